@@ -0,0 +1,66 @@
+package config
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/enr"
+)
+
+// 节点在一个IP协议族上的地址
+type Endpoint struct {
+	IP  net.IP
+	UDP int
+	TCP int
+}
+
+func (e *Endpoint) UDPAddr() string {
+	return net.JoinHostPort(e.IP.String(), strconv.Itoa(e.UDP))
+}
+
+func (e *Endpoint) TCPAddr() string {
+	return net.JoinHostPort(e.IP.String(), strconv.Itoa(e.TCP))
+}
+
+// 读取节点记录中的IPv4和IPv6地址，不存在的返回nil
+// IPv6地址优先使用udp6和tcp6中的端口，没有的话与IPv4共用udp和tcp端口
+// 通过enode链接创建的IPv6节点，ip6与udp和tcp保存在一起
+func Endpoints(n *enode.Node) (v4, v6 *Endpoint) {
+	var (
+		udp  enr.UDP
+		tcp  enr.TCP
+		udp6 enr.UDP6
+		tcp6 enr.TCP6
+		ip4  enr.IPv4
+		ip6  enr.IPv6
+	)
+	n.Load(&udp)
+	n.Load(&tcp)
+	if n.Load(&ip4) == nil {
+		v4 = &Endpoint{IP: net.IP(ip4), UDP: int(udp), TCP: int(tcp)}
+	}
+	if n.Load(&ip6) == nil {
+		v6 = &Endpoint{IP: net.IP(ip6), UDP: int(udp), TCP: int(tcp)}
+		if n.Load(&udp6) == nil {
+			v6.UDP = int(udp6)
+		}
+		if n.Load(&tcp6) == nil {
+			v6.TCP = int(tcp6)
+		}
+	}
+	return v4, v6
+}
+
+// 返回可以通过UDP查询的节点
+// 只有ip6和udp6的记录，Node.UDP读取不到端口，使用IPv6地址重新构造节点
+func Dialable(n *enode.Node) *enode.Node {
+	if n.IP() != nil && n.UDP() != 0 {
+		return n
+	}
+	_, v6 := Endpoints(n)
+	if v6 == nil || v6.UDP == 0 || n.Pubkey() == nil {
+		return n
+	}
+	return enode.NewV4(n.Pubkey(), v6.IP, v6.TCP, v6.UDP)
+}
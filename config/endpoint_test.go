@@ -0,0 +1,47 @@
+package config
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/enr"
+)
+
+func TestEndpoints(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	ip6 := net.ParseIP("2001:db8::1")
+
+	// 双栈节点，IPv6使用单独的端口
+	var r enr.Record
+	r.Set(enr.IPv4{1, 2, 3, 4})
+	r.Set(enr.UDP(30303))
+	r.Set(enr.TCP(30303))
+	r.Set(enr.IPv6(ip6))
+	r.Set(enr.UDP6(30304))
+	enode.SignV4(&r, key)
+	n, _ := enode.New(enode.ValidSchemes, &r)
+	v4, v6 := Endpoints(n)
+	if v4 == nil || v4.UDP != 30303 || v6 == nil || v6.UDP != 30304 || v6.TCP != 30303 {
+		t.Fatalf("wrong dual stack endpoints: %v %v", v4, v6)
+	}
+
+	// 只有ip6和udp6的节点需要重新构造才能查询
+	var r6 enr.Record
+	r6.Set(enr.IPv6(ip6))
+	r6.Set(enr.UDP6(30305))
+	enode.SignV4(&r6, key)
+	n6, _ := enode.New(enode.ValidSchemes, &r6)
+	if n6.UDP() != 0 {
+		t.Fatal("udp6 should not be loaded as udp")
+	}
+	d := Dialable(n6)
+	if !d.IP().Equal(ip6) || d.UDP() != 30305 {
+		t.Fatalf("wrong dialable node %s", d.URLv4())
+	}
+	if !strings.HasSuffix(d.URLv4(), "@[2001:db8::1]:0?discport=30305") {
+		t.Fatalf("wrong url %s", d.URLv4())
+	}
+}
@@ -1,7 +1,6 @@
 package config
 
 import (
-	"fmt"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/p2p/enode"
@@ -9,5 +8,11 @@ import (
 
 func TestBlack(t *testing.T) {
 	node := enode.MustParseV4("enode://6f04d3be3ccc7fabc1e216d6f85be945e991ee9948204e2597b29c74ca334993ccf6303e9209ce52d1b73b0b7a168efb9c11284c281c75aa852b1f73895556d8@94.79.55.28:30000")
-	fmt.Println(Reject(node))
+	if !Reject(node) {
+		t.Error("node in the ip black list should be rejected")
+	}
+	other := enode.MustParseV4("enode://6f04d3be3ccc7fabc1e216d6f85be945e991ee9948204e2597b29c74ca334993ccf6303e9209ce52d1b73b0b7a168efb9c11284c281c75aa852b1f73895556d8@94.79.55.29:30000")
+	if Reject(other) {
+		t.Error("other nodes should not be rejected")
+	}
 }
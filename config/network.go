@@ -0,0 +1,221 @@
+package config
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/forkid"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// 按照enr中eth条目的分叉ID(EIP-2124)判断节点属于哪条链
+// 依赖的go-ethereum版本较旧，没有之后的分叉，所以这里自己记录每条链所有分叉的区块高度和时间戳
+// Networks中的链在整个进程中共用，分叉列表不会改变
+// 之后的分叉在Copy得到的本次运行的副本上添加，可以通过Extend添加，也会从多个节点公布的下一个分叉中学习，见Match
+type Network struct {
+	Name    string
+	genesis common.Hash // 创世区块哈希，自定义的链为空
+	lock    sync.RWMutex
+	hashes  map[[4]byte]struct{} // 这条链历史上所有的分叉哈希
+	hash    uint32               // 最后一个已知分叉的哈希
+	last    uint64               // 最后一个已知分叉的区块高度或时间戳
+	// 停在最后一个已知分叉的节点公布的下一个分叉，以及公布这个分叉的节点IP
+	// nil代表共用的链，不能添加分叉
+	proposals map[uint64]map[string]struct{}
+}
+
+// 有这么多个不同IP的节点公布了相同的下一个分叉才学习这个分叉
+const learnQuorum = 3
+
+// 使用创世区块和按顺序排列的分叉区块高度或时间戳计算每个阶段的分叉哈希
+func NewNetwork(name string, genesis common.Hash, forks []uint64) *Network {
+	n := &Network{Name: name, genesis: genesis, hashes: make(map[[4]byte]struct{})}
+	n.hash = crc32.ChecksumIEEE(genesis[:])
+	n.add(n.hash)
+	for _, fork := range forks {
+		n.extend(fork)
+	}
+	return n
+}
+
+// 本次运行使用的副本，可以添加之后的分叉，不影响共用的链
+func (n *Network) Copy() *Network {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	c := &Network{
+		Name:      n.Name,
+		genesis:   n.genesis,
+		hashes:    make(map[[4]byte]struct{}, len(n.hashes)),
+		hash:      n.hash,
+		last:      n.last,
+		proposals: make(map[uint64]map[string]struct{}),
+	}
+	for h := range n.hashes {
+		c.hashes[h] = struct{}{}
+	}
+	return c
+}
+
+// 在最后一个已知分叉之后按顺序添加分叉，不晚于最后一个已知分叉的会被跳过
+// 同一个高度的多个分叉只计算一次，高度为0的分叉包含在创世区块中
+// 只能用于Copy得到的副本
+func (n *Network) Extend(forks ...uint64) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if n.proposals == nil {
+		panic("extending shared network " + n.Name)
+	}
+	for _, fork := range forks {
+		n.extend(fork)
+	}
+}
+
+func (n *Network) extend(fork uint64) {
+	if fork <= n.last {
+		return
+	}
+	var blob [8]byte
+	binary.BigEndian.PutUint64(blob[:], fork)
+	n.hash = crc32.Update(n.hash, crc32.IEEETable, blob[:])
+	n.add(n.hash)
+	n.last = fork
+}
+
+// 使用指定的分叉哈希列表，每个哈希是4字节的十六进制
+func NewCustomNetwork(hashes []string) (*Network, error) {
+	n := &Network{Name: "custom", hashes: make(map[[4]byte]struct{})}
+	for _, s := range hashes {
+		b, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+		if err != nil || len(b) != 4 {
+			return nil, fmt.Errorf("invalid fork hash %s", s)
+		}
+		var h [4]byte
+		copy(h[:], b)
+		n.hashes[h] = struct{}{}
+	}
+	if len(n.hashes) == 0 {
+		return nil, fmt.Errorf("custom network needs at least one fork hash")
+	}
+	return n, nil
+}
+
+func (n *Network) add(hash uint32) {
+	var h [4]byte
+	binary.BigEndian.PutUint32(h[:], hash)
+	n.hashes[h] = struct{}{}
+}
+
+// 可以选择的链，在整个进程中共用，爬取时使用Copy得到的副本
+var Networks = map[string]*Network{
+	"mainnet": NewNetwork("mainnet", params.MainnetGenesisHash, []uint64{
+		1150000, 1920000, 2463000, 2675000, 4370000, 7280000, 7280000, 9069000, 9200000,
+		12244000, 12965000, 13773000, 15050000,
+		1681338455, 1710338135, 1746612311,
+		1764798551, 1765290071, 1767747671,
+	}),
+	"goerli": NewNetwork("goerli", params.GoerliGenesisHash, []uint64{
+		1561651, 4460644, 5062605,
+		1678832736, 1705473120,
+	}),
+	"sepolia": NewNetwork("sepolia", params.SepoliaGenesisHash, []uint64{
+		1735371,
+		1677557088, 1706655072, 1741159776,
+		1760427360, 1761017184, 1761607008,
+	}),
+}
+
+// 按照分叉ID找到对应的链，不认识的分叉ID返回空字符串
+// 创世区块相同的链（例如以太坊经典和主网）分叉哈希不同，不会被当成同一条链
+func ForkNetwork(id forkid.ID) string {
+	names := make([]string, 0, len(Networks))
+	for name := range Networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if Networks[name].MatchID(id) {
+			return name
+		}
+	}
+	return ""
+}
+
+// enr中的eth条目，与go-ethereum的eth/protocols/eth中的定义一致
+type ethEntry struct {
+	ForkID forkid.ID
+	Rest   []rlp.RawValue `rlp:"tail"`
+}
+
+func (e ethEntry) ENRKey() string {
+	return "eth"
+}
+
+// 读取节点enr中的分叉ID，没有eth条目返回false
+func ForkID(n *enode.Node) (forkid.ID, bool) {
+	var entry ethEntry
+	if n.Record() == nil || n.Load(&entry) != nil {
+		return forkid.ID{}, false
+	}
+	return entry.ForkID, true
+}
+
+// 节点是否属于这条链，不属于时返回原因，副本同时从节点公布的下一个分叉中学习
+func (n *Network) Match(node *enode.Node) (bool, string) {
+	id, ok := ForkID(node)
+	if !ok {
+		return false, "no eth entry"
+	}
+	n.learn(id, node)
+	if !n.MatchID(id) {
+		return false, fmt.Sprintf("fork hash %x next %d", id.Hash, id.Next)
+	}
+	return true, ""
+}
+
+// 分叉ID是否属于这条链
+// 已知的分叉哈希都接受，爬虫没有自己的链头，不按照EIP-2124比较下一个分叉
+func (n *Network) MatchID(id forkid.ID) bool {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	_, known := n.hashes[id.Hash]
+	return known
+}
+
+// 节点停在最后一个已知分叉并且公布了之后的分叉时，说明这条链可能还有之后的分叉
+// 单个节点公布的分叉可能是伪造的，learnQuorum个不同IP的节点公布了相同的分叉之后，
+// 才按照EIP-2124的方法计算出之后的分叉哈希，已经升级的节点公布的未知哈希就可以被接受
+func (n *Network) learn(id forkid.ID, node *enode.Node) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if n.proposals == nil || n.genesis == (common.Hash{}) || node.IP() == nil {
+		return
+	}
+	if id.Hash != n.lastHash() || id.Next <= n.last {
+		return
+	}
+	ips, ok := n.proposals[id.Next]
+	if !ok {
+		ips = make(map[string]struct{})
+		n.proposals[id.Next] = ips
+	}
+	ips[node.IP().String()] = struct{}{}
+	if len(ips) >= learnQuorum {
+		n.extend(id.Next)
+		// 之前的公布都来自停在上一个分叉的节点
+		n.proposals = make(map[uint64]map[string]struct{})
+	}
+}
+
+func (n *Network) lastHash() [4]byte {
+	var h [4]byte
+	binary.BigEndian.PutUint32(h[:], n.hash)
+	return h
+}
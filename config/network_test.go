@@ -0,0 +1,184 @@
+package config
+
+import (
+	"net"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/forkid"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/enr"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// 依赖的go-ethereum中已有的分叉应该计算出相同的哈希
+func TestNetworkHashes(t *testing.T) {
+	cases := []struct {
+		network string
+		config  *params.ChainConfig
+		genesis common.Hash
+		head    uint64
+	}{
+		{"mainnet", params.MainnetChainConfig, params.MainnetGenesisHash, 0},
+		{"mainnet", params.MainnetChainConfig, params.MainnetGenesisHash, 9069000},
+		{"mainnet", params.MainnetChainConfig, params.MainnetGenesisHash, 13773000},
+		{"goerli", params.GoerliChainConfig, params.GoerliGenesisHash, 5062605},
+		{"sepolia", params.SepoliaChainConfig, params.SepoliaGenesisHash, 0},
+	}
+	for _, c := range cases {
+		n := Networks[c.network]
+		id := forkid.NewID(c.config, c.genesis, c.head)
+		if _, ok := n.hashes[id.Hash]; !ok {
+			t.Errorf("%s at %d: missing fork hash %x", c.network, c.head, id.Hash)
+		}
+	}
+	// 之后的分叉
+	if _, ok := Networks["mainnet"].hashes[[4]byte{0xdc, 0xe9, 0x6c, 0x2d}]; !ok {
+		t.Error("mainnet shanghai fork hash missing")
+	}
+}
+
+func TestNetworkMatch(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	node := func(id *forkid.ID) *enode.Node {
+		var r enr.Record
+		r.Set(enr.IP(net.IPv4(1, 2, 3, 4)))
+		r.Set(enr.UDP(30303))
+		if id != nil {
+			r.Set(ethEntry{ForkID: *id})
+		}
+		if err := enode.SignV4(&r, key); err != nil {
+			t.Fatal(err)
+		}
+		n, err := enode.New(enode.ValidSchemes, &r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	mainnet := Networks["mainnet"]
+	if ok, _ := mainnet.Match(node(&forkid.ID{Hash: [4]byte{0xdc, 0xe9, 0x6c, 0x2d}, Next: 1710338135})); !ok {
+		t.Error("mainnet node should match")
+	}
+	goerli := forkid.NewID(params.GoerliChainConfig, params.GoerliGenesisHash, 6000000)
+	if ok, _ := mainnet.Match(node(&goerli)); ok {
+		t.Error("goerli node should not match mainnet")
+	}
+	if ok, reason := mainnet.Match(node(nil)); ok || reason != "no eth entry" {
+		t.Error("node without eth entry should not match")
+	}
+	custom, err := NewCustomNetwork([]string{"0xb8c6299d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := custom.Match(node(&goerli)); !ok {
+		t.Error("custom network should match the given fork hash")
+	}
+	if _, err := NewCustomNetwork([]string{"xyz"}); err == nil {
+		t.Error("invalid fork hash should be rejected")
+	}
+	// 当前主网节点的分叉ID，BPO2之后还没有计划的分叉
+	if ok, reason := mainnet.Match(node(&forkid.ID{Hash: [4]byte{0x07, 0xc9, 0x46, 0x2e}})); !ok {
+		t.Error("current mainnet node should match:", reason)
+	}
+}
+
+// 分叉列表停在Prague时，从停在Prague的多个节点公布的下一个分叉中学习之后的分叉哈希
+func TestNetworkLearn(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	node := func(id forkid.ID, ip byte) *enode.Node {
+		var r enr.Record
+		r.Set(enr.IP(net.IPv4(10, 0, 0, ip)))
+		r.Set(ethEntry{ForkID: id})
+		if err := enode.SignV4(&r, key); err != nil {
+			t.Fatal(err)
+		}
+		n, err := enode.New(enode.ValidSchemes, &r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	prague := NewNetwork("mainnet", params.MainnetGenesisHash, []uint64{
+		1150000, 1920000, 2463000, 2675000, 4370000, 7280000, 7280000, 9069000, 9200000,
+		12244000, 12965000, 13773000, 15050000,
+		1681338455, 1710338135, 1746612311,
+	}).Copy()
+	osaka := forkid.ID{Hash: [4]byte{0x51, 0x67, 0xe2, 0xa6}, Next: 1765290071}
+	announce := forkid.ID{Hash: [4]byte{0xc3, 0x76, 0xcf, 0x8b}, Next: 1764798551}
+	// 同一个IP多次公布只算一次
+	for i := 0; i < learnQuorum; i++ {
+		if ok, _ := prague.Match(node(announce, 1)); !ok {
+			t.Fatal("prague node should match")
+		}
+	}
+	if ok, _ := prague.Match(node(osaka, 100)); ok {
+		t.Fatal("fork announced by a single ip should not be learned")
+	}
+	// 还没有升级的多个节点公布了Osaka的时间
+	for i := 2; i <= learnQuorum; i++ {
+		prague.Match(node(announce, byte(i)))
+	}
+	if ok, reason := prague.Match(node(osaka, 100)); !ok {
+		t.Fatal("osaka node should match after learning:", reason)
+	}
+	// 之后的分叉继续从多个Osaka节点公布的下一个分叉中学习
+	for i := 1; i <= learnQuorum; i++ {
+		prague.Match(node(osaka, byte(i)))
+	}
+	if ok, _ := prague.Match(node(forkid.ID{Hash: [4]byte{0xcb, 0xa2, 0xa1, 0xc0}}, 100)); !ok {
+		t.Error("bpo1 node should match after learning")
+	}
+	// 通过命令行添加的分叉
+	prague.Extend(1767747671)
+	if ok, _ := prague.Match(node(forkid.ID{Hash: [4]byte{0x07, 0xc9, 0x46, 0x2e}}, 100)); !ok {
+		t.Error("bpo2 node should match after extending")
+	}
+	if ok, _ := prague.Match(node(forkid.ID{Hash: [4]byte{1, 2, 3, 4}}, 100)); ok {
+		t.Error("unrelated fork hash should not match")
+	}
+}
+
+// 共用的链不从节点公布的分叉中学习
+func TestNetworkShared(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	mainnet := Networks["mainnet"]
+	next := mainnet.last + 1000
+	for i := 1; i <= learnQuorum; i++ {
+		var r enr.Record
+		r.Set(enr.IP(net.IPv4(10, 0, 0, byte(i))))
+		r.Set(ethEntry{ForkID: forkid.ID{Hash: mainnet.lastHash(), Next: next}})
+		if err := enode.SignV4(&r, key); err != nil {
+			t.Fatal(err)
+		}
+		n, err := enode.New(enode.ValidSchemes, &r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mainnet.Match(n)
+	}
+	if mainnet.last == next {
+		t.Fatal("shared network should not learn forks")
+	}
+	copied := mainnet.Copy()
+	copied.Extend(next)
+	if mainnet.last == next || copied.last != next {
+		t.Fatal("extending a copy should not change the shared network")
+	}
+}
+
+// 以太坊经典与主网的创世区块相同，按照分叉ID区分
+func TestForkNetwork(t *testing.T) {
+	if got := ForkNetwork(forkid.ID{Hash: [4]byte{0x07, 0xc9, 0x46, 0x2e}}); got != "mainnet" {
+		t.Errorf("got %q for mainnet", got)
+	}
+	if got := ForkNetwork(forkid.NewID(params.GoerliChainConfig, params.GoerliGenesisHash, 6000000)); got != "goerli" {
+		t.Errorf("got %q for goerli", got)
+	}
+	// 以太坊经典没有DAO分叉，在2500000的分叉之后与主网的分叉哈希不同
+	classic := NewNetwork("classic", params.MainnetGenesisHash, []uint64{1150000, 2500000})
+	if got := ForkNetwork(forkid.ID{Hash: classic.lastHash()}); got != "" {
+		t.Errorf("got %q for classic", got)
+	}
+}
@@ -2,21 +2,111 @@ package config
 
 import (
 	"crypto/ecdsa"
+	"fmt"
+	"os"
+	"path"
+	"sync"
 
-	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
 )
 
-var PrivateKey = genPriv()
-
 // 用于初始化各种map的初始大小
 const NodeCount = 800000
 
-func genPriv() *ecdsa.PrivateKey {
-	priv, err := crypto.ToECDSA(common.FromHex("51e33445e18afc55f9c76a2640538204940abebc9704823052aac6c7275923db"))
-	// priv, err := crypto.GenerateKey()
+// 每个协议在内存中最多保存的等待查询的节点个数，超过的节点之后从数据库补充
+var QueueLimit = 100000
+
+var (
+	nodeKey     *ecdsa.PrivateKey
+	nodeKeyLock sync.Mutex
+)
+
+// 返回爬虫使用的节点私钥
+// 第一次运行时生成新的私钥并保存在数据目录中，之后一直使用保存的私钥
+func NodeKey() *ecdsa.PrivateKey {
+	nodeKeyLock.Lock()
+	defer nodeKeyLock.Unlock()
+	if nodeKey != nil {
+		return nodeKey
+	}
+	priv, err := crypto.LoadECDSA(KeyPath)
 	if err != nil {
-		panic(err)
+		if !os.IsNotExist(err) {
+			panic(err)
+		}
+		priv, err = generateNodeKey()
+		if err != nil {
+			panic(err)
+		}
+	}
+	nodeKey = priv
+	return nodeKey
+}
+
+// 返回多个身份使用的私钥，第一个是NodeKey
+// 其余的私钥保存在nodekey.<序号>文件中，不存在时生成
+func NodeKeys(n int) []*ecdsa.PrivateKey {
+	keys := []*ecdsa.PrivateKey{NodeKey()}
+	for i := 1; i < n; i++ {
+		file := fmt.Sprintf("%s.%d", KeyPath, i)
+		priv, err := crypto.LoadECDSA(file)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				panic(err)
+			}
+			if priv, err = crypto.GenerateKey(); err != nil {
+				panic(err)
+			}
+			if err := crypto.SaveECDSA(file, priv); err != nil {
+				panic(err)
+			}
+		}
+		keys = append(keys, priv)
+	}
+	return keys
+}
+
+// 生成新的私钥替换之前的私钥
+func RotateNodeKey() (*ecdsa.PrivateKey, error) {
+	nodeKeyLock.Lock()
+	defer nodeKeyLock.Unlock()
+	priv, err := generateNodeKey()
+	if err != nil {
+		return nil, err
+	}
+	nodeKey = priv
+	return priv, nil
+}
+
+// 导入私钥文件，文件格式与geth的nodekey相同，是十六进制编码的私钥
+func ImportNodeKey(file string) (*ecdsa.PrivateKey, error) {
+	nodeKeyLock.Lock()
+	defer nodeKeyLock.Unlock()
+	priv, err := crypto.LoadECDSA(file)
+	if err != nil {
+		return nil, err
+	}
+	if err := saveNodeKey(priv); err != nil {
+		return nil, err
+	}
+	nodeKey = priv
+	return priv, nil
+}
+
+func generateNodeKey() (*ecdsa.PrivateKey, error) {
+	priv, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := saveNodeKey(priv); err != nil {
+		return nil, err
+	}
+	return priv, nil
+}
+
+func saveNodeKey(priv *ecdsa.PrivateKey) error {
+	if err := os.MkdirAll(path.Dir(KeyPath), 0777); err != nil {
+		return err
 	}
-	return priv
+	return crypto.SaveECDSA(KeyPath, priv)
 }
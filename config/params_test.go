@@ -0,0 +1,37 @@
+package config
+
+import (
+	"path"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestNodeKey(t *testing.T) {
+	BasePath = t.TempDir()
+	KeyPath = path.Join(BasePath, "nodekey")
+	nodeKey = nil
+
+	priv := NodeKey()
+	// 重新从文件加载得到相同的私钥
+	nodeKey = nil
+	if !NodeKey().Equal(priv) {
+		t.Fatal("node key should be persisted")
+	}
+
+	rotated, err := RotateNodeKey()
+	if err != nil || rotated.Equal(priv) || !NodeKey().Equal(rotated) {
+		t.Fatal("node key should be rotated")
+	}
+
+	file := path.Join(BasePath, "import")
+	crypto.SaveECDSA(file, priv)
+	imported, err := ImportNodeKey(file)
+	if err != nil || !imported.Equal(priv) {
+		t.Fatal("wrong imported key")
+	}
+	nodeKey = nil
+	if !NodeKey().Equal(priv) {
+		t.Fatal("imported key should be persisted")
+	}
+}
@@ -12,6 +12,7 @@ import (
 var BasePath string = path.Join(GetCurrentAbPath(), "data")
 var RpcPath string = path.Join(BasePath, "query.ipc")
 var DBPath string = path.Join(BasePath, "storagedb")
+var KeyPath string = path.Join(BasePath, "nodekey")
 
 // 最终方案-全兼容
 func GetCurrentAbPath() string {
@@ -0,0 +1,46 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// 收到停止信号后等待正在运行的查询结束的最长时间
+var ShutdownTimeout = time.Second * 30
+
+// 监听SIGINT和SIGTERM信号
+// 第一次收到信号关闭返回的管道，通知停止调度新的查询
+// 第二次收到信号直接强制退出
+func WatchSignals() <-chan struct{} {
+	stop := make(chan struct{})
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		fmt.Printf("received %v, shutting down, interrupt again to force exit\n", sig)
+		close(stop)
+		<-sigs
+		fmt.Println("force exit")
+		os.Exit(1)
+	}()
+	return stop
+}
+
+// 等待所有协程结束，超时返回false
+func WaitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"net"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// 是否拒绝查询被标记为女巫攻击的节点，开启后启动时从数据库加载最近一次保存的分析结果
+var RejectSybil bool
+
+// 节点ID的前bits位等于prefix的前bits位
+type IDPrefix struct {
+	Prefix []byte
+	Bits   int
+}
+
+func (p IDPrefix) Match(id enode.ID) bool {
+	for i := 0; i < p.Bits; i += 8 {
+		mask := byte(0xff)
+		if p.Bits-i < 8 {
+			mask <<= 8 - (p.Bits - i)
+		}
+		if (id[i/8]^p.Prefix[i/8])&mask != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+var (
+	sybilLock     sync.RWMutex
+	sybilIPs      map[string]struct{}
+	sybilPrefixes []IDPrefix
+)
+
+// 设置被标记的IP和节点ID前缀
+func SetSybil(ips []net.IP, prefixes []IDPrefix) {
+	sybilLock.Lock()
+	defer sybilLock.Unlock()
+	sybilIPs = make(map[string]struct{}, len(ips))
+	for _, ip := range ips {
+		sybilIPs[ip.String()] = struct{}{}
+	}
+	sybilPrefixes = prefixes
+}
+
+// 节点是否属于被标记的集群
+func IsSybil(n *enode.Node) bool {
+	sybilLock.RLock()
+	defer sybilLock.RUnlock()
+	if n.IP() != nil {
+		if _, ok := sybilIPs[n.IP().String()]; ok {
+			return true
+		}
+	}
+	id := n.ID()
+	for _, p := range sybilPrefixes {
+		if p.Match(id) {
+			return true
+		}
+	}
+	return false
+}
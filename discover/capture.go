@@ -0,0 +1,265 @@
+package discover
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/discover/v4wire"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// 抓包文件以魔数和版本号开头，之后是连续的记录
+var captureMagic = []byte("NHCAP")
+
+const captureVersion = 1
+
+// 缓冲的记录写入文件的时间间隔，进程被杀掉时最多丢失这段时间的数据包
+const captureFlushInterval = time.Second
+
+var errCaptureFormat = errors.New("not a capture file")
+
+// 抓包文件中的一条记录
+// 格式：<时间戳纳秒 8字节><方向 1字节><协议 1字节><地址长度 2字节><地址><数据包长度 4字节><数据包>
+type CaptureRecord struct {
+	Time   time.Time
+	Sent   bool // true为发送，false为接收
+	Proto  byte // 4或者5
+	Addr   string
+	Packet []byte
+}
+
+// 把连接上收发的所有数据包写入抓包文件
+type Capture struct {
+	lock sync.Mutex
+	f    *os.File
+	w    *bufio.Writer
+	err  error
+	done chan struct{}
+}
+
+// 创建文件并写入文件头，之后每隔captureFlushInterval把缓冲的记录写入文件
+func NewCapture(file string) (*Capture, error) {
+	f, err := os.Create(file)
+	if err != nil {
+		return nil, err
+	}
+	c := &Capture{f: f, w: bufio.NewWriter(f), done: make(chan struct{})}
+	c.w.Write(captureMagic)
+	c.w.WriteByte(captureVersion)
+	if err := c.w.Flush(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	go c.loop()
+	return c, nil
+}
+
+func (c *Capture) loop() {
+	ticker := time.NewTicker(captureFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.flush()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *Capture) flush() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.err != nil || c.w == nil {
+		return
+	}
+	if err := c.w.Flush(); err != nil {
+		c.err = err
+	}
+}
+
+// 记录一个连接收发的数据包，proto为连接使用的协议版本
+func (c *Capture) Watch(conn *Conn, proto byte) {
+	conn.OnRead(func(b []byte, addr *net.UDPAddr) {
+		c.write(false, proto, b, addr)
+	})
+	conn.OnWrite(func(b []byte, addr *net.UDPAddr) {
+		c.write(true, proto, b, addr)
+	})
+}
+
+func (c *Capture) write(sent bool, proto byte, b []byte, addr *net.UDPAddr) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	// 出错后不再写入，关闭时返回错误
+	if c.err != nil || c.w == nil {
+		return
+	}
+	a := addr.String()
+	head := make([]byte, 12, 12+len(a)+4)
+	binary.BigEndian.PutUint64(head, uint64(time.Now().UnixNano()))
+	if sent {
+		head[8] = 's'
+	} else {
+		head[8] = 'r'
+	}
+	head[9] = proto
+	binary.BigEndian.PutUint16(head[10:], uint16(len(a)))
+	head = append(head, a...)
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(b)))
+	head = append(head, size[:]...)
+	if _, err := c.w.Write(head); err != nil {
+		c.err = err
+		return
+	}
+	if _, err := c.w.Write(b); err != nil {
+		c.err = err
+	}
+}
+
+// 关闭后的数据包不再记录，需要在连接关闭之后调用
+func (c *Capture) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.w == nil {
+		return c.err
+	}
+	close(c.done)
+	if err := c.w.Flush(); err != nil && c.err == nil {
+		c.err = err
+	}
+	if err := c.f.Close(); err != nil && c.err == nil {
+		c.err = err
+	}
+	c.w = nil
+	return c.err
+}
+
+// 依次读取抓包文件中的记录，fn返回false时停止
+// 进程被杀掉时最后一条记录可能只写入了一部分，读到不完整的记录时结束
+func ReadCapture(r io.Reader, fn func(rec *CaptureRecord) bool) error {
+	br := bufio.NewReader(r)
+	head := make([]byte, len(captureMagic)+1)
+	if _, err := io.ReadFull(br, head); err != nil || string(head[:len(captureMagic)]) != string(captureMagic) {
+		return errCaptureFormat
+	}
+	if v := head[len(captureMagic)]; v != captureVersion {
+		return fmt.Errorf("unsupported capture version %d", v)
+	}
+	head = make([]byte, 12)
+	for {
+		if err := readCapture(br, head, fn); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// 读取一条记录，fn返回false时返回io.EOF
+func readCapture(br *bufio.Reader, head []byte, fn func(rec *CaptureRecord) bool) error {
+	if _, err := io.ReadFull(br, head); err != nil {
+		return err
+	}
+	rec := &CaptureRecord{
+		Time:  time.Unix(0, int64(binary.BigEndian.Uint64(head))),
+		Sent:  head[8] == 's',
+		Proto: head[9],
+	}
+	addr := make([]byte, binary.BigEndian.Uint16(head[10:]))
+	if _, err := io.ReadFull(br, addr); err != nil {
+		return err
+	}
+	rec.Addr = string(addr)
+	var size [4]byte
+	if _, err := io.ReadFull(br, size[:]); err != nil {
+		return err
+	}
+	rec.Packet = make([]byte, binary.BigEndian.Uint32(size[:]))
+	if _, err := io.ReadFull(br, rec.Packet); err != nil {
+		return err
+	}
+	if !fn(rec) {
+		return io.EOF
+	}
+	return nil
+}
+
+// 解码后的一个事件
+type CaptureEvent struct {
+	Time   time.Time
+	Sent   bool
+	Addr   string
+	Kind   string   // ping、pong、findnode、neighbors、enrrequest、enrresponse
+	Sender enode.ID // 数据包签名者的节点ID
+	Detail string
+}
+
+var errV5Packet = errors.New("discv5 packets are encrypted and can not be decoded offline")
+
+// 解码v4协议的数据包，v5协议的数据包需要会话密钥，无法离线解码
+func (rec *CaptureRecord) Decode() (*CaptureEvent, error) {
+	if rec.Proto != 4 {
+		return nil, errV5Packet
+	}
+	packet, key, hash, err := v4wire.Decode(rec.Packet)
+	if err != nil {
+		return nil, err
+	}
+	ev := &CaptureEvent{Time: rec.Time, Sent: rec.Sent, Addr: rec.Addr, Sender: key.ID()}
+	switch p := packet.(type) {
+	case *v4wire.Ping:
+		ev.Kind = "ping"
+		ev.Detail = fmt.Sprintf("hash=%x version=%d from=%v:%d to=%v:%d enrseq=%d", shortToken(hash), p.Version, p.From.IP, p.From.UDP, p.To.IP, p.To.UDP, p.ENRSeq)
+	case *v4wire.Pong:
+		ev.Kind = "pong"
+		ev.Detail = fmt.Sprintf("to=%v:%d reply=%x enrseq=%d", p.To.IP, p.To.UDP, shortToken(p.ReplyTok), p.ENRSeq)
+	case *v4wire.Findnode:
+		ev.Kind = "findnode"
+		// 远程节点按照目标公钥的哈希查找
+		ev.Detail = fmt.Sprintf("target=%x", crypto.Keccak256(p.Target[:]))
+	case *v4wire.Neighbors:
+		ev.Kind = "neighbors"
+		nodes := make([]string, 0, len(p.Nodes))
+		for _, n := range p.Nodes {
+			nodes = append(nodes, fmt.Sprintf("%x@%v:%d", n.ID.ID().Bytes()[:8], n.IP, n.UDP))
+		}
+		ev.Detail = fmt.Sprintf("count=%d nodes=%s", len(p.Nodes), strings.Join(nodes, ","))
+	case *v4wire.ENRRequest:
+		ev.Kind = "enrrequest"
+		ev.Detail = fmt.Sprintf("hash=%x", shortToken(hash))
+	case *v4wire.ENRResponse:
+		ev.Kind = "enrresponse"
+		ev.Detail = fmt.Sprintf("reply=%x seq=%d", shortToken(p.ReplyTok), p.Record.Seq())
+	default:
+		ev.Kind = "unknown"
+	}
+	return ev, nil
+}
+
+// 请求的哈希和回复中的请求哈希只显示前4个字节，用于把回复和请求对应起来
+func shortToken(b []byte) []byte {
+	if len(b) > 4 {
+		return b[:4]
+	}
+	return b
+}
+
+func (ev *CaptureEvent) String() string {
+	dir := "<-"
+	if ev.Sent {
+		dir = "->"
+	}
+	return fmt.Sprintf("%s %s %s %s sender=%x %s", ev.Time.Format("15:04:05.000000"), dir, ev.Addr, ev.Kind, ev.Sender[:8], ev.Detail)
+}
@@ -0,0 +1,122 @@
+package discover
+
+import (
+	"bytes"
+	"net"
+	"node_hunter/config"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// a ping b并查询b的enr，抓包后离线解码
+func TestCaptureReplay(t *testing.T) {
+	config.BasePath = t.TempDir()
+	file := path.Join(config.BasePath, "capture")
+	privA, _ := crypto.GenerateKey()
+	privB, _ := crypto.GenerateKey()
+	a, connA := initV4(0, privA, "db.a")
+	b, connB := initV4(0, privB, "db.b")
+	defer b.Close()
+	c, err := NewCapture(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Watch(connA, 4)
+
+	addr := &net.UDPAddr{IP: net.IP{127, 0, 0, 1}, Port: connB.LocalAddr().(*net.UDPAddr).Port}
+	remote := enode.NewV4(&privB.PublicKey, addr.IP, 0, addr.Port)
+	if err := a.Ping(remote); err != nil {
+		t.Fatal(err)
+	}
+	// b的记录中没有ip，RequestENR会校验失败，但是请求和回复都已经收发
+	a.RequestENR(remote)
+	a.Close()
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	seen := make(map[string]bool)
+	err = ReadCapture(f, func(rec *CaptureRecord) bool {
+		ev, err := rec.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.Addr != addr.String() {
+			t.Errorf("wrong peer address %s", rec.Addr)
+		}
+		// 发送的数据包由a签名，收到的由b签名
+		want := a.Self().ID()
+		if !ev.Sent {
+			want = remote.ID()
+		}
+		if ev.Sender != want {
+			t.Errorf("wrong sender of %v", ev)
+		}
+		dir := "recv "
+		if ev.Sent {
+			dir = "send "
+		}
+		seen[dir+ev.Kind] = true
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"send ping", "recv pong", "send enrrequest", "recv enrresponse"} {
+		if !seen[k] {
+			t.Errorf("missing %s in %v", k, seen)
+		}
+	}
+}
+
+// 没有关闭时记录也会定期写入文件，不完整的最后一条记录被忽略
+func TestCaptureFlush(t *testing.T) {
+	file := path.Join(t.TempDir(), "capture")
+	c, err := NewCapture(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	addr := &net.UDPAddr{IP: net.IP{127, 0, 0, 1}, Port: 30303}
+	c.write(true, 4, []byte{1, 2, 3}, addr)
+	var data []byte
+	for i := 0; i < 30; i++ {
+		time.Sleep(captureFlushInterval / 10)
+		if data, err = os.ReadFile(file); err != nil {
+			t.Fatal(err)
+		}
+		if len(data) > len(captureMagic)+1 {
+			break
+		}
+	}
+	count := 0
+	read := func(b []byte) error {
+		count = 0
+		return ReadCapture(bytes.NewReader(b), func(rec *CaptureRecord) bool {
+			if rec.Addr != addr.String() || !bytes.Equal(rec.Packet, []byte{1, 2, 3}) {
+				t.Errorf("wrong record %+v", rec)
+			}
+			count++
+			return true
+		})
+	}
+	if err := read(data); err != nil || count != 1 {
+		t.Fatalf("read %d records before close: %v", count, err)
+	}
+	if err := read(data[:len(data)-1]); err != nil || count != 0 {
+		t.Fatalf("truncated record: %d records, %v", count, err)
+	}
+	if err := read(data[len(captureMagic):]); err != errCaptureFormat {
+		t.Fatalf("file without header should be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package discover
+
+import (
+	"net"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// 收发数据包时调用的钩子
+// b在调用结束后会被复用，钩子不能保留它
+type PacketHook func(b []byte, addr *net.UDPAddr)
+
+// 包装节点发现协议使用的UDP连接，收发数据包时调用注册的钩子
+// UDPv4和UDPv5关闭时会关闭连接，连接关闭时同时关闭协议使用的enode.DB
+type Conn struct {
+	discover.UDPConn
+	db         *enode.DB
+	lock       sync.RWMutex
+	readHooks  []PacketHook
+	writeHooks []PacketHook
+}
+
+func NewConn(c discover.UDPConn) *Conn {
+	return &Conn{UDPConn: c}
+}
+
+// 注册收到数据包时调用的钩子
+func (c *Conn) OnRead(h PacketHook) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.readHooks = append(c.readHooks, h)
+}
+
+// 注册发送数据包之前调用的钩子
+func (c *Conn) OnWrite(h PacketHook) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.writeHooks = append(c.writeHooks, h)
+}
+
+// 在UDPv4和UDPv5关闭的过程中调用，之后路由表剩余的写入只会得到数据库已关闭的错误
+func (c *Conn) Close() error {
+	err := c.UDPConn.Close()
+	if c.db != nil {
+		c.db.Close()
+	}
+	return err
+}
+
+func (c *Conn) ReadFromUDP(b []byte) (int, *net.UDPAddr, error) {
+	n, addr, err := c.UDPConn.ReadFromUDP(b)
+	if err == nil {
+		c.lock.RLock()
+		for _, h := range c.readHooks {
+			h(b[:n], addr)
+		}
+		c.lock.RUnlock()
+	}
+	return n, addr, err
+}
+
+func (c *Conn) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	c.lock.RLock()
+	for _, h := range c.writeHooks {
+		h(b, addr)
+	}
+	c.lock.RUnlock()
+	return c.UDPConn.WriteToUDP(b, addr)
+}
+
+// v4协议数据包的头部是32字节的哈希和65字节的签名，之后一个字节是包的类型
+const v4HeadSize = 32 + 65
+
+// 不校验签名，直接读取v4数据包的类型，长度不够返回0
+func v4PacketKind(b []byte) byte {
+	if len(b) <= v4HeadSize {
+		return 0
+	}
+	return b[v4HeadSize]
+}
@@ -0,0 +1,116 @@
+package discover
+
+import (
+	"math"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// 使用标志重捕法(Schnabel估计)估计远程节点路由表的大小
+// 每次FINDNODE返回的节点作为一次捕获，之前见过的节点作为重捕
+// N = Σ(C*M) / (ΣR + 1)，C为本次捕获数，M为本次之前标记的个数，R为本次重捕数
+// ΣR近似服从均值为Σ(C*M)/N的泊松分布，由此得到覆盖率达到要求的置信度
+// FINDNODE返回的是距离目标最近的节点，远处的桶更容易被重复捕获，估计值偏小，置信度偏高
+type estimator struct {
+	lock  sync.Mutex
+	seen  map[enode.ID]struct{}
+	sumCM float64
+	sumR  int
+}
+
+func newEstimator() *estimator {
+	return &estimator{seen: make(map[enode.ID]struct{})}
+}
+
+// 记录一次查询返回的节点
+func (e *estimator) add(rs []*enode.Node) {
+	if len(rs) == 0 {
+		return
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	marked := len(e.seen)
+	sample := make(map[enode.ID]struct{}, len(rs))
+	for _, n := range rs {
+		sample[n.ID()] = struct{}{}
+	}
+	for id := range sample {
+		if _, ok := e.seen[id]; ok {
+			e.sumR++
+		} else {
+			e.seen[id] = struct{}{}
+		}
+	}
+	e.sumCM += float64(len(sample) * marked)
+}
+
+// 已经见过的不同节点个数
+func (e *estimator) distinct() int {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return len(e.seen)
+}
+
+// 估计的路由表大小，没有捕获过节点时返回-1
+func (e *estimator) estimate() float64 {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.estimateLocked()
+}
+
+func (e *estimator) estimateLocked() float64 {
+	if len(e.seen) == 0 {
+		return -1
+	}
+	n := e.sumCM / float64(e.sumR+1)
+	// 估计值不会小于已经见过的个数
+	if n < float64(len(e.seen)) {
+		n = float64(len(e.seen))
+	}
+	return n
+}
+
+// 见过的节点占估计大小的比例，无法估计时返回-1
+func (e *estimator) coverage() float64 {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	n := e.estimateLocked()
+	if n <= 0 {
+		return -1
+	}
+	return float64(len(e.seen)) / n
+}
+
+// 覆盖率不低于target的置信度，即路由表大小不超过seen/target的置信度
+// 路由表大小为seen/target时ΣR的均值为λ=Σ(C*M)*target/seen，更大的路由表重捕更少，
+// 所以置信度为这个均值下重捕少于观测到的ΣR的概率，没有重捕时为0
+func (e *estimator) confidence(target float64) float64 {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.sumR == 0 || target <= 0 {
+		return 0
+	}
+	lambda := e.sumCM * target / float64(len(e.seen))
+	return poissonCDF(e.sumR-1, lambda)
+}
+
+// 均值为lambda的泊松分布不超过k的概率
+func poissonCDF(k int, lambda float64) float64 {
+	if lambda == 0 {
+		return 1
+	}
+	// 在对数空间中累加，避免lambda很大时exp(-lambda)下溢
+	logp := -lambda
+	sum := 0.0
+	for i := 0; i <= k; i++ {
+		if i > 0 {
+			logp += math.Log(lambda / float64(i))
+		}
+		sum += math.Exp(logp)
+	}
+	if sum > 1 {
+		sum = 1
+	}
+	return sum
+}
@@ -0,0 +1,73 @@
+package discover
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/enr"
+)
+
+// 从size个节点中均匀地不重复抽取16个节点，模拟一次捕获
+func sample(rnd *rand.Rand, nodes []*enode.Node) []*enode.Node {
+	rs := make([]*enode.Node, 0, bucketSize)
+	for _, i := range rnd.Perm(len(nodes))[:bucketSize] {
+		rs = append(rs, nodes[i])
+	}
+	return rs
+}
+
+func randomNodes(rnd *rand.Rand, size int) []*enode.Node {
+	nodes := make([]*enode.Node, size)
+	for i := range nodes {
+		var id enode.ID
+		rnd.Read(id[:])
+		nodes[i] = enode.SignNull(new(enr.Record), id)
+	}
+	return nodes
+}
+
+func TestEstimatorUnknown(t *testing.T) {
+	e := newEstimator()
+	if e.estimate() != -1 || e.coverage() != -1 || e.confidence(0.9) != 0 {
+		t.Fatal("estimate should be unknown without queries")
+	}
+}
+
+// 多次捕获之后估计值接近真实大小
+func TestEstimatorSchnabel(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for _, size := range []int{100, 1000} {
+		nodes := randomNodes(rnd, size)
+		e := newEstimator()
+		for q := 0; q < size/2; q++ {
+			e.add(sample(rnd, nodes))
+		}
+		if n := e.estimate(); n < 0.8*float64(size) || n > 1.2*float64(size) {
+			t.Errorf("size=%d: estimate %f", size, n)
+		}
+	}
+}
+
+// 置信度足够时停止，真实的覆盖率达不到要求的次数不超过置信度允许的范围
+func TestEstimatorConfidence(t *testing.T) {
+	rnd := rand.New(rand.NewSource(2))
+	failed := 0
+	trials := 40
+	for trial := 0; trial < trials; trial++ {
+		nodes := randomNodes(rnd, 300)
+		e := newEstimator()
+		for q := 0; q < 1000 && e.confidence(0.9) < 0.95; q++ {
+			e.add(sample(rnd, nodes))
+		}
+		if e.confidence(0.9) < 0.95 {
+			t.Fatalf("not confident after 1000 queries, seen %d", e.distinct())
+		}
+		if float64(e.distinct())/float64(len(nodes)) < 0.9 {
+			failed++
+		}
+	}
+	if failed > trials/10 {
+		t.Errorf("real coverage below target in %d of %d trials", failed, trials)
+	}
+}
@@ -0,0 +1,158 @@
+package discover
+
+import (
+	"crypto/ecdsa"
+	crand "crypto/rand"
+	"math/big"
+	"node_hunter/limit"
+	"node_hunter/storage"
+	_ "unsafe"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// 查询远程节点路由表的接口，v4和v5协议分别实现
+type Finder interface {
+	Protocol() storage.Protocol
+	// 使用的本地身份的序号
+	Identity() int
+	// 查询远程节点路由表中距离随机目标最近的节点
+	FindRandomNode(n *enode.Node) ([]*enode.Node, error)
+	// 查询远程节点路由表中与它的对数距离为dist的桶
+	// dist为MinBucketDistance时查询的是所有更近距离共用的桶
+	FindDistance(n *enode.Node, dist int) ([]*enode.Node, error)
+	RequestENR(n *enode.Node) (*enode.Node, error)
+	// 关闭本地的监听
+	Close()
+}
+
+// 远程节点路由表的桶的划分方式与go-ethereum一致
+// 对数距离从256到241各有一个桶，不超过240的距离共用一个桶
+const (
+	MaxBucketDistance = 256
+	MinBucketDistance = 240
+	Buckets           = MaxBucketDistance - MinBucketDistance + 1
+)
+
+// 发出请求之前等待限速器
+// 不能在写数据包的钩子中等待：v4协议的等待会占用请求的超时时间，还会阻塞读循环中发送的回复，v5协议会阻塞分发线程
+// 回复和v4协议的端点证明不受限制
+type limitedFinder struct {
+	Finder
+	limiter *limit.Limiter
+}
+
+// limiter为nil时直接返回f
+func LimitFinder(f Finder, limiter *limit.Limiter) Finder {
+	if limiter == nil {
+		return f
+	}
+	return &limitedFinder{f, limiter}
+}
+
+func (f *limitedFinder) FindRandomNode(n *enode.Node) ([]*enode.Node, error) {
+	f.limiter.Packet(n.IP())
+	return f.Finder.FindRandomNode(n)
+}
+
+func (f *limitedFinder) FindDistance(n *enode.Node, dist int) ([]*enode.Node, error) {
+	f.limiter.Packet(n.IP())
+	return f.Finder.FindDistance(n, dist)
+}
+
+func (f *limitedFinder) RequestENR(n *enode.Node) (*enode.Node, error) {
+	f.limiter.Packet(n.IP())
+	return f.Finder.RequestENR(n)
+}
+
+type v4Finder struct {
+	*discover.UDPv4
+	identity int
+}
+
+func NewV4Finder(udpv4 *discover.UDPv4, identity int) Finder {
+	return &v4Finder{udpv4, identity}
+}
+
+func (f *v4Finder) Protocol() storage.Protocol {
+	return storage.DiscV4
+}
+
+func (f *v4Finder) Identity() int {
+	return f.identity
+}
+
+// v4协议的FINDNODE使用公钥作为目标，远程节点计算公钥的哈希得到目标节点ID
+// 不断生成随机的目标直到它与远程节点的距离符合要求，远程节点不会校验目标是否是有效的公钥
+func (f *v4Finder) FindDistance(n *enode.Node, dist int) ([]*enode.Node, error) {
+	target := distanceTarget(n.ID(), dist)
+	pub := &ecdsa.PublicKey{
+		X: new(big.Int).SetBytes(target[:32]),
+		Y: new(big.Int).SetBytes(target[32:]),
+	}
+	return f.FindNode(n, pub)
+}
+
+// 生成哈希与id的对数距离为dist的随机目标
+func distanceTarget(id enode.ID, dist int) [64]byte {
+	var target [64]byte
+	for {
+		crand.Read(target[:])
+		d := enode.LogDist(id, enode.ID(crypto.Keccak256Hash(target[:])))
+		if d == dist || (dist == MinBucketDistance && d < dist) {
+			return target
+		}
+	}
+}
+
+type v5Finder struct {
+	*discover.UDPv5
+	identity int
+}
+
+func NewV5Finder(udpv5 *discover.UDPv5, identity int) Finder {
+	return &v5Finder{udpv5, identity}
+}
+
+func (f *v5Finder) Protocol() storage.Protocol {
+	return storage.DiscV5
+}
+
+func (f *v5Finder) Identity() int {
+	return f.identity
+}
+
+// go-ethereum没有导出v5协议对指定节点发送FINDNODE的方法，这里直接链接过去
+//
+//go:linkname v5findnode github.com/ethereum/go-ethereum/p2p/discover.(*UDPv5).findnode
+func v5findnode(t *discover.UDPv5, n *enode.Node, distances []uint) ([]*enode.Node, error)
+
+// v5协议的FINDNODE按照距离查询
+// 与lookup中一样，选取随机目标与远程节点的距离以及相邻的距离，一次最多查询3个距离
+func (f *v5Finder) FindRandomNode(n *enode.Node) ([]*enode.Node, error) {
+	var target enode.ID
+	crand.Read(target[:])
+	td := enode.LogDist(target, n.ID())
+	dists := []uint{uint(td)}
+	for i := 1; len(dists) < 3; i++ {
+		if td+i <= 256 {
+			dists = append(dists, uint(td+i))
+		}
+		if td-i > 0 {
+			dists = append(dists, uint(td-i))
+		}
+	}
+	return v5findnode(f.UDPv5, n, dists)
+}
+
+// v5协议可以直接按照距离查询
+// 最近的桶一次查询3个距离，更近的距离上几乎不可能有节点
+func (f *v5Finder) FindDistance(n *enode.Node, dist int) ([]*enode.Node, error) {
+	dists := []uint{uint(dist)}
+	if dist == MinBucketDistance {
+		dists = append(dists, uint(dist-1), uint(dist-2))
+	}
+	return v5findnode(f.UDPv5, n, dists)
+}
@@ -0,0 +1,84 @@
+package discover
+
+import (
+	crand "crypto/rand"
+	"node_hunter/limit"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/enr"
+)
+
+func TestDistanceTarget(t *testing.T) {
+	node := enode.MustParseV4("enode://8935c9600d925fd46bdf9d1d155ae682c420d75e4546bfd1de4f9cd18c13aab8edd12a1222d34b10113b091f7d95e85e6c985db93086806535a28efd52002109@175.214.58.105:30303")
+	for d := MaxBucketDistance; d >= MinBucketDistance; d-- {
+		target := distanceTarget(node.ID(), d)
+		got := enode.LogDist(node.ID(), enode.ID(crypto.Keccak256Hash(target[:])))
+		if got != d && !(d == MinBucketDistance && got < d) {
+			t.Fatalf("wrong distance: want %d, got %d", d, got)
+		}
+	}
+}
+
+func TestBucketCoverage(t *testing.T) {
+	node := enode.MustParseV4("enode://8935c9600d925fd46bdf9d1d155ae682c420d75e4546bfd1de4f9cd18c13aab8edd12a1222d34b10113b091f7d95e85e6c985db93086806535a28efd52002109@175.214.58.105:30303")
+	// 距离为255的桶中有3个节点，另外混入了2个距离为256的节点
+	var rs []*enode.Node
+	for _, d := range []int{255, 255, 255, 256, 256} {
+		var id enode.ID
+		for {
+			crand.Read(id[:])
+			if enode.LogDist(node.ID(), id) == d {
+				break
+			}
+		}
+		rs = append(rs, enode.SignNull(new(enr.Record), id))
+	}
+	if got := bucketFill(node.ID(), 255, rs); got != 3 {
+		t.Errorf("got fill %d, want 3", got)
+	}
+	if got := bucketCoverage(Buckets, 40); got != 1 {
+		t.Errorf("all buckets answered: got %v, want 1", got)
+	}
+	if got := bucketCoverage(Buckets, 0); got != 1 {
+		t.Errorf("empty table: got %v, want 1", got)
+	}
+	// 两个桶失败，按照装满估计缺少32个节点
+	if got := bucketCoverage(Buckets-2, 32); got != 0.5 {
+		t.Errorf("got %v, want 0.5", got)
+	}
+}
+
+// 只记录请求的Finder
+type fakeFinder struct {
+	Finder
+	requests int
+}
+
+func (f *fakeFinder) FindRandomNode(n *enode.Node) ([]*enode.Node, error) {
+	f.requests++
+	return nil, nil
+}
+
+func TestLimitFinder(t *testing.T) {
+	node := enode.MustParseV4("enode://8935c9600d925fd46bdf9d1d155ae682c420d75e4546bfd1de4f9cd18c13aab8edd12a1222d34b10113b091f7d95e85e6c985db93086806535a28efd52002109@175.214.58.105:30303")
+	f := new(fakeFinder)
+	if LimitFinder(f, nil) != Finder(f) {
+		t.Fatal("nil limiter should not wrap the finder")
+	}
+	// 每秒最多10个请求，第11个请求需要等待
+	limiter := limit.New(limit.Config{PerIP: 10})
+	lf := LimitFinder(f, limiter)
+	start := time.Now()
+	for i := 0; i < 11; i++ {
+		lf.FindRandomNode(node)
+	}
+	if f.requests != 11 {
+		t.Fatalf("got %d requests, want 11", f.requests)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("requests were not limited, took %v", d)
+	}
+}
@@ -1,6 +1,8 @@
 package discover
 
 import (
+	"crypto/ecdsa"
+	"fmt"
 	"net"
 	"node_hunter/config"
 	"os"
@@ -12,23 +14,43 @@ import (
 )
 
 func InitV4(port int) *discover.UDPv4 {
+	udpv4, _ := initV4(port, config.NodeKey(), "db")
+	return udpv4
+}
+
+// 启动v4协议，同时返回包装后的UDP连接用于注册收发数据包的钩子
+func InitV4Conn(port int) (*discover.UDPv4, *Conn) {
+	return initV4(port, config.NodeKey(), "db")
+}
+
+// 使用第i个身份的私钥启动v4协议，每个身份使用单独的enode.DB
+func InitV4Identity(port int, i int, priv *ecdsa.PrivateKey) (*discover.UDPv4, *Conn) {
+	dbName := "db"
+	if i > 0 {
+		dbName = fmt.Sprintf("db.%d", i)
+	}
+	return initV4(port, priv, dbName)
+}
+
+func initV4(port int, priv *ecdsa.PrivateKey, dbName string) (*discover.UDPv4, *Conn) {
 	// 构造UDP连接，要使用ListenUDP不能使用DialUDP
-	conn, err := net.ListenUDP("udp4", &net.UDPAddr{
+	// 监听udp同时接收IPv4和IPv6的数据包
+	udp, err := net.ListenUDP("udp", &net.UDPAddr{
 		IP:   []byte{},
 		Port: port,
 	})
 	if err != nil {
 		panic(err)
 	}
+	conn := NewConn(udp)
 
-	// 准备enode.DB对象
-	db, err := enode.OpenDB(path.Join(config.BasePath, "db"))
+	// 准备enode.DB对象，关闭UDPv4时随连接一起关闭
+	db, err := enode.OpenDB(path.Join(config.BasePath, dbName))
 	if err != nil {
 		panic(err)
 	}
+	conn.db = db
 
-	// 准备节点私钥
-	priv := config.PrivateKey
 	ln := enode.NewLocalNode(db, priv)
 
 	logger := log.New()
@@ -42,5 +64,5 @@ func InitV4(port int) *discover.UDPv4 {
 	if err != nil {
 		panic(err)
 	}
-	return udpv4
+	return udpv4, conn
 }
@@ -0,0 +1,44 @@
+package discover
+
+import (
+	"net"
+	"node_hunter/config"
+	"path"
+
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// 同时返回包装后的UDP连接用于注册收发数据包的钩子
+func InitV5(port int) (*discover.UDPv5, *Conn) {
+	// 构造UDP连接，要使用ListenUDP不能使用DialUDP
+	// 监听udp同时接收IPv4和IPv6的数据包
+	udp, err := net.ListenUDP("udp", &net.UDPAddr{
+		IP:   []byte{},
+		Port: port,
+	})
+	if err != nil {
+		panic(err)
+	}
+	conn := NewConn(udp)
+
+	// 准备enode.DB对象，v4和v5可能同时运行，不能共用一个数据库
+	db, err := enode.OpenDB(path.Join(config.BasePath, "db5"))
+	if err != nil {
+		panic(err)
+	}
+	conn.db = db
+
+	// 准备节点私钥
+	priv := config.NodeKey()
+	ln := enode.NewLocalNode(db, priv)
+
+	// 启动节点发现协议
+	udpv5, err := discover.ListenV5(conn, ln, discover.Config{
+		PrivateKey: priv,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return udpv5, conn
+}
@@ -0,0 +1,148 @@
+package discover
+
+import (
+	"fmt"
+	"net"
+	"node_hunter/storage"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/discover/v4wire"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// 汇总写入数据库的时间间隔
+const observeFlushInterval = time.Second * 10
+
+// 向一个地址发送请求之后这段时间内从这个地址收到的ping认为是证明端点的ping
+const proofPingWindow = time.Minute
+
+// 记录其他节点主动发给我们的v4协议请求
+// 爬虫的节点ID会进入很多节点的路由表，通过这些请求可以知道还有谁在爬取，哪些节点在主动建立连接
+type Observer struct {
+	l       *storage.Logger
+	lock    sync.Mutex
+	pending map[inboundKey]*storage.Inbound
+	sent    map[string]int64 // 最近发送过请求的地址以及最后发送的时间
+	closed  chan struct{}
+	done    chan struct{}
+}
+
+type inboundKey struct {
+	id   enode.ID
+	from string
+	kind byte
+}
+
+// 创建后每隔observeFlushInterval把收到的请求写入数据库，Close时写入剩余的请求
+func NewObserver(l *storage.Logger) *Observer {
+	o := &Observer{
+		l:       l,
+		pending: make(map[inboundKey]*storage.Inbound),
+		sent:    make(map[string]int64),
+		closed:  make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go o.loop()
+	return o
+}
+
+// 监听一个连接上收发的数据包
+func (o *Observer) Watch(conn *Conn) {
+	conn.OnRead(o.handlePacket)
+	conn.OnWrite(o.handleSent)
+}
+
+// 记录我们发送请求的地址，对方收到FINDNODE或者ping之后通常会ping回来证明我们的端点
+func (o *Observer) handleSent(b []byte, addr *net.UDPAddr) {
+	kind := v4PacketKind(b)
+	if kind != v4wire.PingPacket && kind != v4wire.FindnodePacket && kind != v4wire.ENRRequestPacket {
+		return
+	}
+	o.lock.Lock()
+	o.sent[addr.String()] = time.Now().Unix()
+	o.lock.Unlock()
+}
+
+// 只记录请求，回复是我们自己的查询引起的
+// 收到的ping大多是我们查询过的节点证明端点发来的，这些ping标记为proofping，不算作主动的请求
+func (o *Observer) handlePacket(b []byte, addr *net.UDPAddr) {
+	kind := v4PacketKind(b)
+	if kind != v4wire.PingPacket && kind != v4wire.FindnodePacket && kind != v4wire.ENRRequestPacket {
+		return
+	}
+	// 校验签名并得到发送者的公钥
+	_, fromKey, _, err := v4wire.Decode(b)
+	if err != nil {
+		return
+	}
+	now := time.Now().Unix()
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if t, ok := o.sent[addr.String()]; kind == v4wire.PingPacket && ok && now-t <= int64(proofPingWindow/time.Second) {
+		kind = storage.InboundProofPing
+	}
+	key := inboundKey{fromKey.ID(), addr.String(), kind}
+	r := o.pending[key]
+	if r == nil {
+		r = &storage.Inbound{ID: key.id, From: key.from, Kind: kind, First: now}
+		o.pending[key] = r
+	}
+	r.Last = now
+	r.Count++
+}
+
+func (o *Observer) loop() {
+	defer close(o.done)
+	ticker := time.NewTicker(observeFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			o.flush()
+		case <-o.closed:
+			o.flush()
+			return
+		}
+	}
+}
+
+func (o *Observer) flush() {
+	o.lock.Lock()
+	rs := make([]*storage.Inbound, 0, len(o.pending))
+	for _, r := range o.pending {
+		rs = append(rs, r)
+	}
+	o.pending = make(map[inboundKey]*storage.Inbound)
+	// 去掉超过时间窗口的地址
+	now := time.Now().Unix()
+	for addr, t := range o.sent {
+		if now-t > int64(proofPingWindow/time.Second) {
+			delete(o.sent, addr)
+		}
+	}
+	o.lock.Unlock()
+	if len(rs) > 0 {
+		o.l.WriteInbound(rs)
+	}
+}
+
+// 停止并写入剩余的请求，需要在关闭数据库之前调用
+func (o *Observer) Close() {
+	close(o.closed)
+	<-o.done
+}
+
+// 只监听端口记录收到的请求，不主动查询任何节点，直到stop关闭
+func Observe(port int, stop <-chan struct{}) {
+	l := storage.StartLog(nil, false)
+	defer l.Close()
+	udpv4, conn := InitV4Conn(port)
+	o := NewObserver(l)
+	o.Watch(conn)
+	fmt.Println("observing inbound requests:", udpv4.Self().URLv4())
+	<-stop
+	// 先关闭监听，之后不会再有新的请求
+	udpv4.Close()
+	o.Close()
+}
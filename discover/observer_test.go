@@ -0,0 +1,63 @@
+package discover
+
+import (
+	"net"
+	"node_hunter/config"
+	"node_hunter/storage"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// a主动ping b，b记录收到的请求；b ping c之后c为了证明端点ping回来，记为proofping
+func TestObserver(t *testing.T) {
+	config.BasePath = t.TempDir()
+	config.DBPath = path.Join(config.BasePath, "storagedb")
+	config.RpcPath = path.Join(config.BasePath, "query.ipc")
+	l := storage.StartLog(nil, false)
+	defer l.Close()
+
+	privA, _ := crypto.GenerateKey()
+	privB, _ := crypto.GenerateKey()
+	privC, _ := crypto.GenerateKey()
+	a, _ := initV4(0, privA, "db.a")
+	defer a.Close()
+	b, connB := initV4(0, privB, "db.b")
+	defer b.Close()
+	c, connC := initV4(0, privC, "db.c")
+	defer c.Close()
+	o := NewObserver(l)
+	o.Watch(connB)
+
+	addr := &net.UDPAddr{IP: net.IP{127, 0, 0, 1}, Port: connB.LocalAddr().(*net.UDPAddr).Port}
+	if err := a.Ping(enode.NewV4(&privB.PublicKey, addr.IP, 0, addr.Port)); err != nil {
+		t.Fatal(err)
+	}
+	addrC := &net.UDPAddr{IP: net.IP{127, 0, 0, 1}, Port: connC.LocalAddr().(*net.UDPAddr).Port}
+	if err := b.Ping(enode.NewV4(&privC.PublicKey, addrC.IP, 0, addrC.Port)); err != nil {
+		t.Fatal(err)
+	}
+	// c在回复pong之后才发送ping
+	proof := inboundKey{c.Self().ID(), addrC.String(), storage.InboundProofPing}
+	for i := 0; i < 50; i++ {
+		o.lock.Lock()
+		_, ok := o.pending[proof]
+		o.lock.Unlock()
+		if ok {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	o.Close()
+
+	days := l.InboundDays(10)
+	if len(days) != 1 || days[0].Nodes != 1 || days[0].Requests["ping"] != 1 || days[0].Requests["proofping"] != 1 {
+		t.Fatalf("wrong inbound days %+v", days)
+	}
+	if top := days[0].Top; len(top) != 1 || top[0].ID != a.Self().ID().String() {
+		t.Fatalf("wrong inbound nodes %+v", top)
+	}
+}
@@ -0,0 +1,95 @@
+package discover
+
+import (
+	"net"
+	"node_hunter/storage"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/p2p/discover/v4wire"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// 使用v4协议ping节点，并记录往返时间和pong的来源地址
+// UDPv4.Ping只返回错误，这里通过连接的读钩子获得pong数据包
+// 读钩子只能按照节点ID找到等待中的ping，所以同一个节点ID同时只有一个ping，之后的等待前一个结束
+type Pinger struct {
+	*discover.UDPv4
+	lock    sync.Mutex
+	done    *sync.Cond // 有ping结束时通知等待同一个节点ID的ping
+	pending map[enode.ID]*pendingPing
+}
+
+type pendingPing struct {
+	start time.Time
+	end   time.Time
+	from  *net.UDPAddr
+	seq   uint64
+}
+
+func NewPinger(udpv4 *discover.UDPv4, conn *Conn) *Pinger {
+	p := &Pinger{
+		UDPv4:   udpv4,
+		pending: make(map[enode.ID]*pendingPing),
+	}
+	p.done = sync.NewCond(&p.lock)
+	conn.OnRead(p.handlePacket)
+	return p
+}
+
+// 收到pong时记录等待中的ping的结果
+// 读钩子在UDPv4处理数据包之前执行，所以Ping返回时结果已经记录
+func (p *Pinger) handlePacket(b []byte, addr *net.UDPAddr) {
+	if v4PacketKind(b) != v4wire.PongPacket {
+		return
+	}
+	packet, fromKey, _, err := v4wire.Decode(b)
+	if err != nil {
+		return
+	}
+	pong := packet.(*v4wire.Pong)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	pp := p.pending[fromKey.ID()]
+	if pp == nil || !pp.end.IsZero() {
+		return
+	}
+	pp.end = time.Now()
+	pp.from = addr
+	pp.seq = pong.ENRSeq
+}
+
+func (p *Pinger) Ping(n *enode.Node) (*storage.PingResult, error) {
+	p.lock.Lock()
+	for p.pending[n.ID()] != nil {
+		p.done.Wait()
+	}
+	pp := &pendingPing{start: time.Now()}
+	p.pending[n.ID()] = pp
+	p.lock.Unlock()
+	defer func() {
+		p.lock.Lock()
+		delete(p.pending, n.ID())
+		p.done.Broadcast()
+		p.lock.Unlock()
+	}()
+
+	if err := p.UDPv4.Ping(n); err != nil {
+		return nil, err
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	// 没有截获到pong数据包时使用Ping返回的时间
+	if pp.end.IsZero() {
+		pp.end = time.Now()
+	}
+	rs := &storage.PingResult{
+		RTT: pp.end.Sub(pp.start),
+		Seq: pp.seq,
+	}
+	if pp.from != nil {
+		rs.From = pp.from.String()
+	}
+	return rs, nil
+}
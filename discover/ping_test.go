@@ -0,0 +1,52 @@
+package discover
+
+import (
+	"net"
+	"node_hunter/config"
+	"node_hunter/storage"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// 在本地回环地址上启动两个v4节点，一个ping另一个
+func TestPinger(t *testing.T) {
+	config.BasePath = t.TempDir()
+	privA, _ := crypto.GenerateKey()
+	privB, _ := crypto.GenerateKey()
+	a, connA := initV4(0, privA, "db.a")
+	defer a.Close()
+	b, connB := initV4(0, privB, "db.b")
+	defer b.Close()
+
+	addr := &net.UDPAddr{IP: net.IP{127, 0, 0, 1}, Port: connB.LocalAddr().(*net.UDPAddr).Port}
+	remote := enode.NewV4(&privB.PublicKey, addr.IP, 0, addr.Port)
+
+	pinger := NewPinger(a, connA)
+	rs, err := pinger.Ping(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rs.RTT <= 0 || rs.From != addr.String() {
+		t.Fatalf("wrong ping result: %+v", rs)
+	}
+
+	// 同时ping同一个节点ID，每个ping都得到自己的结果
+	var wg sync.WaitGroup
+	results := make([]*storage.PingResult, 3)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = pinger.Ping(remote)
+		}(i)
+	}
+	wg.Wait()
+	for i, rs := range results {
+		if rs == nil || rs.RTT <= 0 || rs.From != addr.String() {
+			t.Errorf("ping %d: wrong result %+v", i, rs)
+		}
+	}
+}
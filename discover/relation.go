@@ -1,15 +1,19 @@
 package discover
 
 import (
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"math"
 	"node_hunter/config"
+	"node_hunter/dns"
+	"node_hunter/limit"
 	"node_hunter/rlpx"
 	"node_hunter/storage"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	"github.com/ethereum/go-ethereum/p2p/discover"
 	"github.com/ethereum/go-ethereum/p2p/enode"
 )
 
@@ -19,43 +23,112 @@ var defaultSessionLogInterval = time.Second
 // 每个正在查询的节点的日志时间间隔
 var sessionLogInterval = time.Second
 
+// 会话在完成前被中断
+var errAborted = errors.New("session aborted")
+
+// 节点不属于爬取的链，没有查询它的邻居
+var errOutOfNetwork = errors.New("out of network")
+
 type session struct {
 	initial    *enode.Node // 要查询的节点
-	udpv4      *discover.UDPv4
+	finder     Finder      // 查询enr以及记录会话使用的身份，即finders中的第一个
+	finders    []Finder    // 查询路由表使用的所有身份，每个身份发送同样多的请求
+	proto      storage.Protocol
 	l          *storage.Logger
 	rtt        time.Duration // 查询这个节点的rtt时间
 	threads    int
 	maxThreads int
 	errCount   int32 // 出现错误的次数，一旦查询成功就归零
-	err        error // 最后的错误
+	errLock    sync.Mutex
+	err        error // 最后的错误，多个查询线程同时写入，通过setErr和lastErr访问
 	nodes      int32 // 这个节点认识的节点个数
+	queries    int32 // 发送的FINDNODE请求个数
+	answered   int32 // 成功返回的FINDNODE请求个数
+
+	strategy string
+	est      *estimator // 估计远程路由表的大小
+	stop     StopConfig
+	noEnr    bool
+	noRlpx   bool
+	rlpx     *rlpx.Query
+	network  *config.Network       // 只查询属于这条链的节点的邻居，nil代表不限制
+	self     map[enode.ID]struct{} // 爬虫自己的所有身份，被远程节点加入了路由表，不记录
+	abort    <-chan struct{}       // 关闭后停止继续查询
 
-	noEnr  bool
-	noRlpx bool
+	// 判断所属的链和记录enr共用一次enr查询
+	enrOnce sync.Once
+	enrNode *enode.Node
+	enrErr  error
 }
 
-func newSession(l *storage.Logger, udpv4 *discover.UDPv4, initial *enode.Node, maxThreads int, noEnr, noRlpx bool) *session {
+func newSession(l *storage.Logger, finders []Finder, initial *enode.Node, cfg Config, abort <-chan struct{}) *session {
+	proto := finders[0].Protocol()
 	return &session{
 		initial:    initial,
-		udpv4:      udpv4,
+		finder:     finders[0],
+		finders:    finders,
+		proto:      proto,
 		l:          l,
 		rtt:        time.Millisecond * 100,
-		nodes:      int32(l.NodeRelations(initial)),
-		maxThreads: maxThreads,
-		noEnr:      noEnr,
-		noRlpx:     noRlpx,
+		nodes:      int32(l.NodeRelations(proto, initial)),
+		maxThreads: cfg.NodeThreads,
+		strategy:   cfg.Strategy,
+		est:        newEstimator(),
+		stop:       cfg.Stop,
+		noEnr:      cfg.NoEnr,
+		noRlpx:     cfg.NoRlpx,
+		rlpx:       rlpx.NewQuery(cfg.limiter),
+		network:    cfg.Network,
+		self:       cfg.self,
+		abort:      abort,
+	}
+}
+
+// 会话是否被中断
+func (s *session) aborted() bool {
+	select {
+	case <-s.abort:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *session) setErr(err error) {
+	s.errLock.Lock()
+	s.err = err
+	s.errLock.Unlock()
+}
+
+func (s *session) lastErr() error {
+	s.errLock.Lock()
+	defer s.errLock.Unlock()
+	return s.err
+}
+
+// 记录身份f查询到的节点以及关系
+func (s *session) record(f Finder, rs []*enode.Node) {
+	for _, r := range rs {
+		if _, ok := s.self[r.ID()]; ok {
+			continue
+		}
+		r = config.Dialable(r)
+		s.l.WriteNode(r, s.proto)
+		// 新写入了认识节点，增加计数
+		if s.l.WriteRelation(s.proto, s.initial, r, f.Identity()) {
+			atomic.AddInt32(&s.nodes, 1)
+		}
 	}
 }
 
 // 执行在一个RTT时间内的查询
-// 根据之前的RTT时间来确定要查询的线程数
+// 根据之前的RTT时间来确定要查询的线程数，每个线程使用所有身份各查询一次
 func (s *session) doRTT() int {
-	udpv4 := s.udpv4
 	start := time.Now()
 	// rtt时间是100ms的多少倍，就使用多少线程查询
 	threads := int(s.rtt / (time.Millisecond * 100))
 	// 最少使用一个线程查询，有错误也使用一个线程
-	if threads == 0 || s.err != nil {
+	if threads == 0 || s.lastErr() != nil {
 		threads = 1
 	}
 	// 最多10个线程
@@ -69,20 +142,19 @@ func (s *session) doRTT() int {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			rs, err := udpv4.FindRandomNode(s.initial)
-			if err != nil {
-				atomic.AddInt32(&s.errCount, 1)
-				s.err = err
-			} else {
-				atomic.StoreInt32(&s.errCount, 0)
-				s.err = nil
-			}
-			for _, r := range rs {
-				s.l.WriteNode(r)
-				// 新写入了认识节点，增加计数
-				if s.l.WriteRelation(s.initial, r) {
-					atomic.AddInt32(&s.nodes, 1)
+			for _, f := range s.finders {
+				atomic.AddInt32(&s.queries, 1)
+				rs, err := f.FindRandomNode(s.initial)
+				if err != nil {
+					atomic.AddInt32(&s.errCount, 1)
+					s.setErr(err)
+				} else {
+					atomic.StoreInt32(&s.errCount, 0)
+					atomic.AddInt32(&s.answered, 1)
+					s.setErr(nil)
+					s.est.add(rs)
 				}
+				s.record(f, rs)
 			}
 		}()
 	}
@@ -94,8 +166,59 @@ func (s *session) doRTT() int {
 	return threads
 }
 
+// 根据节点enr中的分叉ID判断节点是否属于爬取的链，不属于的节点记录下来
+// 节点记录中没有eth条目时先查询enr，查询失败无法判断时按照属于处理
+func (s *session) inNetwork() bool {
+	if s.l.IsOutOfNetwork(s.initial) {
+		return false
+	}
+	n := s.initial
+	if _, ok := config.ForkID(n); !ok {
+		// 不查询enr时无法判断，按照属于这条链处理
+		if s.noEnr {
+			return true
+		}
+		var err error
+		if n, err = s.enr(); err != nil {
+			return true
+		}
+	}
+	ok, reason := s.network.Match(n)
+	if !ok {
+		s.l.WriteOutOfNetwork(s.initial, reason)
+		fmt.Println("out of network:", reason, s.initial.URLv4())
+	}
+	return ok
+}
+
+// 查询节点的enr记录，最多尝试三次，一个会话只查询一次，结果写入enr表
+func (s *session) enr() (*enode.Node, error) {
+	s.enrOnce.Do(func() {
+		for i := 0; i < 3; i++ {
+			if s.enrNode, s.enrErr = s.finder.RequestENR(s.initial); s.enrErr == nil {
+				break
+			}
+		}
+		if s.l.HasEnr(s.initial) {
+			return
+		}
+		if s.enrErr == nil {
+			s.l.WriteEnr(s.initial, s.enrNode, nil)
+			fmt.Println("enr done:", s.enrNode.URLv4(), "seq:", s.enrNode.Seq())
+		} else {
+			s.l.WriteEnr(s.initial, nil, s.enrErr)
+			fmt.Println("error enr:", s.initial.URLv4(), s.enrErr)
+		}
+	})
+	return s.enrNode, s.enrErr
+}
+
 func (s *session) do() error {
-	fmt.Println("start search:", s.initial.URLv4())
+	// 不属于爬取的链的节点不查询它的邻居
+	if s.network != nil && !s.inNetwork() {
+		return errOutOfNetwork
+	}
+	fmt.Println("start search:", s.proto, s.initial.URLv4())
 	done := make(chan struct{})
 	// 等待enr和rlpx执行完成
 	var wg sync.WaitGroup
@@ -108,10 +231,11 @@ func (s *session) do() error {
 				return
 			case <-time.Tick(time.Second * 5):
 				count := s.nodes
+				err := s.lastErr()
 				// 节点数超过0，或者报错了才打印
-				if count != 0 || (s.err != nil && s.err.Error() != "RPC timeout") {
-					if s.err != nil {
-						fmt.Printf("count: %d, rtt: %v, threads: %d, err: %v %s\n", count, s.rtt/time.Millisecond*time.Millisecond, s.threads, s.err, s.initial.URLv4())
+				if count != 0 || (err != nil && storage.ClassifyError(err).Class != storage.ErrTimeout) {
+					if err != nil {
+						fmt.Printf("count: %d, rtt: %v, threads: %d, err: %v %s\n", count, s.rtt/time.Millisecond*time.Millisecond, s.threads, err, s.initial.URLv4())
 					} else {
 						fmt.Printf("count: %d, rtt: %v, threads: %d %s\n", count, s.rtt/time.Millisecond*time.Millisecond, s.threads, s.initial.URLv4())
 					}
@@ -128,130 +252,494 @@ func (s *session) do() error {
 			if s.l.HasEnr(s.initial) {
 				return
 			}
-			for i := 0; i < 3; i++ {
-				nn, err := s.udpv4.RequestENR(s.initial)
-				if err == nil {
-					s.l.WriteEnr(s.initial, nn, err)
-					fmt.Println("enr done:", nn.URLv4(), "seq:", nn.Seq())
-					break
-				}
-				if i == 2 {
-					s.l.WriteEnr(s.initial, nil, err)
-					fmt.Println("error enr:", s.initial.URLv4(), err)
-				}
-			}
+			s.enr()
 		}()
 	}
 
-	// 查询rlpx记录
-	if !s.noRlpx {
+	// 查询rlpx记录，v5协议的节点可能没有TCP端口
+	if !s.noRlpx && s.initial.TCP() != 0 {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
 			if s.l.HasRlpx(s.initial) {
 				return
 			}
-			q := rlpx.NewQuery()
-			err := q.QueryNode(s.l, s.initial)
-			if err != nil {
-				if err.Error() == "too many open files" {
-					panic(err)
-				}
+			err := s.rlpx.QueryNode(s.l, s.initial)
+			if err != nil && storage.ClassifyError(err).Class == storage.ErrResource {
+				panic(err)
 			}
 		}()
 	}
 
-	// 查询了多少次后没有增加
-	stopCount := 0
-	for {
-		if s.errCount >= 5 {
-			break
+	rec := &storage.SessionRecord{
+		Strategy:     s.strategy,
+		Identity:     s.finder.Identity(),
+		Compared:     len(s.finders) > 1,
+		Completeness: -1,
+	}
+	if s.strategy == DistanceStrategy {
+		rec.Buckets, rec.Completeness = s.doDistances()
+		rec.Estimate = -1
+		rec.StopReason = StopBuckets
+	} else {
+		rec.StopReason = s.doRandom()
+		rec.Estimate = s.est.estimate()
+		rec.Completeness = s.est.coverage()
+	}
+	wg.Wait()
+	close(done)
+	// 被中断的会话不记录结果，下次启动重新查询
+	if s.aborted() {
+		fmt.Println("search node aborted", s.initial.URLv4())
+		return errAborted
+	}
+	s.l.WriteProbe(s.initial, storage.ProbeFindNode, atomic.LoadInt32(&s.answered) > 0)
+	rec.Time = time.Now().Unix()
+	rec.Queries = int(atomic.LoadInt32(&s.queries))
+	rec.Relations = int(atomic.LoadInt32(&s.nodes))
+	if err := s.lastErr(); atomic.LoadInt32(&s.answered) == 0 && err != nil {
+		rec.Failure = storage.ClassifyError(err)
+	}
+	s.l.WriteSession(s.proto, s.initial, rec)
+	fmt.Printf("search node done, count=%d estimate=%.0f stop=%s %s\n", s.nodes, rec.Estimate, rec.StopReason, s.initial.URLv4())
+	return s.lastErr()
+}
+
+// 会话结束的原因
+const (
+	StopCoverage = "coverage" // 覆盖率达到要求的置信度足够高
+	StopErrors   = "errors"   // 连续出错次数达到上限
+	StopStalled  = "stalled"  // 连续多轮没有发现新的节点
+	StopRounds   = "rounds"   // 查询轮数达到上限
+	StopBuckets  = "buckets"  // 按距离遍历完所有的桶
+)
+
+// 随机查询策略结束会话的条件
+type StopConfig struct {
+	Coverage    float64 // 覆盖率达到多少时结束，0到1之间，0代表不使用覆盖率判断
+	Confidence  float64 // 覆盖率达到要求的置信度，0到1之间
+	MaxErrors   int     // 连续出错多少次结束
+	StallRounds int     // 连续多少轮没有新的节点结束
+	MaxRounds   int     // 最多查询多少轮，0代表不限制
+}
+
+// 不断查询随机目标，直到估计的覆盖率足够高或者满足其他结束条件
+// 返回结束的原因
+func (s *session) doRandom() string {
+	// 连续多少轮没有新的节点
+	stall := 0
+	for round := 0; ; round++ {
+		if s.stop.MaxErrors > 0 && int(atomic.LoadInt32(&s.errCount)) >= s.stop.MaxErrors {
+			return StopErrors
+		}
+		if s.stop.MaxRounds > 0 && round >= s.stop.MaxRounds {
+			return StopRounds
+		}
+		if s.stop.Coverage > 0 && s.est.confidence(s.stop.Coverage) >= s.stop.Confidence {
+			return StopCoverage
 		}
-		lastCount := s.nodes
+		if s.aborted() {
+			return ""
+		}
+		last := s.est.distinct()
 		s.doRTT()
-		// threads := s.doRTT()
-		newCount := s.nodes
-		if newCount == lastCount {
-			stopCount++
+		if s.est.distinct() == last {
+			stall++
 		} else {
-			stopCount = 0
+			stall = 0
 		}
-		if stopCount >= 20 {
-			break
+		if s.stop.StallRounds > 0 && stall >= s.stop.StallRounds {
+			return StopStalled
 		}
 	}
+}
+
+// 按照对数距离逐个查询远程节点路由表的桶，每个身份对每个桶最多尝试3次
+// 返回成功查询的桶的个数，以及按照桶的填充情况估计的覆盖率
+func (s *session) doDistances() (int, float64) {
+	dists := make(chan int, Buckets)
+	for d := MaxBucketDistance; d >= MinBucketDistance; d-- {
+		dists <- d
+	}
+	close(dists)
+
+	threads := s.maxThreads
+	if threads > Buckets {
+		threads = Buckets
+	}
+	s.threads = threads
+	var answered, filled int32
+	var wg sync.WaitGroup
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range dists {
+				// 多个身份查询同一个桶时取最多的结果
+				ok, fill := false, 0
+				for _, f := range s.finders {
+					for try := 0; try < 3 && !s.aborted(); try++ {
+						atomic.AddInt32(&s.queries, 1)
+						rs, err := f.FindDistance(s.initial, d)
+						if err != nil {
+							s.setErr(err)
+							continue
+						}
+						s.record(f, rs)
+						ok = true
+						if n := bucketFill(s.initial.ID(), d, rs); n > fill {
+							fill = n
+						}
+						break
+					}
+				}
+				if ok {
+					atomic.AddInt32(&answered, 1)
+					atomic.AddInt32(&filled, int32(fill))
+				}
+			}
+		}()
+	}
 	wg.Wait()
-	fmt.Printf("search node done, count=%d %s\n", s.nodes, s.initial.URLv4())
-	close(done)
-	return s.err
+	// 有一个桶查询成功就不认为会话出错
+	if answered > 0 {
+		s.setErr(nil)
+	}
+	return int(answered), bucketCoverage(int(answered), int(filled))
+}
+
+// 远程节点路由表每个桶最多保存的节点个数，与go-ethereum一致
+const bucketSize = 16
+
+// 返回的节点中属于距离为dist的桶的个数
+// v4协议返回的是距离目标最近的节点，桶不满时会混入其他桶的节点
+func bucketFill(id enode.ID, dist int, rs []*enode.Node) int {
+	fill := 0
+	for _, r := range rs {
+		d := enode.LogDist(id, r.ID())
+		if d == dist || (dist == MinBucketDistance && d < dist) {
+			fill++
+		}
+	}
+	if fill > bucketSize {
+		fill = bucketSize
+	}
+	return fill
+}
+
+// 成功查询的桶中的节点个数占路由表大小的比例
+// 没有查询成功的桶按照装满估计，所以结果是覆盖率的下限，所有桶都查询成功时为1
+func bucketCoverage(answered, filled int) float64 {
+	missing := (Buckets - answered) * bucketSize
+	if filled+missing == 0 {
+		return 1
+	}
+	return float64(filled) / float64(filled+missing)
 }
 
-// 查询指定的节点认识的所有节点，并导出到relation文件中
-func DumpRelation(l *storage.Logger, udpv4 *discover.UDPv4, initial *enode.Node, nodeThreads int, noEnr, noRlpx bool) error {
+// 使用finders中的所有身份查询指定的节点认识的所有节点，并导出到relation文件中
+// abort关闭后会话尽快结束并返回errAborted，节点不属于爬取的链时返回errOutOfNetwork
+func DumpRelation(l *storage.Logger, finders []Finder, initial *enode.Node, cfg Config, abort <-chan struct{}) error {
 	// 启动与对方节点的会话，并进行查询
-	s := newSession(l, udpv4, initial, nodeThreads, noEnr, noRlpx)
+	s := newSession(l, finders, initial, cfg, abort)
 	err := s.do()
 
 	return err
 }
 
-func StartDiscover(nodes []*enode.Node, threads int, nodeThreads int, noEnr, noRlpx bool) {
-	fmt.Printf("start discover: threads=%d\n", threads)
-	l := storage.StartLog(nodes, true)
-	defer l.Close()
+// 查询一个节点的路由表的策略
+const (
+	RandomStrategy   = "random"   // 不断查询随机目标直到没有新的关系
+	DistanceStrategy = "distance" // 按照对数距离逐个查询路由表的每个桶
+)
 
-	udpv4 := InitV4(30303)
+// 节点发现的配置
+type Config struct {
+	Threads     int             // 同时查询的节点个数
+	NodeThreads int             // 查询单个节点最多使用的线程数
+	NoEnr       bool            // 不查询enr记录
+	NoRlpx      bool            // 不查询rlpx元数据
+	V5          bool            // 同时启动discv5协议的爬虫
+	DNS         []string        // 开始前同步的EIP-1459节点树链接
+	Strategy    string          // 查询单个节点的策略
+	Identities  int             // v4协议同时使用的本地身份个数
+	Compare     float64         // 有多个身份时，这个比例的节点由所有身份共同查询，用于比较不同身份观察到的结果
+	Port        int             // 第一个身份监听的端口，之后的身份依次加一，v5协议使用最后一个端口
+	Limit       limit.Config    // 发送数据包和建立连接的速率限制
+	Stop        StopConfig      // 随机查询策略结束会话的条件
+	Priority    string          // 等待节点的优先级策略，storage.Priorities中的名字
+	Daemon      bool            // 守护模式，按照时间间隔不断开始新的一轮爬取
+	Interval    time.Duration   // 守护模式下每轮爬取的时间间隔
+	Network     *config.Network // 只查询属于这条链的节点的邻居，nil代表不限制
+	Observe     bool            // 同时记录其他节点主动发来的v4协议请求
+	Capture     string          // 把收发的所有数据包写入这个抓包文件，空代表不抓包
 
-	// 控制同时查询的线程数
-	token := make(chan struct{}, threads)
-	for i := 0; i < threads; i++ {
-		token <- struct{}{}
+	limiter *limit.Limiter        // 所有身份和协议共用的限速器
+	self    map[enode.ID]struct{} // 所有身份的节点ID
+}
+
+// stop关闭后不再开始新的会话，等待正在运行的会话结束
+// 超过config.ShutdownTimeout仍未结束的会话被中断，保留doing标记，下次启动时优先继续查询
+// 守护模式下每隔cfg.Interval开始新的一轮，使用新的日期重新查询所有节点，rpc服务一直运行
+func StartDiscover(nodes []*enode.Node, cfg Config, stop <-chan struct{}) {
+	fmt.Printf("start discover: threads=%d v5=%v strategy=%s identities=%d\n", cfg.Threads, cfg.V5, cfg.Strategy, cfg.Identities)
+	newPriority, ok := storage.Priorities[cfg.Priority]
+	if !ok {
+		newPriority = storage.NewHistoryPriority
+	}
+	l := storage.StartLogWith(nodes, true, newPriority)
+	defer l.Close()
+
+	if cfg.Identities < 1 {
+		cfg.Identities = 1
+	}
+	if cfg.Identities > storage.MaxIdentities {
+		cfg.Identities = storage.MaxIdentities
+	}
+	cfg.limiter = limit.New(cfg.Limit)
+	cfg.self = make(map[enode.ID]struct{})
+	// 每个身份使用不同的私钥和端口
+	var finders []Finder
+	var observer *Observer
+	if cfg.Observe {
+		observer = NewObserver(l)
+	}
+	var capture *Capture
+	if cfg.Capture != "" {
+		var err error
+		if capture, err = NewCapture(cfg.Capture); err != nil {
+			panic(err)
+		}
+	}
+	for i, priv := range config.NodeKeys(cfg.Identities) {
+		udpv4, conn := InitV4Identity(cfg.Port+i, i, priv)
+		if observer != nil {
+			observer.Watch(conn)
+		}
+		if capture != nil {
+			capture.Watch(conn, 4)
+		}
+		l.WriteIdentity(i, udpv4.Self().ID())
+		cfg.self[udpv4.Self().ID()] = struct{}{}
+		finders = append(finders, LimitFinder(NewV4Finder(udpv4, i), cfg.limiter))
+	}
+	if cfg.V5 {
+		udpv5, conn := InitV5(cfg.Port + cfg.Identities)
+		if capture != nil {
+			capture.Watch(conn, 5)
+		}
+		cfg.self[udpv5.Self().ID()] = struct{}{}
+		finders = append(finders, LimitFinder(NewV5Finder(udpv5, 0), cfg.limiter))
+		// 种子节点同样作为v5协议的种子
+		for _, n := range nodes {
+			l.WriteNode(n, storage.DiscV5)
+		}
 	}
+	// 在关闭数据库之前关闭所有监听
+	defer func() {
+		for _, f := range finders {
+			f.Close()
+		}
+		if observer != nil {
+			observer.Close()
+		}
+		if capture != nil {
+			if err := capture.Close(); err != nil {
+				fmt.Println("capture error:", err)
+			}
+		}
+	}()
 	var running int32 = 0
-	// 每秒打印一次当前运行查询线程个数
+	finished := make(chan struct{})
+	defer close(finished)
+	// 每秒打印一次当前运行查询线程个数和发送速率
 	go func() {
 		for {
 			running := atomic.LoadInt32(&running)
-			fmt.Printf("running search goroutine=%d\n", running)
+			packets, dials := cfg.limiter.Rates()
+			fmt.Printf("running search goroutine=%d packets/s=%.0f dials/s=%.0f\n", running, packets, dials)
 			c := running
 			if running == 0 {
 				c = 1
 			}
 			sessionLogInterval = time.Duration(c) * defaultSessionLogInterval
-			time.Sleep(time.Second)
+			select {
+			case <-finished:
+				return
+			case <-time.After(time.Second):
+			}
 		}
 	}()
-	// 不断循环所有节点进行搜索
+	// 每个协议独立地遍历自己的等待列表
+	byProto := make(map[storage.Protocol][]Finder)
+	for _, f := range finders {
+		byProto[f.Protocol()] = append(byProto[f.Protocol()], f)
+	}
 	for {
-		// 每秒打印一次当前正在有多少节点被查询
-		for node := l.GetWaiting(); node != nil; node = l.GetWaiting() {
+		start := time.Now()
+		// 节点树中的节点作为所有协议的种子节点，每轮都重新同步
+		if len(cfg.DNS) > 0 {
+			var protos []storage.Protocol
+			for p := range byProto {
+				protos = append(protos, p)
+			}
+			if _, err := dns.SyncTrees(l, cfg.DNS, nil, protos...); err != nil {
+				fmt.Println("dns error:", err)
+			}
+		}
+		// 守护模式下每轮最多运行一个时间间隔
+		var timer *time.Timer
+		var deadline <-chan time.Time
+		if cfg.Daemon {
+			timer = time.NewTimer(cfg.Interval)
+			deadline = timer.C
+		}
+		completed := crawlRound(l, byProto, cfg, &running, stop, deadline)
+		select {
+		case <-stop:
+			// 中途停止的保留今天的日期，下次启动继续查询
+			fmt.Println("discover stopped")
+			return
+		default:
+		}
+		if !cfg.Daemon {
+			// 结束后删除今天的日期
+			l.RemoveDate()
+			return
+		}
+		rec := l.EndRound(start, completed)
+		fmt.Printf("round %s finished, completed=%v relations=%d done=%d\n", rec.Date, completed, rec.Info.Relations, rec.Info.RelationDone)
+		// 提前完成的等到下一轮开始的时间
+		if completed {
+			select {
+			case <-timer.C:
+			case <-stop:
+				fmt.Println("discover stopped")
+				return
+			}
+		}
+		l.NewRound()
+	}
+}
+
+// 执行一轮爬取，直到所有协议的等待节点都查询完成、到达deadline或者收到停止信号
+// 停止后等待正在运行的会话，超时则中断它们
+// 返回是否所有节点都查询完成
+func crawlRound(l *storage.Logger, byProto map[storage.Protocol][]Finder, cfg Config, running *int32, stop <-chan struct{}, deadline <-chan time.Time) bool {
+	roundStop := make(chan struct{})
+	abort := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, fs := range byProto {
+		wg.Add(1)
+		go func(fs []Finder) {
+			defer wg.Done()
+			crawl(l, fs, cfg, running, roundStop, abort)
+		}(fs)
+	}
+	finished := make(chan struct{})
+	go func() {
+		select {
+		case <-stop:
+		case <-deadline:
+			fmt.Println("round deadline reached")
+		case <-finished:
+			return
+		}
+		close(roundStop)
+		if !config.WaitTimeout(&wg, config.ShutdownTimeout) {
+			fmt.Println("shutdown timeout, aborting running sessions")
+			close(abort)
+		}
+	}()
+	wg.Wait()
+	close(finished)
+	select {
+	case <-roundStop:
+		return false
+	default:
+		return true
+	}
+}
+
+// 使用一个协议不断循环所有等待的节点进行搜索，直到没有新的节点
+// 同一协议的多个身份轮流执行查询会话，按照节点ID抽样的cfg.Compare比例的节点由所有身份共同查询
+// stop关闭后不再开始新的会话，等待已经开始的会话结束后返回
+func crawl(l *storage.Logger, finders []Finder, cfg Config, total *int32, stop, abort <-chan struct{}) {
+	proto := finders[0].Protocol()
+	next := 0
+	// 控制同时查询的线程数
+	token := make(chan struct{}, cfg.Threads)
+	for i := 0; i < cfg.Threads; i++ {
+		token <- struct{}{}
+	}
+	var running int32 = 0
+	var sessions sync.WaitGroup
+	defer sessions.Wait()
+	for {
+		for node := l.GetWaiting(proto); node != nil; node = l.GetWaiting(proto) {
 			// 不查询被拒绝的节点
 			if config.Reject(node) {
 				continue
 			}
-			<-token
-			// 开始查询
-			l.RelationDoing(node)
+			select {
+			case <-token:
+			case <-stop:
+				fmt.Println("stop scheduling new sessions", proto)
+				return
+			}
+			// 开始查询，同一个节点ID换了地址的记录今天不再重复查询
+			if !l.StartRelation(proto, node) {
+				token <- struct{}{}
+				continue
+			}
 			atomic.AddInt32(&running, 1)
+			atomic.AddInt32(total, 1)
+			fs := []Finder{finders[next%len(finders)]}
+			next++
+			if len(finders) > 1 && sampled(node.ID(), cfg.Compare) {
+				fs = finders
+			}
+			sessions.Add(1)
 			go func(n *enode.Node) {
-				err := DumpRelation(l, udpv4, n, nodeThreads, noEnr, noRlpx)
-				if err != nil {
-					fmt.Println("error", n.URLv4(), err)
+				defer sessions.Done()
+				err := DumpRelation(l, fs, n, cfg, abort)
+				switch err {
+				case errAborted:
+					// 被中断的会话保留doing标记
+				case errOutOfNetwork:
+					// 链外节点只记录在outnet表中，不算作查询完成
+					l.RelationSkipped(proto, n)
+				default:
+					if err != nil {
+						fmt.Println("error", proto, n.URLv4(), err)
+					}
+					l.RelationDone(proto, n)
 				}
-				l.RelationDone(n)
 				token <- struct{}{}
 				atomic.AddInt32(&running, -1)
+				atomic.AddInt32(total, -1)
 			}(node)
 		}
 		if atomic.LoadInt32(&running) > 0 {
-			fmt.Println("waiting potential new nodes")
-			time.Sleep(time.Second * 3)
-			fmt.Printf("all nodes finished, running goroutine=%d\n", atomic.LoadInt32(&running))
+			fmt.Println("waiting potential new nodes", proto)
+			select {
+			case <-time.After(time.Second * 3):
+			case <-stop:
+				fmt.Println("stop scheduling new sessions", proto)
+				return
+			}
+			fmt.Printf("all nodes finished, running goroutine=%d %s\n", atomic.LoadInt32(&running), proto)
 		} else {
-			fmt.Println("all nodes finished, stop")
+			fmt.Println("all nodes finished, stop", proto)
 			break
 		}
 	}
-	// 结束后删除今天的日期
-	l.RemoveDate()
+}
+
+// 按照节点ID抽样，同一个节点每次的结果相同，share不小于1时总是返回true
+func sampled(id enode.ID, share float64) bool {
+	if share >= 1 {
+		return true
+	}
+	return float64(binary.BigEndian.Uint64(id[:8])) < share*math.MaxUint64
 }
@@ -2,32 +2,63 @@ package discover
 
 import (
 	"fmt"
+	"node_hunter/config"
+	"node_hunter/simnet"
 	"sync"
 	"testing"
 
 	crand "crypto/rand"
 
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/discover"
 	"github.com/ethereum/go-ethereum/p2p/enode"
 )
 
+// 模拟网络中0号节点启动时认识其他所有节点，返回0号节点和查询它的本地节点
+func newRelationNet(t *testing.T, n int) (*simnet.Network, *discover.UDPv4) {
+	config.BasePath = t.TempDir()
+	sim, err := simnet.New(n, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(sim.Close)
+	for i := 1; i < n; i++ {
+		sim.Link(0, i)
+	}
+	if err := sim.Start(); err != nil {
+		t.Fatal(err)
+	}
+	priv, _ := crypto.GenerateKey()
+	v4, _ := initV4(0, priv, "db.r")
+	t.Cleanup(v4.Close)
+	return sim, v4
+}
+
+// 随机目标的查询返回0号节点路由表中的节点
 func TestRelation1(t *testing.T) {
-	node := enode.MustParseV4("enode://8935c9600d925fd46bdf9d1d155ae682c420d75e4546bfd1de4f9cd18c13aab8edd12a1222d34b10113b091f7d95e85e6c985db93086806535a28efd52002109@175.214.58.105:30303")
-	v4 := InitV4(30303)
+	sim, v4 := newRelationNet(t, 6)
+	node := sim.Nodes[0].Enode()
 	rss := make(map[enode.ID]bool)
 	for i := 0; i < 3; i++ {
 		rs, err := v4.FindRandomNode(node)
-		fmt.Println(rs, err)
+		if err != nil {
+			t.Fatal(err)
+		}
 		for _, r := range rs {
 			rss[r.ID()] = true
 		}
 	}
-	fmt.Println(len(rss))
-	v4.Close()
+	for i, n := range sim.Nodes[1:] {
+		if !rss[n.Enode().ID()] {
+			t.Errorf("node %d not returned", i+1)
+		}
+	}
 }
 
+// 多个线程同时查询同一个节点
 func TestRelation2(t *testing.T) {
-	node := enode.MustParseV4("enode://8935c9600d925fd46bdf9d1d155ae682c420d75e4546bfd1de4f9cd18c13aab8edd12a1222d34b10113b091f7d95e85e6c985db93086806535a28efd52002109@175.214.58.105:30303")
-	v4 := InitV4(30303)
+	sim, v4 := newRelationNet(t, 6)
+	node := sim.Nodes[0].Enode()
 	var wg sync.WaitGroup
 	var lock sync.Mutex
 	rss := make(map[enode.ID]bool)
@@ -36,7 +67,9 @@ func TestRelation2(t *testing.T) {
 		go func() {
 			defer wg.Done()
 			rs, err := v4.FindRandomNode(node)
-			fmt.Println(rs, err)
+			if err != nil {
+				t.Error(err)
+			}
 			lock.Lock()
 			for _, r := range rs {
 				rss[r.ID()] = true
@@ -45,32 +78,44 @@ func TestRelation2(t *testing.T) {
 		}()
 	}
 	wg.Wait()
-	fmt.Println(len(rss))
-	v4.Close()
+	if len(rss) < len(sim.Nodes)-1 {
+		t.Errorf("got %d nodes, want at least %d", len(rss), len(sim.Nodes)-1)
+	}
 }
 
 func TestFind(t *testing.T) {
-	node := enode.MustParseV4("enode://8935c9600d925fd46bdf9d1d155ae682c420d75e4546bfd1de4f9cd18c13aab8edd12a1222d34b10113b091f7d95e85e6c985db93086806535a28efd52002109@175.214.58.105:30303")
-	v4 := InitV4(30303)
-	fmt.Println(v4.FindRandomNode(node))
+	sim, v4 := newRelationNet(t, 3)
+	rs, err := NewV4Finder(v4, 0).FindRandomNode(sim.Nodes[0].Enode())
+	if err != nil || len(rs) < 2 {
+		t.Fatal(rs, err)
+	}
 }
 
 func TestPing(t *testing.T) {
-	node := enode.MustParseV4("enode://8935c9600d925fd46bdf9d1d155ae682c420d75e4546bfd1de4f9cd18c13aab8edd12a1222d34b10113b091f7d95e85e6c985db93086806535a28efd52002109@175.214.58.105:30303")
-	v4 := InitV4(30303)
-	fmt.Println(v4.Ping(node))
+	sim, v4 := newRelationNet(t, 2)
+	if err := v4.Ping(sim.Nodes[0].Enode()); err != nil {
+		t.Fatal(err)
+	}
 }
 
+// 按距离查询时返回的节点中包含这个距离上的邻居
 func TestFindDist(t *testing.T) {
-	node := enode.MustParseV4("enode://f645a111bf38373a17d769bb919aa5e04efa967fa2c291c743d6e3ad9a4a6c5a19d74acdc4ebf89bf278d302208d886954fb28ad324e0e28981ba42b5a20834b@216.62.161.238:30303?discport=30305")
-	v4 := InitV4(30303)
-	rs, err := v4.FindNode(node, node.Pubkey())
-	if err != nil {
-		panic(err)
-	}
-	for _, r := range rs {
-		fmt.Println(r)
-		fmt.Println(enode.LogDist(node.ID(), r.ID()))
+	sim, v4 := newRelationNet(t, 4)
+	node := sim.Nodes[0].Enode()
+	f := NewV4Finder(v4, 0)
+	for _, n := range sim.Nodes[1:] {
+		want := n.Enode()
+		rs, err := f.FindDistance(node, enode.LogDist(node.ID(), want.ID()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := false
+		for _, r := range rs {
+			found = found || r.ID() == want.ID()
+		}
+		if !found {
+			t.Errorf("%s not returned at distance %d", want.URLv4(), enode.LogDist(node.ID(), want.ID()))
+		}
 	}
 }
 
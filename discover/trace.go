@@ -0,0 +1,201 @@
+package discover
+
+import (
+	"crypto/ecdsa"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"node_hunter/config"
+	"node_hunter/storage"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// 与go-ethereum的查找一样，每轮并发询问3个节点，保留距离目标最近的16个节点
+const (
+	traceAlpha      = 3
+	traceBucketSize = 16
+)
+
+var errUnknownTarget = errors.New("target node id is not in the node table, use its enode, enr or public key")
+
+// 解析查找的目标，可以是enode链接、enr链接、128个十六进制字符的公钥或者节点ID
+// v4协议的FINDNODE使用公钥作为目标，只有节点ID时从节点表中找到它的公钥
+func ParseTraceTarget(l *storage.Logger, s string) ([64]byte, error) {
+	var target [64]byte
+	if strings.HasPrefix(s, "enode://") || strings.HasPrefix(s, "enr:") {
+		n, err := enode.Parse(enode.ValidSchemes, s)
+		if err != nil {
+			return target, err
+		}
+		if n.Pubkey() == nil {
+			return target, fmt.Errorf("unsupported identity scheme: %s", s)
+		}
+		copy(target[:], crypto.FromECDSAPub(n.Pubkey())[1:])
+		return target, nil
+	}
+	b, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	if err != nil {
+		return target, err
+	}
+	switch len(b) {
+	case len(target):
+		copy(target[:], b)
+		return target, nil
+	case len(enode.ID{}):
+		var id enode.ID
+		copy(id[:], b)
+		nodes := l.ClosestNodes(id, 1)
+		if len(nodes) == 0 || nodes[0].ID() != id {
+			return target, errUnknownTarget
+		}
+		copy(target[:], crypto.FromECDSAPub(nodes[0].Pubkey())[1:])
+		return target, nil
+	}
+	return target, fmt.Errorf("invalid target %s", s)
+}
+
+// 从种子节点开始对目标执行迭代查找，记录每一跳询问的节点和返回的结果
+// 每轮询问距离目标最近的还没有询问过的3个节点，最近的16个节点都询问过后结束
+func trace(udpv4 *discover.UDPv4, target [64]byte, seeds []*enode.Node, stop <-chan struct{}) *storage.Lookup {
+	id := enode.ID(crypto.Keccak256Hash(target[:]))
+	pub := &ecdsa.PublicKey{
+		X: new(big.Int).SetBytes(target[:32]),
+		Y: new(big.Int).SetBytes(target[32:]),
+	}
+	start := time.Now()
+	lk := &storage.Lookup{
+		Target: hex.EncodeToString(id[:]),
+		Pubkey: hex.EncodeToString(target[:]),
+		Start:  start.UnixNano() / int64(time.Millisecond),
+	}
+	traceNode := func(n *enode.Node) storage.TraceNode {
+		return storage.TraceNode{URL: n.URLv4(), Dist: enode.LogDist(id, n.ID())}
+	}
+
+	// closest按照与目标的距离排序，最多保留traceBucketSize个节点
+	var closest []*enode.Node
+	seen := make(map[enode.ID]bool)
+	asked := make(map[enode.ID]bool)
+	add := func(n *enode.Node) bool {
+		if seen[n.ID()] {
+			return false
+		}
+		seen[n.ID()] = true
+		i := sort.Search(len(closest), func(i int) bool { return enode.DistCmp(id, n.ID(), closest[i].ID()) < 0 })
+		if i < traceBucketSize {
+			closest = append(closest, nil)
+			copy(closest[i+1:], closest[i:])
+			closest[i] = n
+			if len(closest) > traceBucketSize {
+				closest = closest[:traceBucketSize]
+			}
+		}
+		return true
+	}
+	for _, n := range seeds {
+		if n.ID() != id && add(n) {
+			lk.Seeds = append(lk.Seeds, traceNode(n))
+		}
+	}
+
+loop:
+	for round := 1; ; round++ {
+		select {
+		case <-stop:
+			break loop
+		default:
+		}
+		var batch []*enode.Node
+		for _, n := range closest {
+			if !asked[n.ID()] {
+				asked[n.ID()] = true
+				batch = append(batch, n)
+				if len(batch) == traceAlpha {
+					break
+				}
+			}
+		}
+		if len(batch) == 0 {
+			break
+		}
+		hops := make([]storage.Hop, len(batch))
+		replies := make([][]*enode.Node, len(batch))
+		var wg sync.WaitGroup
+		for i, n := range batch {
+			wg.Add(1)
+			go func(i int, n *enode.Node) {
+				defer wg.Done()
+				sent := time.Now()
+				rs, err := udpv4.FindNode(n, pub)
+				hops[i] = storage.Hop{
+					Round: round,
+					Node:  n.URLv4(),
+					Dist:  enode.LogDist(id, n.ID()),
+					Sent:  sent.Sub(start).Milliseconds(),
+					RTT:   time.Since(sent).Milliseconds(),
+				}
+				if err != nil {
+					hops[i].Error = err.Error()
+				}
+				replies[i] = rs
+			}(i, n)
+		}
+		wg.Wait()
+		for i := range batch {
+			for _, r := range replies[i] {
+				r = config.Dialable(r)
+				hops[i].Returned = append(hops[i].Returned, traceNode(r))
+				if r.ID() == id {
+					lk.Found = true
+					continue
+				}
+				if add(r) {
+					hops[i].New++
+				}
+			}
+		}
+		lk.Hops = append(lk.Hops, hops...)
+	}
+	for _, n := range closest {
+		lk.Closest = append(lk.Closest, traceNode(n))
+	}
+	lk.Duration = time.Since(start).Milliseconds()
+	return lk
+}
+
+// 对目标执行一次定向查找并保存到trace表，返回的节点同时写入节点表
+// 没有指定种子节点时使用节点表中距离目标最近的16个节点
+func Trace(port int, target string, seeds []*enode.Node, stop <-chan struct{}) (*storage.Lookup, error) {
+	l := storage.StartLog(nil, false)
+	defer l.Close()
+	t, err := ParseTraceTarget(l, target)
+	if err != nil {
+		return nil, err
+	}
+	if len(seeds) == 0 {
+		seeds = l.ClosestNodes(enode.ID(crypto.Keccak256Hash(t[:])), traceBucketSize)
+	}
+	if len(seeds) == 0 {
+		return nil, errors.New("no seed nodes, crawl first or use --seeds")
+	}
+	udpv4, _ := InitV4Conn(port)
+	defer udpv4.Close()
+	lk := trace(udpv4, t, seeds, stop)
+	for _, hop := range lk.Hops {
+		for _, r := range hop.Returned {
+			if n, err := enode.ParseV4(r.URL); err == nil {
+				l.WriteNode(n, storage.DiscV4)
+			}
+		}
+	}
+	l.WriteLookup(lk)
+	return lk, nil
+}
@@ -0,0 +1,65 @@
+package discover
+
+import (
+	"node_hunter/config"
+	"node_hunter/simnet"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// 模拟网络中每个节点启动时只认识后面的两个节点，从0号节点查找7号节点
+// 节点刷新路由表时还会认识其他节点，所以经过的跳数不确定
+func TestTrace(t *testing.T) {
+	config.BasePath = t.TempDir()
+	sim, err := simnet.New(8, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sim.Close()
+	for i := range sim.Nodes {
+		sim.Link(i, (i+1)%len(sim.Nodes))
+		sim.Link(i, (i+2)%len(sim.Nodes))
+	}
+	if err := sim.Start(); err != nil {
+		t.Fatal(err)
+	}
+	priv, _ := crypto.GenerateKey()
+	udpv4, _ := initV4(0, priv, "db.t")
+	defer udpv4.Close()
+
+	want := sim.Nodes[7]
+	var target [64]byte
+	copy(target[:], crypto.FromECDSAPub(&want.Key.PublicKey)[1:])
+	lk := trace(udpv4, target, []*enode.Node{sim.Nodes[0].Enode()}, nil)
+
+	if !lk.Found || lk.Target != want.Enode().ID().String() {
+		t.Fatalf("target should be found %+v", lk)
+	}
+	if len(lk.Hops) == 0 || lk.Hops[0].Node != sim.Nodes[0].Enode().URLv4() || lk.Hops[0].Round != 1 {
+		t.Fatalf("the first hop should ask the seed %+v", lk.Hops)
+	}
+	// 所有可以到达的节点都被询问过，每个节点只询问一次
+	asked := make(map[string]bool)
+	for i, h := range lk.Hops {
+		if h.Error != "" {
+			t.Errorf("hop %d failed: %s", i, h.Error)
+		}
+		if asked[h.Node] {
+			t.Errorf("%s asked twice", h.Node)
+		}
+		asked[h.Node] = true
+		if i > 0 && h.Round < lk.Hops[i-1].Round {
+			t.Errorf("hops are not in order")
+		}
+		for _, r := range h.Returned {
+			if n := enode.MustParseV4(r.URL); r.Dist != enode.LogDist(n.ID(), want.Enode().ID()) {
+				t.Errorf("wrong distance of %s", r.URL)
+			}
+		}
+	}
+	if len(lk.Hops) > 7 || len(lk.Closest) == 0 || len(lk.Closest) > 7 {
+		t.Fatalf("got %d hops and %d closest nodes, want at most 7", len(lk.Hops), len(lk.Closest))
+	}
+}
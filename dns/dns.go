@@ -0,0 +1,48 @@
+package dns
+
+import (
+	"fmt"
+	"node_hunter/storage"
+
+	"github.com/ethereum/go-ethereum/p2p/dnsdisc"
+)
+
+// 同步EIP-1459的DNS节点树，把树中的所有节点写入数据库
+// 节点树中的链接指向的其他节点树也会一起同步
+// resolver为nil时使用系统的DNS
+// 返回同步到的节点个数
+func SyncTrees(l *storage.Logger, urls []string, resolver dnsdisc.Resolver, protos ...storage.Protocol) (int, error) {
+	if len(protos) == 0 {
+		protos = []storage.Protocol{storage.DiscV4}
+	}
+	client := dnsdisc.NewClient(dnsdisc.Config{
+		Resolver: resolver,
+	})
+	visited := make(map[string]bool)
+	count := 0
+	for len(urls) > 0 {
+		url := urls[0]
+		urls = urls[1:]
+		if visited[url] {
+			continue
+		}
+		visited[url] = true
+
+		fmt.Println("syncing tree", url)
+		tree, err := client.SyncTree(url)
+		if err != nil {
+			return count, fmt.Errorf("sync %s: %v", url, err)
+		}
+		nodes := tree.Nodes()
+		for _, n := range nodes {
+			for _, p := range protos {
+				l.WriteNode(n, p)
+			}
+			l.WriteDNSNode(url, tree.Seq(), n)
+		}
+		count += len(nodes)
+		fmt.Printf("tree done, seq=%d nodes=%d links=%d %s\n", tree.Seq(), len(nodes), len(tree.Links()), url)
+		urls = append(urls, tree.Links()...)
+	}
+	return count, nil
+}
@@ -0,0 +1,88 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"node_hunter/config"
+	"node_hunter/storage"
+	"path"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/dnsdisc"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/enr"
+)
+
+// 使用map模拟DNS服务器
+type mapResolver map[string]string
+
+func (mr mapResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if record, ok := mr[name]; ok {
+		return []string{record}, nil
+	}
+	return nil, fmt.Errorf("no such host %s", name)
+}
+
+func testNodes(n int) []*enode.Node {
+	var nodes []*enode.Node
+	for i := 0; i < n; i++ {
+		key, _ := crypto.GenerateKey()
+		var r enr.Record
+		r.Set(enr.IPv4{10, 0, 0, byte(i + 1)})
+		r.Set(enr.UDP(30303))
+		r.Set(enr.TCP(30303))
+		if err := enode.SignV4(&r, key); err != nil {
+			panic(err)
+		}
+		n, err := enode.New(enode.ValidSchemes, &r)
+		if err != nil {
+			panic(err)
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes
+}
+
+func TestSyncTrees(t *testing.T) {
+	config.BasePath = t.TempDir()
+	config.DBPath = path.Join(config.BasePath, "storagedb")
+	config.RpcPath = path.Join(config.BasePath, "query.ipc")
+
+	// 第二棵树通过链接引用
+	key2, _ := crypto.GenerateKey()
+	tree2, _ := dnsdisc.MakeTree(3, testNodes(2), nil)
+	url2, _ := tree2.Sign(key2, "b.example.org")
+
+	key1, _ := crypto.GenerateKey()
+	tree1, _ := dnsdisc.MakeTree(7, testNodes(3), []string{url2})
+	url1, _ := tree1.Sign(key1, "a.example.org")
+
+	resolver := mapResolver{}
+	for k, v := range tree1.ToTXT("a.example.org") {
+		resolver[k] = v
+	}
+	for k, v := range tree2.ToTXT("b.example.org") {
+		resolver[k] = v
+	}
+
+	l := storage.StartLog(nil, false)
+	defer l.Close()
+	count, err := SyncTrees(l, []string{url1}, resolver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 5 || l.Nodes() != 5 {
+		t.Fatalf("wrong node count: synced=%d stored=%d", count, l.Nodes())
+	}
+	seqs := map[string]uint{url1: 7, url2: 3}
+	trees := l.TodayDNSTrees()
+	if len(trees) != 2 {
+		t.Fatalf("wrong tree count %d", len(trees))
+	}
+	for _, tree := range trees {
+		if tree.Seq != seqs[tree.Url] {
+			t.Errorf("wrong seq %d for %s", tree.Seq, tree.Url)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"node_hunter/config"
 	"node_hunter/discover"
+	"node_hunter/limit"
 	"node_hunter/storage"
 	"sync"
 	"sync/atomic"
@@ -11,10 +12,13 @@ import (
 	"github.com/ethereum/go-ethereum/p2p/enode"
 )
 
-func UpdateENR(threads int) {
+// stop关闭后不再发起新的查询，等待正在进行的查询结束后关闭数据库
+// limiter限制发送数据包的速率，nil代表不限速
+func UpdateENR(threads int, limiter *limit.Limiter, stop <-chan struct{}) {
 	fmt.Printf("updating enr threads=%d\n", threads)
 	udpv4 := discover.InitV4(30304)
 	l := storage.StartLog(nil, false)
+	defer l.Close()
 
 	token := make(chan struct{}, threads)
 	for i := 0; i < threads; i++ {
@@ -24,6 +28,7 @@ func UpdateENR(threads int) {
 	// scanner := bufio.NewScanner(nodesF)
 	var count int64
 	var wg sync.WaitGroup
+loop:
 	for {
 		node := l.NextNode()
 		// 遍历所有节点到末尾了，结束
@@ -32,14 +37,19 @@ func UpdateENR(threads int) {
 		}
 		// 拒绝的节点跳过
 		if config.Reject(node) {
-			return
+			continue
 		}
 		// 查询过的节点跳过
 		if l.HasEnr(node) {
 			continue
 		}
+		select {
+		case <-token:
+		case <-stop:
+			fmt.Println("stop requesting enr")
+			break loop
+		}
 		wg.Add(1)
-		<-token
 		go func(n *enode.Node) {
 			defer wg.Done()
 			defer func() { token <- struct{}{} }()
@@ -49,7 +59,17 @@ func UpdateENR(threads int) {
 				return
 			}
 			fmt.Println("requesting", n.URLv4())
+			// 发出请求之前等待限速器，不在写数据包的路径上等待
+			limiter.Packet(n.IP())
 			nn, err := udpv4.RequestENR(n)
+			// 停止时关闭监听导致的错误不记录
+			select {
+			case <-stop:
+				if err != nil {
+					return
+				}
+			default:
+			}
 			l.WriteEnr(n, nn, err)
 			str := n.URLv4()
 			if err != nil {
@@ -65,5 +85,10 @@ func UpdateENR(threads int) {
 			}
 		}(node)
 	}
+	// 超时后关闭监听，让正在等待的请求立即返回
+	if !config.WaitTimeout(&wg, config.ShutdownTimeout) {
+		fmt.Println("shutdown timeout, closing udp")
+	}
+	udpv4.Close()
 	wg.Wait()
 }
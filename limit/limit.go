@@ -0,0 +1,161 @@
+package limit
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// 限速的配置，单位都是每秒的个数，0代表不限制
+type Config struct {
+	Global    float64 // 全局发送的数据包和建立的连接
+	PerIP     float64 // 发往同一个IP的
+	PerSubnet float64 // 发往同一个子网的，IPv4按/24划分，IPv6按/64划分
+}
+
+// 超过这个时间没有使用的IP和子网的令牌桶被清理
+// 令牌桶最多积累一秒的令牌，清理后重新创建没有区别
+const idleTimeout = time.Minute
+
+// 节点发现的数据包和RLPx的连接共用的限速器
+// nil代表不限速，所有方法都可以在nil上调用
+type Limiter struct {
+	cfg    Config
+	global *rate.Limiter
+
+	lock      sync.Mutex
+	ips       map[string]*bucket
+	subnets   map[string]*bucket
+	lastPrune time.Time
+
+	packets int64 // 发送的数据包总数
+	dials   int64 // 建立的连接总数
+
+	rateLock    sync.Mutex
+	lastTime    time.Time
+	lastPackets int64
+	lastDials   int64
+}
+
+type bucket struct {
+	*rate.Limiter
+	last time.Time
+}
+
+// 每个令牌桶最多积累一秒的令牌
+func newLimiter(r float64) *rate.Limiter {
+	burst := int(r)
+	if burst < 1 {
+		burst = 1
+	}
+	return rate.NewLimiter(rate.Limit(r), burst)
+}
+
+func New(cfg Config) *Limiter {
+	l := &Limiter{
+		cfg:       cfg,
+		ips:       make(map[string]*bucket),
+		subnets:   make(map[string]*bucket),
+		lastPrune: time.Now(),
+		lastTime:  time.Now(),
+	}
+	if cfg.Global > 0 {
+		l.global = newLimiter(cfg.Global)
+	}
+	return l
+}
+
+// 等待直到可以向ip发送一个数据包
+func (l *Limiter) Packet(ip net.IP) {
+	if l == nil {
+		return
+	}
+	l.wait(ip)
+	atomic.AddInt64(&l.packets, 1)
+}
+
+// 等待直到可以与ip建立一个连接
+func (l *Limiter) Dial(ip net.IP) {
+	if l == nil {
+		return
+	}
+	l.wait(ip)
+	atomic.AddInt64(&l.dials, 1)
+}
+
+func (l *Limiter) wait(ip net.IP) {
+	ipl, subnetl := l.buckets(ip)
+	for _, r := range []*rate.Limiter{l.global, subnetl, ipl} {
+		if r != nil {
+			r.Wait(context.Background())
+		}
+	}
+}
+
+// 获取ip和它所在子网的令牌桶，不限速的返回nil
+func (l *Limiter) buckets(ip net.IP) (*rate.Limiter, *rate.Limiter) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := time.Now()
+	if now.Sub(l.lastPrune) > idleTimeout {
+		prune(l.ips, now)
+		prune(l.subnets, now)
+		l.lastPrune = now
+	}
+	var ipl, subnetl *rate.Limiter
+	if l.cfg.PerIP > 0 {
+		ipl = get(l.ips, ip.String(), l.cfg.PerIP, now)
+	}
+	if l.cfg.PerSubnet > 0 {
+		subnetl = get(l.subnets, Subnet(ip), l.cfg.PerSubnet, now)
+	}
+	return ipl, subnetl
+}
+
+func get(m map[string]*bucket, key string, r float64, now time.Time) *rate.Limiter {
+	b := m[key]
+	if b == nil {
+		b = &bucket{Limiter: newLimiter(r)}
+		m[key] = b
+	}
+	b.last = now
+	return b.Limiter
+}
+
+func prune(m map[string]*bucket, now time.Time) {
+	for k, b := range m {
+		if now.Sub(b.last) > idleTimeout {
+			delete(m, k)
+		}
+	}
+}
+
+// ip所在的子网，IPv4为/24，IPv6为/64
+func Subnet(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return (&net.IPNet{IP: ip4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}).String()
+}
+
+// 距离上次调用以来每秒发送的数据包和建立的连接个数
+func (l *Limiter) Rates() (packets, dials float64) {
+	if l == nil {
+		return 0, 0
+	}
+	l.rateLock.Lock()
+	defer l.rateLock.Unlock()
+	now := time.Now()
+	p, d := atomic.LoadInt64(&l.packets), atomic.LoadInt64(&l.dials)
+	secs := now.Sub(l.lastTime).Seconds()
+	if secs > 0 {
+		packets = float64(p-l.lastPackets) / secs
+		dials = float64(d-l.lastDials) / secs
+	}
+	l.lastTime, l.lastPackets, l.lastDials = now, p, d
+	return packets, dials
+}
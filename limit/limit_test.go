@@ -0,0 +1,52 @@
+package limit
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSubnet(t *testing.T) {
+	tests := map[string]string{
+		"77.170.227.84":          "77.170.227.0/24",
+		"2001:db8:1:2:3:4:5:6":   "2001:db8:1:2::/64",
+		"::ffff:168.119.18.20":   "168.119.18.0/24",
+		"2001:db8:1:2:ffff::abc": "2001:db8:1:2::/64",
+	}
+	for ip, want := range tests {
+		if got := Subnet(net.ParseIP(ip)); got != want {
+			t.Errorf("subnet of %s: want %s, got %s", ip, want, got)
+		}
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	l := New(Config{PerSubnet: 10})
+	a := net.ParseIP("77.170.227.84")
+	b := net.ParseIP("77.170.227.85")
+	c := net.ParseIP("168.119.18.20")
+
+	start := time.Now()
+	// 同一个子网一秒只能积累10个令牌，之后每100ms一个
+	for i := 0; i < 6; i++ {
+		l.Packet(a)
+		l.Packet(b)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("subnet budget not enforced, elapsed %v", elapsed)
+	}
+	// 其他子网不受影响
+	start = time.Now()
+	l.Dial(c)
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Fatalf("other subnet should not wait, elapsed %v", elapsed)
+	}
+	if packets, dials := l.Rates(); packets <= 0 || dials <= 0 {
+		t.Fatalf("wrong rates: packets=%f dials=%f", packets, dials)
+	}
+
+	// nil代表不限速
+	var unlimited *Limiter
+	unlimited.Packet(a)
+	unlimited.Dial(a)
+}
@@ -1,90 +1,654 @@
 package main
 
 import (
+	"crypto/ecdsa"
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
+	"node_hunter/config"
 	"node_hunter/discover"
+	"node_hunter/dns"
 	"node_hunter/enr"
+	"node_hunter/limit"
+	"node_hunter/ping"
 	"node_hunter/query"
 	"node_hunter/rlpx"
 	"node_hunter/storage"
+	"os"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/ethereum/go-ethereum/p2p/enode"
 	"github.com/jessevdk/go-flags"
 	"github.com/syndtr/goleveldb/leveldb"
 )
 
+// 发送数据包和建立连接的速率限制，各个子命令共用
+type LimitOptions struct {
+	Rate       float64 `long:"rate" default:"0" description:"max packets and dials per second, 0 means unlimited"`
+	IPRate     float64 `long:"iprate" default:"0" description:"max packets and dials per second to a single ip, 0 means unlimited"`
+	SubnetRate float64 `long:"subnetrate" default:"0" description:"max packets and dials per second to a /24 or /64 subnet, 0 means unlimited"`
+}
+
+func (o LimitOptions) config() limit.Config {
+	return limit.Config{
+		Global:    o.Rate,
+		PerIP:     o.IPRate,
+		PerSubnet: o.SubnetRate,
+	}
+}
+
 type DiscoverCommand struct {
-	NoRlpx      bool     `long:"norlpx" default:"false" description:"disable rlpx"`
-	NoEnr       bool     `long:"noenr" default:"false" description:"disable enr"`
-	Remove      bool     `short:"r" long:"remove" default:"false" description:"remove all done sign"`
-	Threads     int      `short:"t" long:"threads" default:"30" description:"threads to execute node discover"`
-	NodeThreads int      `short:"n" long:"nodethreads" default:"10" description:"threads to execute node discover"`
-	SeedNodes   []string `short:"s" long:"seeds" description:"initial seed nodes"`
+	NoRlpx      bool          `long:"norlpx" default:"false" description:"disable rlpx"`
+	NoEnr       bool          `long:"noenr" default:"false" description:"disable enr"`
+	Remove      bool          `short:"r" long:"remove" default:"false" description:"remove all done sign"`
+	Threads     int           `short:"t" long:"threads" default:"30" description:"threads to execute node discover"`
+	NodeThreads int           `short:"n" long:"nodethreads" default:"10" description:"threads to execute node discover"`
+	SeedNodes   []string      `short:"s" long:"seeds" description:"initial seed nodes"`
+	SeedFiles   []string      `long:"seed-file" description:"files of seed nodes, nodes.json or one enode/enr per line, may be gzipped"`
+	V5          bool          `long:"v5" default:"false" description:"run a discv5 crawler alongside the discv4 crawler"`
+	DNS         []string      `long:"dns" description:"EIP-1459 enrtree:// urls used as seeds"`
+	Strategy    string        `long:"strategy" default:"random" description:"how to query a node's table, random or distance"`
+	Identities  int           `long:"identities" default:"1" description:"number of local node identities used by the discv4 crawler"`
+	Compare     float64       `long:"compare" default:"0.1" description:"share of nodes queried by every identity to compare their views, sampled by node id"`
+	Port        int           `short:"p" long:"port" default:"30303" description:"udp port of the first identity, the others use the following ports"`
+	Coverage    float64       `long:"coverage" default:"0.95" description:"stop a random session once this share of the remote table is seen with --confidence, 0 disables"`
+	Confidence  float64       `long:"confidence" default:"0.95" description:"required confidence of the --coverage stop, derived from the recapture counts"`
+	MaxErrors   int           `long:"maxerrors" default:"5" description:"stop a random session after this many consecutive errors"`
+	StallRounds int           `long:"stallrounds" default:"20" description:"stop a random session after this many rounds without new nodes"`
+	MaxRounds   int           `long:"maxrounds" default:"0" description:"max rounds of a random session, 0 means unlimited"`
+	Priority    string        `long:"priority" default:"history" description:"order of waiting nodes, history or fifo"`
+	Daemon      bool          `long:"daemon" default:"false" description:"keep crawling and start a new round every interval"`
+	Interval    time.Duration `long:"interval" default:"24h" description:"interval between crawl rounds in daemon mode, at least 24h"`
+	Network     string        `long:"network" description:"only follow nodes of this chain by enr fork id, mainnet, goerli, sepolia or custom"`
+	ForkHashes  []string      `long:"forkhash" description:"accepted fork hashes of the custom network, 4 bytes in hex"`
+	Forks       []uint64      `long:"fork" description:"block number or timestamp of a fork after the built-in ones of --network, in order"`
+	Observe     bool          `long:"observe" default:"false" description:"also record discv4 requests other nodes send to us"`
+	Capture     string        `long:"capture" description:"write every sent and received discovery packet to this file, see replay"`
+	RejectSybil bool          `long:"reject-sybil" default:"false" description:"skip the ip and id clusters saved by sybil --save"`
+	LimitOptions
 }
 
 func (d *DiscoverCommand) Execute(args []string) error {
 	if d.Remove {
 		l := storage.StartLog(nil, false)
+		defer l.Close()
 		l.RemoveDone()
 		return nil
 	}
-	var seed []*enode.Node
+	if d.Strategy != discover.RandomStrategy && d.Strategy != discover.DistanceStrategy {
+		return fmt.Errorf("unknown strategy %s", d.Strategy)
+	}
+	if _, ok := storage.Priorities[d.Priority]; !ok {
+		return fmt.Errorf("unknown priority %s", d.Priority)
+	}
+	// 每轮使用一个日期，间隔小于一天会与上一轮使用相同的日期
+	if d.Daemon && d.Interval < 24*time.Hour {
+		return fmt.Errorf("interval should be at least 24h")
+	}
+	if d.Coverage < 0 || d.Coverage > 1 {
+		return fmt.Errorf("coverage should be between 0 and 1")
+	}
+	if d.Confidence < 0 || d.Confidence > 1 {
+		return fmt.Errorf("confidence should be between 0 and 1")
+	}
+	if d.Identities < 1 || d.Identities > storage.MaxIdentities {
+		return fmt.Errorf("identities should be between 1 and %d", storage.MaxIdentities)
+	}
+	if d.Compare < 0 || d.Compare > 1 {
+		return fmt.Errorf("compare should be between 0 and 1")
+	}
+	network, err := d.network()
+	if err != nil {
+		return err
+	}
+	config.RejectSybil = d.RejectSybil
+	seed := d.readSeeds()
+	discover.StartDiscover(seed, discover.Config{
+		Threads:     d.Threads,
+		NodeThreads: d.NodeThreads,
+		NoEnr:       d.NoEnr,
+		NoRlpx:      d.NoRlpx,
+		V5:          d.V5,
+		DNS:         d.DNS,
+		Strategy:    d.Strategy,
+		Identities:  d.Identities,
+		Compare:     d.Compare,
+		Port:        d.Port,
+		Limit:       d.config(),
+		Priority:    d.Priority,
+		Daemon:      d.Daemon,
+		Interval:    d.Interval,
+		Network:     network,
+		Observe:     d.Observe,
+		Capture:     d.Capture,
+		Stop: discover.StopConfig{
+			Coverage:    d.Coverage,
+			Confidence:  d.Confidence,
+			MaxErrors:   d.MaxErrors,
+			StallRounds: d.StallRounds,
+			MaxRounds:   d.MaxRounds,
+		},
+	}, config.WatchSignals())
+	return nil
+}
+
+// 没有指定链时返回nil，不限制查询的节点
+func (d *DiscoverCommand) network() (*config.Network, error) {
+	switch d.Network {
+	case "":
+		return nil, nil
+	case "custom":
+		return config.NewCustomNetwork(d.ForkHashes)
+	}
+	n, ok := config.Networks[d.Network]
+	if !ok {
+		return nil, fmt.Errorf("unknown network %s", d.Network)
+	}
+	// 命令行添加的和运行中学习到的分叉只影响这次运行
+	n = n.Copy()
+	n.Extend(d.Forks...)
+	return n, nil
+}
+
+// 读取命令行和文件中的种子节点，无效的记录打印出来后跳过
+func (d *DiscoverCommand) readSeeds() []*enode.Node {
+	var (
+		seed     []*enode.Node
+		rejected int
+	)
 	for _, s := range d.SeedNodes {
-		n := enode.MustParseV4(s)
+		n, err := storage.ParseNode(s)
+		if err != nil {
+			fmt.Println("invalid seed:", err)
+			rejected++
+			continue
+		}
 		seed = append(seed, n)
 	}
-	discover.StartDiscover(seed, d.Threads, d.NodeThreads, d.NoEnr, d.NoRlpx)
-	return nil
+	for _, file := range d.SeedFiles {
+		nodes, errs := storage.ReadSeeds(file)
+		for _, err := range errs {
+			fmt.Printf("invalid seed in %s: %v\n", file, err)
+		}
+		seed = append(seed, nodes...)
+		rejected += len(errs)
+	}
+	fmt.Printf("seeds accepted=%d rejected=%d\n", len(seed), rejected)
+	return seed
 }
 
 type RlpxCommand struct {
 	Threads int `short:"t" long:"threads" default:"30" description:"threads to query node meta data"`
+	LimitOptions
 }
 
 func (r *RlpxCommand) Execute(args []string) error {
-	q := rlpx.NewQuery()
+	q := rlpx.NewQuery(limit.New(r.config()))
 	l := storage.StartLog(nil, false)
-	q.Query(l, r.Threads)
+	defer l.Close()
+	q.Query(l, r.Threads, config.WatchSignals())
 	return nil
 }
 
 type ENRCommand struct {
 	Threads int `short:"t" long:"threads" default:"30" description:"threads to query node enr record"`
+	LimitOptions
 }
 
 func (e *ENRCommand) Execute(args []string) error {
-	enr.UpdateENR(e.Threads)
+	enr.UpdateENR(e.Threads, limit.New(e.config()), config.WatchSignals())
+	return nil
+}
+
+type PingCommand struct {
+	Threads int `short:"t" long:"threads" default:"30" description:"threads to ping nodes"`
+	Port    int `short:"p" long:"port" default:"30305" description:"udp port to listen on"`
+	LimitOptions
+}
+
+func (p *PingCommand) Execute(args []string) error {
+	ping.PingNodes(p.Port, p.Threads, limit.New(p.config()), config.WatchSignals())
+	return nil
+}
+
+type ObserveCommand struct {
+	Port int `short:"p" long:"port" default:"30303" description:"udp port to listen on"`
+}
+
+// 只监听不查询，使用爬虫的节点私钥，其他节点路由表中的爬虫节点会继续收到请求
+func (o *ObserveCommand) Execute(args []string) error {
+	discover.Observe(o.Port, config.WatchSignals())
+	return nil
+}
+
+type ReplayCommand struct {
+	Addr string `long:"addr" description:"only show packets exchanged with this ip or ip:port"`
+	Kind string `long:"kind" description:"only show this kind of packets, ping, pong, findnode, neighbors, enrrequest or enrresponse"`
+}
+
+// 参数为disc --capture写入的抓包文件
+func (r *ReplayCommand) Execute(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("missing capture file")
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return discover.ReadCapture(f, func(rec *discover.CaptureRecord) bool {
+		if r.Addr != "" && rec.Addr != r.Addr && !strings.HasPrefix(rec.Addr, r.Addr+":") && !strings.HasPrefix(rec.Addr, "["+r.Addr+"]:") {
+			return true
+		}
+		ev, err := rec.Decode()
+		if err != nil {
+			if r.Kind == "" {
+				fmt.Printf("%s %s undecodable v%d packet of %d bytes: %v\n", rec.Time.Format("15:04:05.000000"), rec.Addr, rec.Proto, len(rec.Packet), err)
+			}
+			return true
+		}
+		if r.Kind == "" || ev.Kind == r.Kind {
+			fmt.Println(ev)
+		}
+		return true
+	})
+}
+
+type TraceCommand struct {
+	Target string   `long:"target" description:"node id, enode, enr or public key to look up, or an id prefix with --list"`
+	Seeds  []string `short:"s" long:"seeds" description:"start nodes, defaults to the 16 known nodes closest to the target"`
+	Port   int      `short:"p" long:"port" default:"30303" description:"udp port to listen on"`
+	List   bool     `long:"list" default:"false" description:"list the saved lookups"`
+	Show   int64    `long:"show" description:"replay the saved lookup with this start time"`
+	JSON   bool     `long:"json" default:"false" description:"print the lookups as json"`
+}
+
+func (t *TraceCommand) Execute(args []string) error {
+	if t.List || t.Show != 0 {
+		query := query.NewQueryer()
+		lks := query.Lookups(t.Target)
+		if t.Show != 0 {
+			var found []storage.Lookup
+			for _, lk := range lks {
+				if lk.Start == t.Show {
+					found = append(found, lk)
+				}
+			}
+			if len(found) == 0 {
+				fmt.Println("lookup not found")
+			}
+			lks = found
+		}
+		for i := range lks {
+			switch {
+			case t.JSON:
+				b, err := json.Marshal(lks[i])
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(b))
+			case t.List:
+				lk := lks[i]
+				fmt.Printf("%d %s target=%s hops=%d found=%v duration=%dms\n", lk.Start, lk.Date, lk.Target, len(lk.Hops), lk.Found, lk.Duration)
+			default:
+				printLookup(&lks[i])
+			}
+		}
+		return query.Close()
+	}
+	if t.Target == "" {
+		return fmt.Errorf("missing target")
+	}
+	var seeds []*enode.Node
+	for _, s := range t.Seeds {
+		n, err := storage.ParseNode(s)
+		if err != nil {
+			return err
+		}
+		seeds = append(seeds, n)
+	}
+	lk, err := discover.Trace(t.Port, t.Target, seeds, config.WatchSignals())
+	if err != nil {
+		return err
+	}
+	if t.JSON {
+		b, err := json.Marshal(lk)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+		return nil
+	}
+	printLookup(lk)
+	return nil
+}
+
+// 按照询问的顺序显示查找的每一跳
+func printLookup(lk *storage.Lookup) {
+	fmt.Printf("lookup %d target=%s found=%v duration=%dms\n", lk.Start, lk.Target, lk.Found, lk.Duration)
+	for _, n := range lk.Seeds {
+		fmt.Printf("seed d=%d %s\n", n.Dist, n.URL)
+	}
+	for _, h := range lk.Hops {
+		dists := make([]string, 0, len(h.Returned))
+		for _, r := range h.Returned {
+			dists = append(dists, fmt.Sprint(r.Dist))
+		}
+		fmt.Printf("round %d +%dms d=%d %s rtt=%dms returned=%d new=%d dists=[%s]", h.Round, h.Sent, h.Dist, h.Node, h.RTT, len(h.Returned), h.New, strings.Join(dists, " "))
+		if h.Error != "" {
+			fmt.Printf(" err=%s", h.Error)
+		}
+		fmt.Println()
+	}
+	for _, n := range lk.Closest {
+		fmt.Printf("closest d=%d %s\n", n.Dist, n.URL)
+	}
+}
+
+type SybilCommand struct {
+	MinIPIDs  int  `long:"minip" default:"10" description:"flag an ip hosting at least this many node ids"`
+	MinXORIDs int  `long:"minxor" default:"3" description:"flag an id prefix shared by at least this many node ids"`
+	ExtraBits int  `long:"extrabits" default:"8" description:"id prefix length beyond log2 of the number of node ids"`
+	Save      bool `long:"save" default:"false" description:"save the flagged clusters for disc --reject-sybil"`
+}
+
+func (s *SybilCommand) Execute(args []string) error {
+	query := query.NewQueryer()
+	clusters := query.Sybil(storage.SybilOptions{
+		MinIPIDs:  s.MinIPIDs,
+		MinXORIDs: s.MinXORIDs,
+		ExtraBits: s.ExtraBits,
+	})
+	for _, c := range clusters {
+		key := c.Key
+		if c.Kind == "xor" {
+			key = fmt.Sprintf("%s/%d", c.Key, c.Bits)
+		}
+		fmt.Printf("%s %s ids=%d expected=%.3f score=%.1f relations=%d\n", c.Kind, key, c.IDs, c.Expected, c.Score, c.Relations)
+	}
+	if s.Save {
+		query.SaveSybil(clusters)
+		fmt.Printf("saved %d clusters\n", len(clusters))
+	}
+	return query.Close()
+}
+
+type DNSCommand struct {
+	V5 bool `long:"v5" default:"false" description:"also use the nodes as discv5 seeds"`
+}
+
+// 参数为要同步的enrtree://链接
+func (d *DNSCommand) Execute(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing enrtree url")
+	}
+	protos := []storage.Protocol{storage.DiscV4}
+	if d.V5 {
+		protos = append(protos, storage.DiscV5)
+	}
+	l := storage.StartLog(nil, false)
+	defer l.Close()
+	count, err := dns.SyncTrees(l, args, nil, protos...)
+	fmt.Printf("synced %d nodes\n", count)
+	return err
+}
+
+type KeyCommand struct {
+	Rotate bool   `long:"rotate" default:"false" description:"generate a new node key"`
+	Import string `long:"import" description:"import a hex encoded node key file"`
+}
+
+// 默认显示当前使用的节点身份
+func (k *KeyCommand) Execute(args []string) error {
+	var (
+		priv *ecdsa.PrivateKey
+		err  error
+	)
+	if k.Rotate {
+		priv, err = config.RotateNodeKey()
+	} else if k.Import != "" {
+		priv, err = config.ImportNodeKey(k.Import)
+	} else {
+		priv = config.NodeKey()
+	}
+	if err != nil {
+		return err
+	}
+	db, err := enode.OpenDB("")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	// 公网地址在运行时才能确定，这里只显示公钥和默认端口
+	ln := enode.NewLocalNode(db, priv)
+	ln.SetFallbackUDP(30303)
+	n := ln.Node()
+	fmt.Println("id:", n.ID())
+	fmt.Println("enode:", n.URLv4())
+	fmt.Println("enr:", n.String())
 	return nil
 }
 
 type QueryCommand struct {
-	Today      bool `short:"t" long:"today" default:"false" description:"show today's data"`
-	All        bool `short:"a" long:"all" default:"false" description:"show all data"`
-	Nodes      bool `short:"n" long:"nodes" default:"false" description:"show the number of node records"`
-	Active     bool `short:"i" long:"active" default:"false" description:"show the number of active nodes"`
-	ActiveInfo bool `short:"v" long:"activeinfo" default:"false" description:"show the info of active nodes"`
+	Today      bool          `short:"t" long:"today" default:"false" description:"show today's data"`
+	All        bool          `short:"a" long:"all" default:"false" description:"show all data"`
+	Nodes      bool          `short:"n" long:"nodes" default:"false" description:"show the number of node ids"`
+	Active     bool          `short:"i" long:"active" default:"false" description:"show the number of active node ids"`
+	ActiveInfo bool          `short:"v" long:"activeinfo" default:"false" description:"show the info of active nodes"`
+	DNS        bool          `short:"d" long:"dns" default:"false" description:"show today's dns trees compared with the crawl"`
+	Identity   bool          `long:"identity" default:"false" description:"show today's relations observed by each local identity"`
+	Stack      bool          `short:"s" long:"stack" default:"false" description:"show the number of IPv4-only, IPv6-only and dual-stack nodes"`
+	Ping       bool          `long:"ping" default:"false" description:"show daily reachable nodes and rtt distribution"`
+	Errors     bool          `long:"errors" default:"false" description:"show daily failures of each probe type by error class"`
+	Status     bool          `long:"status" default:"false" description:"show daily eth status exchanges by chain"`
+	Sync       bool          `long:"sync" default:"false" description:"show daily synced, lagging and stuck nodes of each chain by client"`
+	Synced     uint64        `long:"synced" default:"10" description:"max blocks behind the best head for a node to count as synced"`
+	Stuck      time.Duration `long:"stuck" default:"24h" description:"min age of a head block for a node to count as stuck, 0 disables"`
+	Queue      bool          `long:"queue" default:"false" description:"show waiting nodes of the running crawler by priority class"`
+	Rounds     bool          `long:"rounds" default:"false" description:"show the result of each crawl round in daemon mode"`
+	ByURL      bool          `long:"byurl" default:"false" description:"count nodes, relations and active nodes of --today, --all, --nodes and --active by enode url instead of node id"`
+	Entities   bool          `long:"entities" default:"false" description:"compare node records with node ids and show ids with several endpoints"`
+	Inbound    bool          `long:"inbound" default:"false" description:"show daily discv4 requests other nodes sent to us"`
+	Top        int           `long:"top" default:"10" description:"number of nodes listed by --inbound"`
+	NAT        bool          `long:"nat" default:"false" description:"classify nodes by comparing neighbor and enr addresses and pong source ports"`
+	Lifecycle  bool          `long:"lifecycle" default:"false" description:"show how many nodes were recently seen and responded"`
+	Node       string        `long:"node" description:"show the lifecycle of a node, by node id, enode or enr"`
+	Protocol   string        `short:"p" long:"protocol" default:"v4" description:"discovery protocol of active nodes, v4 or v5"`
 }
 
 func (q *QueryCommand) Execute(args []string) error {
+	p, ok := storage.ParseProtocol(q.Protocol)
+	if !ok {
+		return fmt.Errorf("unknown protocol %s", q.Protocol)
+	}
 	query := query.NewQueryer()
-	if q.Today {
-		fmt.Println(query.Today())
-	} else if q.All {
-		fmt.Println(query.All())
+	if q.Today || q.All {
+		var info storage.DBInfo
+		if q.Today {
+			info = query.Today()
+		} else {
+			info = query.All()
+		}
+		if q.ByURL {
+			info = info.Records()
+		}
+		fmt.Println(info)
 	} else if q.Nodes {
-		fmt.Println(query.Nodes())
+		fmt.Println(query.Nodes(q.ByURL))
 	} else if q.Active {
-		fmt.Println(query.Active())
+		fmt.Println(query.Active(p, q.ByURL))
 	} else if q.ActiveInfo {
-		actives := query.ActiveInfo()
+		actives := query.ActiveInfo(p)
 		for _, n := range actives.Nodes {
-			fmt.Println(n.Url, n.Number)
+			fmt.Println(n.Url, n.Number, n.Completeness, n.Estimate, n.StopReason)
+		}
+	} else if q.Entities {
+		s := query.Entities(p)
+		fmt.Printf("node records: %d\nnode ids: %d\nids with several endpoints: %d\nrelations: %d\nactive ids: %d\n", s.Records, s.Nodes, s.Moved, s.Relations, s.Actives)
+	} else if q.Identity {
+		for _, s := range query.Identities() {
+			fmt.Printf("identity %d %s sessions=%d relations=%d compared=%d exclusive=%d\n", s.Index, s.ID, s.Sessions, s.Relations, s.Compared, s.Exclusive)
+		}
+	} else if q.Stack {
+		stacks := query.Stacks()
+		fmt.Printf("IPv4 only: %d\nIPv6 only: %d\ndual stack: %d\n", stacks.IPv4Only, stacks.IPv6Only, stacks.Dual)
+	} else if q.Rounds {
+		for _, r := range query.Rounds() {
+			fmt.Printf("%s start=%s end=%s completed=%v\n%v\n", r.Date, time.Unix(r.Start, 0).Format(time.RFC3339), time.Unix(r.End, 0).Format(time.RFC3339), r.Completed, r.Info)
+		}
+	} else if q.Queue {
+		for _, d := range query.Queue() {
+			fmt.Printf("%s %s %d\n", d.Protocol, d.Class, d.Depth)
+		}
+	} else if q.Ping {
+		printPings(query.Pings())
+	} else if q.Errors {
+		printErrors(query.Errors())
+	} else if q.Sync {
+		days := query.Sync(storage.SyncOptions{SyncedBlocks: q.Synced, StuckAge: q.Stuck})
+		for _, d := range days {
+			fmt.Printf("%s %s best=%d\n", d.Date, d.Chain, d.Best)
+			clients := make([]string, 0, len(d.Clients))
+			for c := range d.Clients {
+				clients = append(clients, c)
+			}
+			sort.Strings(clients)
+			for _, c := range clients {
+				s := d.Clients[c]
+				fmt.Printf("\t%s synced=%d lagging=%d stuck=%d unknown=%d\n", c, s.Synced, s.Lagging, s.Stuck, s.Unknown)
+			}
+		}
+	} else if q.Status {
+		for _, d := range query.Status() {
+			fmt.Printf("%s total=%d failed=%d\n", d.Date, d.Total, d.Failed)
+			printCounts(d.Chains)
+			printCounts(d.Failures)
+		}
+	} else if q.Node != "" {
+		printLifecycle(query.Lifecycle(q.Node))
+		printAddresses(query.Addresses(q.Node))
+	} else if q.Inbound {
+		for _, d := range query.Inbound(q.Top) {
+			fmt.Printf("%s nodes=%d ping=%d findnode=%d enrrequest=%d proofping=%d\n", d.Date, d.Nodes, d.Requests["ping"], d.Requests["findnode"], d.Requests["enrrequest"], d.Requests["proofping"])
+			for _, n := range d.Top {
+				fmt.Printf("\t%s ping=%d findnode=%d enrrequest=%d from=%v\n", n.ID, n.Requests["ping"], n.Requests["findnode"], n.Requests["enrrequest"], n.From)
+			}
+		}
+	} else if q.NAT {
+		report := query.NATReport()
+		classes := make([]string, 0, len(report))
+		for c := range report {
+			classes = append(classes, c)
+		}
+		sort.Strings(classes)
+		for _, c := range classes {
+			fmt.Printf("%s: %d\n", c, report[c])
+		}
+	} else if q.Lifecycle {
+		s := query.LifecycleStats()
+		fmt.Printf("nodes: %d\nnever responded: %d\n", s.Nodes, s.NeverResponded)
+		fmt.Printf("seen: day=%d week=%d month=%d\n", s.SeenDay, s.SeenWeek, s.SeenMonth)
+		fmt.Printf("responded: day=%d week=%d month=%d\n", s.RespondedDay, s.RespondedWeek, s.RespondedMonth)
+		for d := 0; d <= 7; d++ {
+			fmt.Printf("\tfail %d days: %d\n", d, s.FailDays[d])
+		}
+	} else if q.DNS {
+		for _, t := range query.DNSTrees() {
+			fmt.Printf("%s seq=%d nodes=%d crawled=%d responded=%d\n", t.Url, t.Seq, t.Nodes, t.Crawled, t.Responded)
 		}
 	}
 	return query.Close()
 }
 
+func printLifecycle(c *storage.Lifecycle) {
+	if c == nil {
+		fmt.Println("node not found")
+		return
+	}
+	format := func(t int64) string {
+		if t == 0 {
+			return "never"
+		}
+		return time.Unix(t, 0).Format(time.RFC3339)
+	}
+	fmt.Println("id:", c.ID)
+	fmt.Println("first seen:", format(c.FirstSeen))
+	fmt.Println("last seen:", format(c.LastSeen))
+	fmt.Println("last responded:", format(c.LastResponded()))
+	fmt.Println("\tfindnode:", format(c.LastFindNode))
+	fmt.Println("\tenr:", format(c.LastENR))
+	fmt.Println("\trlpx:", format(c.LastRlpx))
+	fmt.Println("\tping:", format(c.LastPing))
+	fmt.Println("consecutive fail days:", c.FailDays)
+	for _, e := range c.Endpoints {
+		fmt.Printf("endpoint %s udp=%d tcp=%d first=%s last=%s\n", e.IP, e.UDP, e.TCP, format(e.FirstSeen), format(e.LastSeen))
+	}
+}
+
+func printAddresses(a *storage.Addresses) {
+	if a == nil {
+		return
+	}
+	for _, o := range []struct {
+		name string
+		o    *storage.Observation
+	}{{"neighbor", a.Neighbor}, {"enr", a.ENR}, {"pong", a.Pong}} {
+		if o.o == nil {
+			fmt.Printf("%s address: none\n", o.name)
+			continue
+		}
+		fmt.Printf("%s address: %s udp=%d tcp=%d at %s\n", o.name, o.o.IP, o.o.UDP, o.o.TCP, time.Unix(o.o.Time, 0).Format(time.RFC3339))
+	}
+	fmt.Println("address class:", a.Class())
+}
+
+// 每天一行可达节点数和rtt分位数，之后是rtt分布
+func printPings(days []storage.PingDay) {
+	for _, d := range days {
+		fmt.Printf("%s pinged=%d reachable=%d median=%v p90=%v\n", d.Date, d.Pinged, d.Reachable, d.Median, d.P90)
+		for i, c := range d.RTTs {
+			if i < len(storage.RTTBuckets) {
+				fmt.Printf("\t< %v: %d\n", storage.RTTBuckets[i], c)
+			} else {
+				fmt.Printf("\t>= %v: %d\n", storage.RTTBuckets[i-1], c)
+			}
+		}
+	}
+}
+
+func printErrors(days []storage.ErrorDay) {
+	for _, d := range days {
+		fmt.Println(d.Date)
+		for _, probe := range []storage.Probe{storage.ProbeFindNode, storage.ProbeENR, storage.ProbeRlpx, storage.ProbePing} {
+			pe, ok := d.Probes[probe.String()]
+			if !ok {
+				continue
+			}
+			fmt.Printf("\t%s total=%d failed=%d\n", probe, pe.Total, pe.Failed)
+			printCounts(pe.Kinds)
+		}
+	}
+}
+
+// 按个数从多到少显示
+func printCounts(counts map[string]int) {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	for _, k := range keys {
+		fmt.Printf("\t\t%s: %d\n", k, counts[k])
+	}
+}
+
 type DBCommand struct {
 	Read   bool `short:"r" long:"read" default:"false" description:"read key"`
 	Write  bool `short:"w" long:"write" default:"false" description:"write key value"`
@@ -143,6 +707,13 @@ type Option struct {
 	Discover DiscoverCommand `command:"disc"`
 	Rlpx     RlpxCommand     `command:"rlpx"`
 	ENR      ENRCommand      `command:"enr"`
+	Ping     PingCommand     `command:"ping"`
+	Sybil    SybilCommand    `command:"sybil"`
+	Trace    TraceCommand    `command:"trace"`
+	Observe  ObserveCommand  `command:"observe"`
+	Replay   ReplayCommand   `command:"replay"`
+	DNS      DNSCommand      `command:"dnsdisc"`
+	Key      KeyCommand      `command:"key"`
 	Query    QueryCommand    `command:"query" alias:"q"`
 	DB       DBCommand       `command:"db"`
 }
@@ -0,0 +1,84 @@
+package ping
+
+import (
+	"fmt"
+	"node_hunter/config"
+	"node_hunter/discover"
+	"node_hunter/limit"
+	"node_hunter/storage"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// 对数据库中的所有节点执行一次ping，记录今天是否可达、rtt以及pong的来源地址
+// stop关闭后不再发起新的ping，等待正在进行的ping结束后关闭数据库
+// port为本地监听的udp端口，limiter限制发送数据包的速率，nil代表不限速
+func PingNodes(port, threads int, limiter *limit.Limiter, stop <-chan struct{}) {
+	fmt.Printf("pinging nodes threads=%d port=%d\n", threads, port)
+	udpv4, conn := discover.InitV4Conn(port)
+	pinger := discover.NewPinger(udpv4, conn)
+	l := storage.StartLog(nil, false)
+	defer l.Close()
+
+	token := make(chan struct{}, threads)
+	for i := 0; i < threads; i++ {
+		token <- struct{}{}
+	}
+
+	var count, reachable int64
+	var wg sync.WaitGroup
+loop:
+	for {
+		node := l.NextNode()
+		// 遍历所有节点到末尾了，结束
+		if node == nil {
+			break
+		}
+		// 拒绝的节点和今天ping过的节点跳过
+		if config.Reject(node) || l.HasPing(node) {
+			continue
+		}
+		select {
+		case <-token:
+		case <-stop:
+			fmt.Println("stop pinging")
+			break loop
+		}
+		wg.Add(1)
+		go func(n *enode.Node) {
+			defer wg.Done()
+			defer func() { token <- struct{}{} }()
+
+			// 发出请求之前等待限速器，不在写数据包的路径上等待，否则等待的时间会算进rtt
+			limiter.Packet(n.IP())
+			rs, err := pinger.Ping(n)
+			// 停止时关闭监听导致的错误不记录
+			select {
+			case <-stop:
+				if err != nil {
+					return
+				}
+			default:
+			}
+			l.WritePing(n, rs, err)
+			if err != nil {
+				fmt.Println("ping", n.URLv4(), "error", err)
+			} else {
+				atomic.AddInt64(&reachable, 1)
+				fmt.Println("ping", n.URLv4(), "rtt", rs.RTT, "from", rs.From)
+			}
+			if c := atomic.AddInt64(&count, 1); c%1000 == 0 {
+				fmt.Printf("done %d nodes, reachable %d\n", c, atomic.LoadInt64(&reachable))
+			}
+		}(node)
+	}
+	// 超时后关闭监听，让正在等待的请求立即返回
+	if !config.WaitTimeout(&wg, config.ShutdownTimeout) {
+		fmt.Println("shutdown timeout, closing udp")
+	}
+	udpv4.Close()
+	wg.Wait()
+	fmt.Printf("ping done %d nodes, reachable %d\n", count, reachable)
+}
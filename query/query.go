@@ -4,35 +4,38 @@ import (
 	"net/rpc"
 	"node_hunter/config"
 	"node_hunter/storage"
-	"os"
 )
 
 type Queryer struct {
-	r         *rpc.Client
-	runServer bool
+	r *rpc.Client
+	l *storage.Logger // 没有正在运行的服务时自己启动的Logger
 }
 
 func NewQueryer() *Queryer {
-	server := false
+	var l *storage.Logger
 	rc, err := rpc.DialHTTP("unix", config.RpcPath)
 	if err != nil {
-		storage.StartLog(nil, false)
-		server = true
+		l = storage.StartLog(nil, false)
 		rc, err = rpc.DialHTTP("unix", config.RpcPath)
 		if err != nil {
 			panic(err)
 		}
 	}
 	return &Queryer{
-		r:         rc,
-		runServer: server,
+		r: rc,
+		l: l,
 	}
 }
 
 // 查询节点记录条数
-func (q *Queryer) Nodes() int {
+// 不同节点ID的个数，byURL为true时返回节点记录的条数
+func (q *Queryer) Nodes(byURL bool) int {
+	method := "Query.NodeIDs"
+	if byURL {
+		method = "Query.NodesCount"
+	}
 	rs := 0
-	err := q.r.Call("Query.NodesCount", struct{}{}, &rs)
+	err := q.r.Call(method, struct{}{}, &rs)
 	if err != nil {
 		panic(err)
 	}
@@ -57,27 +60,210 @@ func (q *Queryer) All() storage.DBInfo {
 	return info
 }
 
-func (q *Queryer) Active() int {
+// 今天返回了关系的节点ID个数，byURL为true时按enode链接统计
+func (q *Queryer) Active(p storage.Protocol, byURL bool) int {
+	method := "Query.ActiveIDs"
+	if byURL {
+		method = "Query.Active"
+	}
 	number := 0
-	err := q.r.Call("Query.Active", struct{}{}, &number)
+	err := q.r.Call(method, p, &number)
 	if err != nil {
 		panic(err)
 	}
 	return number
 }
 
-func (q *Queryer) ActiveInfo() *storage.Actives {
+// 按节点ID去重后的节点、关系和活跃节点个数
+func (q *Queryer) Entities(p storage.Protocol) storage.EntityStats {
+	var stats storage.EntityStats
+	err := q.r.Call("Query.Entities", p, &stats)
+	if err != nil {
+		panic(err)
+	}
+	return stats
+}
+
+func (q *Queryer) ActiveInfo(p storage.Protocol) *storage.Actives {
 	rs := new(storage.Actives)
-	err := q.r.Call("Query.ActiveInfo", struct{}{}, &rs)
+	err := q.r.Call("Query.ActiveInfo", p, &rs)
 	if err != nil {
 		panic(err)
 	}
 	return rs
 }
 
+// 查询今天同步的DNS节点树
+func (q *Queryer) DNSTrees() []storage.DNSTree {
+	var trees []storage.DNSTree
+	err := q.r.Call("Query.DNSTrees", struct{}{}, &trees)
+	if err != nil {
+		panic(err)
+	}
+	return trees
+}
+
+// 查询各种IP协议栈的节点个数
+func (q *Queryer) Stacks() storage.Stacks {
+	var stacks storage.Stacks
+	err := q.r.Call("Query.Stacks", struct{}{}, &stacks)
+	if err != nil {
+		panic(err)
+	}
+	return stacks
+}
+
+// 查询今天每个本地身份观察到的关系
+func (q *Queryer) Identities() []storage.IdentityStats {
+	var stats []storage.IdentityStats
+	err := q.r.Call("Query.Identities", struct{}{}, &stats)
+	if err != nil {
+		panic(err)
+	}
+	return stats
+}
+
+// 查询每天ping的可达节点数和rtt分布
+func (q *Queryer) Pings() []storage.PingDay {
+	var days []storage.PingDay
+	err := q.r.Call("Query.Pings", struct{}{}, &days)
+	if err != nil {
+		panic(err)
+	}
+	return days
+}
+
+// 查询正在运行的爬虫每个协议每个优先级类别的等待节点个数
+func (q *Queryer) Queue() []storage.QueueDepth {
+	var depths []storage.QueueDepth
+	err := q.r.Call("Query.Queue", struct{}{}, &depths)
+	if err != nil {
+		panic(err)
+	}
+	return depths
+}
+
+// 查询守护模式下每一轮爬取的结果
+func (q *Queryer) Rounds() []storage.RoundRecord {
+	var rounds []storage.RoundRecord
+	err := q.r.Call("Query.Rounds", struct{}{}, &rounds)
+	if err != nil {
+		panic(err)
+	}
+	return rounds
+}
+
+// 查询节点的生命周期，节点不存在返回nil
+func (q *Queryer) Lifecycle(node string) *storage.Lifecycle {
+	var c storage.Lifecycle
+	err := q.r.Call("Query.Lifecycle", node, &c)
+	if err != nil {
+		panic(err)
+	}
+	if c.ID == "" {
+		return nil
+	}
+	return &c
+}
+
+// 查询节点观察到的地址，节点不存在返回nil
+func (q *Queryer) Addresses(node string) *storage.Addresses {
+	var a storage.Addresses
+	err := q.r.Call("Query.Addresses", node, &a)
+	if err != nil {
+		panic(err)
+	}
+	if a.ID == "" {
+		return nil
+	}
+	return &a
+}
+
+func (q *Queryer) NATReport() storage.NATReport {
+	var report storage.NATReport
+	err := q.r.Call("Query.NATReport", struct{}{}, &report)
+	if err != nil {
+		panic(err)
+	}
+	return report
+}
+
+func (q *Queryer) Sybil(opts storage.SybilOptions) []storage.SybilCluster {
+	var clusters []storage.SybilCluster
+	err := q.r.Call("Query.Sybil", opts, &clusters)
+	if err != nil {
+		panic(err)
+	}
+	return clusters
+}
+
+// 保存标记的集群，之后使用--reject-sybil的爬虫不再查询它们
+func (q *Queryer) SaveSybil(clusters []storage.SybilCluster) {
+	var reply int
+	err := q.r.Call("Query.SaveSybil", clusters, &reply)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (q *Queryer) Inbound(top int) []storage.InboundDay {
+	var days []storage.InboundDay
+	err := q.r.Call("Query.Inbound", top, &days)
+	if err != nil {
+		panic(err)
+	}
+	return days
+}
+
+func (q *Queryer) Lookups(target string) []storage.Lookup {
+	var lks []storage.Lookup
+	err := q.r.Call("Query.Lookups", target, &lks)
+	if err != nil {
+		panic(err)
+	}
+	return lks
+}
+
+func (q *Queryer) Errors() []storage.ErrorDay {
+	var days []storage.ErrorDay
+	err := q.r.Call("Query.Errors", struct{}{}, &days)
+	if err != nil {
+		panic(err)
+	}
+	return days
+}
+
+func (q *Queryer) Status() []storage.StatusDay {
+	var days []storage.StatusDay
+	err := q.r.Call("Query.Status", struct{}{}, &days)
+	if err != nil {
+		panic(err)
+	}
+	return days
+}
+
+func (q *Queryer) Sync(opts storage.SyncOptions) []storage.SyncDay {
+	var days []storage.SyncDay
+	err := q.r.Call("Query.Sync", opts, &days)
+	if err != nil {
+		panic(err)
+	}
+	return days
+}
+
+func (q *Queryer) LifecycleStats() storage.LifecycleStats {
+	var stats storage.LifecycleStats
+	err := q.r.Call("Query.LifecycleStats", struct{}{}, &stats)
+	if err != nil {
+		panic(err)
+	}
+	return stats
+}
+
 func (q *Queryer) Close() error {
-	if q.runServer {
-		return os.Remove(config.RpcPath)
+	q.r.Close()
+	if q.l != nil {
+		return q.l.Close()
 	}
 	return nil
 }
@@ -0,0 +1,122 @@
+package rlpx
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/rlpx"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// 依赖的go-ethereum中协议握手只声明eth/66，当前的节点已经不支持eth/66了
+// 这里直接使用加密连接，自己发送Hello消息，声明ethVersions中的eth协议版本
+const (
+	helloMsg = 0x00
+
+	// 基础协议的版本，5及以上启用snappy压缩
+	baseProtocolVersion = 5
+	// Hello消息的最大长度
+	helloMaxSize = 2 * 1024
+	// 加密握手和协议握手总共的最长时间
+	handshakeTimeout = 5 * time.Second
+)
+
+// 基础协议的Hello消息，与go-ethereum的p2p中的protoHandshake一致
+type hello struct {
+	Version    uint64
+	Name       string
+	Caps       []p2p.Cap
+	ListenPort uint64
+	ID         []byte         // 去掉开头0x04的64字节公钥
+	Rest       []rlp.RawValue `rlp:"tail"`
+}
+
+// 加密连接，实现了p2p.MsgReadWriter
+type conn struct {
+	enc *rlpx.Conn
+}
+
+func newConn(fd net.Conn, pubkey *ecdsa.PublicKey) *conn {
+	return &conn{enc: rlpx.NewConn(fd, pubkey)}
+}
+
+// 加密握手
+func (c *conn) handshake(priv *ecdsa.PrivateKey) error {
+	c.enc.SetDeadline(time.Now().Add(handshakeTimeout))
+	_, err := c.enc.Handshake(priv)
+	return err
+}
+
+// 交换Hello消息，返回对方的Hello，之后的消息读写没有超时，由调用者控制
+func (c *conn) hello(priv *ecdsa.PrivateKey) (*hello, error) {
+	pubkey := crypto.FromECDSAPub(&priv.PublicKey)
+	our := &hello{Version: baseProtocolVersion, Name: "hunter", Caps: ethCaps(), ID: pubkey[1:]}
+	// 同时发送自己的Hello，优先返回读取的错误，对方断开连接时可以得到原因
+	werr := make(chan error, 1)
+	go func() { werr <- p2p.Send(c, helloMsg, our) }()
+	their, err := c.readHello()
+	if err != nil {
+		<-werr
+		return nil, err
+	}
+	if err := <-werr; err != nil {
+		return nil, fmt.Errorf("write error: %v", err)
+	}
+	c.enc.SetSnappy(their.Version >= baseProtocolVersion)
+	c.enc.SetDeadline(time.Time{})
+	return their, nil
+}
+
+func (c *conn) readHello() (*hello, error) {
+	msg, err := c.ReadMsg()
+	if err != nil {
+		return nil, err
+	}
+	if msg.Size > helloMaxSize {
+		return nil, errors.New("hello message too big")
+	}
+	switch msg.Code {
+	case helloMsg:
+	case discMsg:
+		var reason [1]p2p.DiscReason
+		msg.Decode(&reason)
+		return nil, reason[0]
+	default:
+		return nil, fmt.Errorf("expected hello, got %x", msg.Code)
+	}
+	their := new(hello)
+	if err := msg.Decode(their); err != nil {
+		return nil, err
+	}
+	if len(their.ID) != 64 {
+		return nil, p2p.DiscInvalidIdentity
+	}
+	return their, nil
+}
+
+func (c *conn) ReadMsg() (p2p.Msg, error) {
+	code, data, _, err := c.enc.Read()
+	if err != nil {
+		return p2p.Msg{}, err
+	}
+	// 下一次读取会复用data
+	data = common.CopyBytes(data)
+	return p2p.Msg{Code: code, Size: uint32(len(data)), Payload: bytes.NewReader(data), ReceivedAt: time.Now()}, nil
+}
+
+func (c *conn) WriteMsg(msg p2p.Msg) error {
+	data, err := io.ReadAll(msg.Payload)
+	if err != nil {
+		return err
+	}
+	_, err = c.enc.Write(msg.Code, data)
+	return err
+}
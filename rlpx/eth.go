@@ -0,0 +1,289 @@
+package rlpx
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"net"
+	"node_hunter/storage"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/forkid"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// 协议握手之后继续eth协议的握手，交换Status消息得到节点所在的链和最新区块
+// 再请求最新区块的区块头，得到最新区块的高度和时间，用于判断节点是否完成同步
+// 协议握手中声明了eth/68和eth/69，eth协议的消息代码从协商的偏移开始，见supportsEth
+const (
+	baseProtocolLength = 16
+
+	discMsg            = 0x01
+	pingMsg            = 0x02
+	pongMsg            = 0x03
+	statusMsg          = 0x00
+	getBlockHeadersMsg = 0x03
+	blockHeadersMsg    = 0x04
+
+	// 交换Status和请求区块头分别的最长时间
+	ethTimeout = 5 * time.Second
+)
+
+// 声明的eth协议版本和每个版本的消息个数，eth/69增加了BlockRangeUpdate消息
+var ethLengths = map[uint]uint64{68: 17, 69: 18}
+
+func ethCaps() []p2p.Cap {
+	caps := make([]p2p.Cap, 0, len(ethLengths))
+	for version := range ethLengths {
+		caps = append(caps, p2p.Cap{Name: "eth", Version: version})
+	}
+	sort.Slice(caps, func(i, j int) bool { return caps[i].Version < caps[j].Version })
+	return caps
+}
+
+// 协商出的eth协议版本和消息代码的偏移
+type ethProto struct {
+	version uint
+	offset  uint64
+}
+
+// eth协议中的消息代码加上偏移
+func (p *ethProto) code(code uint64) uint64 {
+	return p.offset + code
+}
+
+// 双方是否有共同支持的eth协议版本，不支持的节点（例如只支持les或者eth/66的节点）不交换Status
+// 与go-ethereum的p2p中的matchProtocols相同，对方的协议按照名字和版本排序，
+// 双方都支持的协议依次占用基础协议之后的消息代码，同名的协议只保留最高的版本
+func supportsEth(caps []p2p.Cap) (*ethProto, bool) {
+	sorted := append([]p2p.Cap(nil), caps...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Name != sorted[j].Name {
+			return sorted[i].Name < sorted[j].Name
+		}
+		return sorted[i].Version < sorted[j].Version
+	})
+	offset := uint64(baseProtocolLength)
+	var proto *ethProto
+	for _, cap := range sorted {
+		length, ok := ethLengths[cap.Version]
+		if cap.Name != "eth" || !ok {
+			continue
+		}
+		// 之前匹配的低版本不再使用
+		if proto != nil {
+			offset -= ethLengths[proto.version]
+		}
+		proto = &ethProto{version: cap.Version, offset: offset}
+		offset += length
+	}
+	return proto, proto != nil
+}
+
+// eth/68的Status消息，与go-ethereum的eth/protocols/eth中的StatusPacket68一致
+type statusPacket68 struct {
+	ProtocolVersion uint32
+	NetworkID       uint64
+	TD              *big.Int
+	Head            common.Hash
+	Genesis         common.Hash
+	ForkID          forkid.ID
+}
+
+// eth/69的Status消息，去掉了总难度，增加了节点保存的区块范围
+type statusPacket69 struct {
+	ProtocolVersion uint32
+	NetworkID       uint64
+	Genesis         common.Hash
+	ForkID          forkid.ID
+	EarliestBlock   uint64
+	LatestBlock     uint64
+	LatestBlockHash common.Hash
+}
+
+// 按照协商的版本解码Status消息，返回解码后的消息和其中的链信息
+func decodeStatus(msg p2p.Msg, version uint) (interface{}, *storage.EthStatus, error) {
+	if version >= 69 {
+		st := new(statusPacket69)
+		if err := msg.Decode(st); err != nil {
+			return nil, nil, err
+		}
+		return st, &storage.EthStatus{
+			Version:       st.ProtocolVersion,
+			NetworkID:     st.NetworkID,
+			Head:          st.LatestBlockHash.Hex(),
+			Genesis:       st.Genesis.Hex(),
+			ForkHash:      fmt.Sprintf("%x", st.ForkID.Hash),
+			ForkNext:      st.ForkID.Next,
+			EarliestBlock: st.EarliestBlock,
+			LatestBlock:   st.LatestBlock,
+		}, nil
+	}
+	st := new(statusPacket68)
+	if err := msg.Decode(st); err != nil {
+		return nil, nil, err
+	}
+	if st.TD == nil {
+		return nil, nil, errors.New("status without total difficulty")
+	}
+	return st, &storage.EthStatus{
+		Version:   st.ProtocolVersion,
+		NetworkID: st.NetworkID,
+		TD:        st.TD.String(),
+		Head:      st.Head.Hex(),
+		Genesis:   st.Genesis.Hex(),
+		ForkHash:  fmt.Sprintf("%x", st.ForkID.Hash),
+		ForkNext:  st.ForkID.Next,
+	}, nil
+}
+
+// 在ethTimeout内执行fn，超时后关闭连接并返回超时错误
+func withTimeout(conn net.Conn, what string, fn func() error) error {
+	var timeout int32
+	timer := time.AfterFunc(ethTimeout, func() {
+		atomic.StoreInt32(&timeout, 1)
+		conn.Close()
+	})
+	defer timer.Stop()
+	err := fn()
+	if err != nil && atomic.LoadInt32(&timeout) == 1 {
+		return &storage.Failure{Class: storage.ErrTimeout, Message: what + " timeout"}
+	}
+	return err
+}
+
+// 读取对方的Status消息，然后把同样的内容发回去，避免对方因为链不同马上断开连接
+// 之后请求对方最新区块的区块头，得到最新区块的高度和时间
+func exchangeStatus(rw p2p.MsgReadWriter, conn net.Conn, proto *ethProto) (*storage.EthStatus, error) {
+	var status *storage.EthStatus
+	err := withTimeout(conn, "status", func() error {
+		msg, err := readEth(rw, proto.code(statusMsg))
+		if err != nil {
+			return err
+		}
+		var packet interface{}
+		packet, status, err = decodeStatus(msg, proto.version)
+		if err != nil {
+			return stageError(err, storage.ErrProtocol)
+		}
+		return p2p.Send(rw, proto.code(statusMsg), packet)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// 区块头请求失败时仍然记录Status，同步状态未知
+	err = withTimeout(conn, "block headers", func() error {
+		number, ts, err := requestHeader(rw, proto, common.HexToHash(status.Head))
+		status.HeadNumber, status.HeadTime = number, ts
+		return err
+	})
+	if err != nil {
+		status.HeadFailure = storage.ClassifyError(err)
+	}
+	return status, nil
+}
+
+// 一直读取到指定代码的eth协议消息，期间回复基础协议的ping，收到断开连接的消息时返回断开的原因
+func readEth(rw p2p.MsgReadWriter, code uint64) (p2p.Msg, error) {
+	for {
+		msg, err := rw.ReadMsg()
+		if err != nil {
+			return msg, err
+		}
+		switch msg.Code {
+		case code:
+			return msg, nil
+		case discMsg:
+			var reason [1]p2p.DiscReason
+			msg.Decode(&reason)
+			return msg, reason[0]
+		case pingMsg:
+			msg.Discard()
+			if err := p2p.Send(rw, pongMsg, []interface{}{}); err != nil {
+				return msg, err
+			}
+		default:
+			msg.Discard()
+		}
+	}
+}
+
+// eth/68和eth/69的GetBlockHeaders和BlockHeaders消息
+type headersQuery struct {
+	Origin  common.Hash
+	Amount  uint64
+	Skip    uint64
+	Reverse bool
+}
+
+type getBlockHeadersPacket struct {
+	RequestId uint64
+	Query     headersQuery
+}
+
+type blockHeadersPacket struct {
+	RequestId uint64
+	Headers   []rlp.RawValue
+}
+
+// 区块头中用到的字段，之后的分叉在末尾增加的字段都忽略
+type headerFields struct {
+	ParentHash  common.Hash
+	UncleHash   common.Hash
+	Coinbase    common.Address
+	Root        common.Hash
+	TxHash      common.Hash
+	ReceiptHash common.Hash
+	Bloom       [256]byte
+	Difficulty  *big.Int
+	Number      *big.Int
+	GasLimit    uint64
+	GasUsed     uint64
+	Time        uint64
+	Rest        []rlp.RawValue `rlp:"tail"`
+}
+
+// 按照哈希请求一个区块头，返回区块的高度和时间戳
+// eth/68和eth/69的区块头消息相同，消息代码加上协商的偏移
+// 校验区块头的哈希，不依赖go-ethereum中区块头的定义，兼容之后分叉增加的字段
+func requestHeader(rw p2p.MsgReadWriter, proto *ethProto, hash common.Hash) (uint64, uint64, error) {
+	id := rand.Uint64()
+	req := &getBlockHeadersPacket{RequestId: id, Query: headersQuery{Origin: hash, Amount: 1}}
+	if err := p2p.Send(rw, proto.code(getBlockHeadersMsg), req); err != nil {
+		return 0, 0, err
+	}
+	for {
+		msg, err := readEth(rw, proto.code(blockHeadersMsg))
+		if err != nil {
+			return 0, 0, err
+		}
+		var res blockHeadersPacket
+		if err := msg.Decode(&res); err != nil {
+			return 0, 0, stageError(err, storage.ErrProtocol)
+		}
+		// 不是这个请求的回复
+		if res.RequestId != id {
+			continue
+		}
+		for _, raw := range res.Headers {
+			if crypto.Keccak256Hash(raw) != hash {
+				continue
+			}
+			var h headerFields
+			if err := rlp.DecodeBytes(raw, &h); err != nil {
+				return 0, 0, stageError(err, storage.ErrProtocol)
+			}
+			if h.Number == nil {
+				break
+			}
+			return h.Number.Uint64(), h.Time, nil
+		}
+		return 0, 0, &storage.Failure{Class: storage.ErrProtocol, Message: "head block header not returned"}
+	}
+}
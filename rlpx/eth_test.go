@@ -0,0 +1,77 @@
+package rlpx
+
+import (
+	"math/big"
+	"net"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/forkid"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+func TestSupportsEth(t *testing.T) {
+	cases := []struct {
+		caps    []p2p.Cap
+		ok      bool
+		version uint
+	}{
+		{[]p2p.Cap{{Name: "eth", Version: 66}, {Name: "les", Version: 4}}, false, 0},
+		{[]p2p.Cap{{Name: "snap", Version: 1}, {Name: "eth", Version: 68}}, true, 68},
+		{[]p2p.Cap{{Name: "eth", Version: 69}, {Name: "eth", Version: 68}, {Name: "eth", Version: 70}}, true, 69},
+	}
+	for _, c := range cases {
+		proto, ok := supportsEth(c.caps)
+		if ok != c.ok || (ok && (proto.version != c.version || proto.offset != baseProtocolLength)) {
+			t.Errorf("%v: got %+v %v", c.caps, proto, ok)
+		}
+	}
+}
+
+// 模拟对方节点，发送Status之后回复最新区块的区块头
+func TestExchangeStatus(t *testing.T) {
+	head := &types.Header{Number: big.NewInt(100), Time: 1700000000, Difficulty: big.NewInt(1)}
+	id := forkid.NewID(params.MainnetChainConfig, params.MainnetGenesisHash, 100)
+	packets := map[uint]interface{}{
+		68: &statusPacket68{ProtocolVersion: 68, NetworkID: 1, TD: big.NewInt(100), Head: head.Hash(), Genesis: params.MainnetGenesisHash, ForkID: id},
+		69: &statusPacket69{ProtocolVersion: 69, NetworkID: 1, Genesis: params.MainnetGenesisHash, ForkID: id, LatestBlock: 100, LatestBlockHash: head.Hash()},
+	}
+	for version, packet := range packets {
+		proto := &ethProto{version: version, offset: baseProtocolLength}
+		local, remote := p2p.MsgPipe()
+		fd, _ := net.Pipe()
+		go func() {
+			defer remote.Close()
+			if p2p.Send(remote, proto.code(statusMsg), packet) != nil {
+				return
+			}
+			// 发回来的Status
+			if msg, err := remote.ReadMsg(); err != nil || msg.Discard() != nil {
+				return
+			}
+			msg, err := remote.ReadMsg()
+			if err != nil || msg.Code != proto.code(getBlockHeadersMsg) {
+				return
+			}
+			var req getBlockHeadersPacket
+			msg.Decode(&req)
+			p2p.Send(remote, proto.code(blockHeadersMsg), []interface{}{req.RequestId, []*types.Header{head}})
+		}()
+		st, err := exchangeStatus(local, fd, proto)
+		local.Close()
+		fd.Close()
+		if err != nil {
+			t.Fatalf("eth/%d: %v", version, err)
+		}
+		if st.Version != uint32(version) || st.Chain() != "mainnet" || st.Head != head.Hash().Hex() {
+			t.Errorf("eth/%d: wrong status %+v", version, st)
+		}
+		if st.HeadFailure != nil || st.HeadNumber != 100 || st.HeadTime != head.Time {
+			t.Errorf("eth/%d: wrong head %+v", version, st)
+		}
+		if wantTD := map[uint]string{68: "100", 69: ""}[version]; st.TD != wantTD || (version == 69) != (st.LatestBlock == 100) {
+			t.Errorf("eth/%d: wrong td or block range %+v", version, st)
+		}
+	}
+}
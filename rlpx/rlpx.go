@@ -5,26 +5,30 @@ import (
 	"fmt"
 	"net"
 	"node_hunter/config"
+	"node_hunter/limit"
 	"node_hunter/storage"
 	"sync"
 	"time"
 
-	"github.com/ethereum/go-ethereum/p2p"
 	"github.com/ethereum/go-ethereum/p2p/enode"
 )
 
 type Query struct {
-	priv *ecdsa.PrivateKey
+	priv    *ecdsa.PrivateKey
+	limiter *limit.Limiter // 建立连接前等待限速器，nil代表不限速
 }
 
-func NewQuery() *Query {
-	priv := config.PrivateKey
+func NewQuery(limiter *limit.Limiter) *Query {
+	priv := config.NodeKey()
 	return &Query{
-		priv: priv,
+		priv:    priv,
+		limiter: limiter,
 	}
 }
 
-func (q *Query) Query(l *storage.Logger, threads int) {
+// stop关闭后不再发起新的查询，等待正在进行的查询结束
+// 每个查询的拨号和握手都有超时时间，所以不会无限等待
+func (q *Query) Query(l *storage.Logger, threads int, stop <-chan struct{}) {
 	fmt.Printf("starting rlpx query threads=%d\n", threads)
 	// 控制同时查询的协程数
 	var wg sync.WaitGroup
@@ -42,8 +46,14 @@ func (q *Query) Query(l *storage.Logger, threads int) {
 		if config.Reject(node) {
 			continue
 		}
+		select {
+		case <-token:
+		case <-stop:
+			fmt.Println("stop querying rlpx")
+			wg.Wait()
+			return
+		}
 		wg.Add(1)
-		<-token
 		go func(n *enode.Node) {
 			defer wg.Done()
 			q.QueryNode(l, n)
@@ -53,38 +63,55 @@ func (q *Query) Query(l *storage.Logger, threads int) {
 	wg.Wait()
 }
 
+// 依次尝试节点的IPv4和IPv6地址建立TCP连接
+func (q *Query) dial(node *enode.Node) (net.Conn, error) {
+	v4, v6 := config.Endpoints(node)
+	var err error = &storage.Failure{Class: storage.ErrNoEndpoint, Message: "no tcp endpoint"}
+	for _, e := range []*config.Endpoint{v4, v6} {
+		if e == nil || e.TCP == 0 {
+			continue
+		}
+		q.limiter.Dial(e.IP)
+		var conn net.Conn
+		conn, err = net.DialTimeout("tcp", e.TCPAddr(), time.Second*3)
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// 握手阶段无法识别的错误按照所在的阶段分类
+func stageError(err error, class storage.ErrorClass) error {
+	f := storage.ClassifyError(err)
+	if f.Class == storage.ErrUnknown {
+		f.Class = class
+	}
+	return f
+}
+
 // 查询一个节点的版本，操作系统，支持的协议
+// 支持eth协议的节点继续交换Status消息，结果记录在status表中
 func (q *Query) QueryNode(l *storage.Logger, node *enode.Node) error {
 	// 最近查询过rlpx元数据了，跳过查询
 	if l.HasRlpx(node) {
 		return nil
 	}
-	endpoint := fmt.Sprintf("%s:%d", node.IP().String(), node.TCP())
 	fmt.Println("querying", node.URLv4())
-	conn, err := net.DialTimeout("tcp4", endpoint, time.Second*3)
+	conn, err := q.dial(node)
 	if err != nil {
-		str := fmt.Sprintf("e%s", err.Error())
-		fmt.Println("rlpx:", str)
-		l.WriteRlpx(node, str)
-		return err
+		return writeFailure(l, node, err)
 	}
-	t := p2p.NewRLPX(conn, node.Pubkey())
-	_, err = t.DoEncHandshake(q.priv)
-	if err != nil {
-		str := fmt.Sprintf("e%s", err.Error())
-		fmt.Println("rlpx:", str)
-		l.WriteRlpx(node, str)
-		return err
+	defer conn.Close()
+	c := newConn(conn, node.Pubkey())
+	if err := c.handshake(q.priv); err != nil {
+		return writeFailure(l, node, stageError(err, storage.ErrHandshake))
 	}
-	their, err := t.DoProtoHandshake()
+	their, err := c.hello(q.priv)
 	if err != nil {
-		str := fmt.Sprintf("e%s", err.Error())
-		fmt.Println("rlpx:", str)
-		l.WriteRlpx(node, str)
-		return err
+		return writeFailure(l, node, stageError(err, storage.ErrProtocol))
 	}
-	conn.Close()
-	str := fmt.Sprintf("i%s ", their.Name)
+	str := fmt.Sprintf("%s ", their.Name)
 	caps := their.Caps
 	// 格式化各个子协议
 	// 第一项前面有个空格，后面使用逗号分隔
@@ -96,6 +123,27 @@ func (q *Query) QueryNode(l *storage.Logger, node *enode.Node) error {
 		str += "," + cap.String()
 	}
 	fmt.Println("rlpx:", str)
-	l.WriteRlpx(node, str)
+	l.WriteRlpx(node, str, nil)
+
+	proto, ok := supportsEth(their.Caps)
+	if !ok {
+		return nil
+	}
+	st, err := exchangeStatus(c, conn, proto)
+	if err != nil {
+		f := storage.ClassifyError(err)
+		fmt.Printf("status: %s: %s\n", f.Kind(), f.Message)
+		l.WriteStatus(node, nil, f)
+		return nil
+	}
+	fmt.Printf("status: eth/%d network=%d chain=%s head=%s number=%d fork=%s\n", st.Version, st.NetworkID, st.Chain(), st.Head, st.HeadNumber, st.ForkHash)
+	l.WriteStatus(node, st, nil)
 	return nil
 }
+
+func writeFailure(l *storage.Logger, node *enode.Node, err error) error {
+	f := storage.ClassifyError(err)
+	fmt.Printf("rlpx: %s: %s\n", f.Kind(), f.Message)
+	l.WriteRlpx(node, "", f)
+	return f
+}
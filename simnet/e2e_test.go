@@ -0,0 +1,123 @@
+package simnet_test
+
+import (
+	"node_hunter/config"
+	"node_hunter/discover"
+	"node_hunter/enr"
+	"node_hunter/rlpx"
+	"node_hunter/simnet"
+	"node_hunter/storage"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// 使用临时目录保存数据库、rpc文件和节点私钥
+func useTempDir(t *testing.T) {
+	config.BasePath = t.TempDir()
+	config.DBPath = path.Join(config.BasePath, "storagedb")
+	config.RpcPath = path.Join(config.BasePath, "query.ipc")
+	config.KeyPath = path.Join(config.BasePath, "nodekey")
+}
+
+// 从0号节点开始爬取模拟网络，5号节点不在任何路由表中，不会被发现
+// 节点刷新路由表时会认识邻居的邻居，所以关系至少包含启动时的邻居
+// 之后分别查询enr和rlpx，检查数据库中的节点、关系、元数据和eth协议的Status
+func TestCrawlSimnet(t *testing.T) {
+	useTempDir(t)
+	sim, err := simnet.New(6, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sim.Close()
+	// 1号节点落后100个区块，2号节点的最新区块是两天前的
+	now := uint64(time.Now().Unix())
+	sim.Nodes[1].SetHead(sim.Nodes[0].Head().Number.Uint64()-100, now)
+	sim.Nodes[2].SetHead(sim.Nodes[0].Head().Number.Uint64()-1000, now-2*86400)
+	links := [][2]int{{0, 1}, {0, 2}, {1, 3}, {2, 3}, {2, 4}, {3, 0}}
+	for _, link := range links {
+		sim.Link(link[0], link[1])
+	}
+	if err := sim.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	discover.StartDiscover([]*enode.Node{sim.Nodes[0].Record()}, discover.Config{
+		Threads:     5,
+		NodeThreads: 2,
+		NoEnr:       true,
+		NoRlpx:      true,
+		Strategy:    discover.RandomStrategy,
+		Identities:  1,
+		Port:        0,
+		Priority:    "fifo",
+		Stop: discover.StopConfig{
+			Coverage:    0.95,
+			Confidence:  0.95,
+			MaxErrors:   3,
+			StallRounds: 2,
+			MaxRounds:   4,
+		},
+	}, nil)
+	enr.UpdateENR(4, nil, nil)
+
+	l := storage.StartLog(nil, false)
+	defer l.Close()
+	rlpx.NewQuery(nil).Query(l, 4, nil)
+
+	// 爬虫被加入了模拟节点的路由表，但是不记录自己
+	if l.Nodes() != 5 {
+		t.Errorf("got %d nodes, want 5", l.Nodes())
+	}
+	if l.HasNode(sim.Nodes[5].Enode()) {
+		t.Error("isolated node should not be found")
+	}
+	if got := l.TodayRelations(storage.DiscV4); got < len(links) || got > 5*4 {
+		t.Errorf("got %d relations, want %d to 20", got, len(links))
+	}
+	for _, link := range links {
+		from, to := sim.Nodes[link[0]].Enode(), sim.Nodes[link[1]].Enode()
+		if !l.HasRelation(storage.DiscV4, from, to) {
+			t.Errorf("missing relation %d -> %d", link[0], link[1])
+		}
+	}
+	days := l.SyncReport(storage.SyncOptions{SyncedBlocks: 10, StuckAge: 24 * time.Hour})
+	if len(days) != 1 || days[0].Chain != "mainnet" {
+		t.Fatalf("wrong sync report %+v", days)
+	}
+	sync := days[0].Clients["simnode"]
+	if sync == nil || sync.Synced != 3 || sync.Lagging != 1 || sync.Stuck != 1 {
+		t.Errorf("wrong sync counts %+v", sync)
+	}
+	for i, n := range sim.Nodes[:5] {
+		node := n.Enode()
+		if !l.IsRelationDone(storage.DiscV4, node) {
+			t.Errorf("node %d should be crawled", i)
+		}
+		if got, want := l.NodeRelations(storage.DiscV4, node), len(n.Neighbors()); got < want {
+			t.Errorf("node %d: got %d relations, want at least %d", i, got, want)
+		}
+		if got := l.TodayEnr(node); got != "i"+n.Record().String() {
+			t.Errorf("node %d: wrong enr %q", i, got)
+		}
+		if got := l.TodayRlpx(node); !strings.HasPrefix(got, "i"+n.Name+" ") || !strings.HasSuffix(got, "eth/68,eth/69") {
+			t.Errorf("node %d: wrong rlpx %q", i, got)
+		}
+		st, f := l.TodayStatus(node)
+		if f != nil || st == nil {
+			t.Errorf("node %d: status failed %v", i, f)
+			continue
+		}
+		head := n.Head()
+		// 双方都支持eth/69，Status中没有总难度
+		if st.Version != 69 || st.Chain() != "mainnet" || st.NetworkID != 1 || st.Head != head.Hash().Hex() || st.TD != "" || st.LatestBlock != head.Number.Uint64() {
+			t.Errorf("node %d: wrong status %+v", i, st)
+		}
+		if st.HeadFailure != nil || st.HeadNumber != head.Number.Uint64() || st.HeadTime != head.Time {
+			t.Errorf("node %d: wrong head %d %d %v", i, st.HeadNumber, st.HeadTime, st.HeadFailure)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package simnet
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/forkid"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/enr"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// 在本地回环地址上模拟的以太坊节点发现网络，用于不依赖主网的端到端测试
+// 每个节点运行go-ethereum的v4协议，启动时用Link指定的邻居初始化路由表，
+// 之后与真实的节点一样，刷新路由表时通过查找认识邻居的邻居，也会把ping过它的节点加入路由表
+
+// 默认的最新区块高度
+const defaultHead = 14000000
+
+// rlpx服务在eth协议握手时发送的Status消息，与go-ethereum的eth/protocols/eth中的StatusPacket68和StatusPacket69一致
+type statusPacket68 struct {
+	ProtocolVersion uint32
+	NetworkID       uint64
+	TD              *big.Int
+	Head            common.Hash
+	Genesis         common.Hash
+	ForkID          forkid.ID
+}
+
+type statusPacket69 struct {
+	ProtocolVersion uint32
+	NetworkID       uint64
+	Genesis         common.Hash
+	ForkID          forkid.ID
+	EarliestBlock   uint64
+	LatestBlock     uint64
+	LatestBlockHash common.Hash
+}
+
+type Node struct {
+	Key    *ecdsa.PrivateKey
+	Name   string // rlpx握手时返回的客户端名字
+	conn   *net.UDPConn
+	db     *enode.DB // 保存在内存中的enode.DB
+	ln     *enode.LocalNode
+	udp    *discover.UDPv4 // Start之前为nil
+	server *p2p.Server     // 没有启动rlpx服务时为nil
+
+	lock      sync.RWMutex
+	neighbors []*enode.Node // 启动时加入路由表的邻居
+	head      *types.Header // eth协议握手时声明的最新区块，默认在主网上
+}
+
+type Network struct {
+	Nodes []*Node
+}
+
+// 准备n个节点，rlpx为true时每个节点同时启动rlpx服务
+// 节点发现协议在Start之后才启动，启动前用Link指定每个节点路由表中的邻居
+func New(n int, rlpx bool) (*Network, error) {
+	sim := new(Network)
+	for i := 0; i < n; i++ {
+		node, err := newNode(i, rlpx)
+		if err != nil {
+			sim.Close()
+			return nil, err
+		}
+		sim.Nodes = append(sim.Nodes, node)
+	}
+	return sim, nil
+}
+
+func newNode(i int, rlpx bool) (*Node, error) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	node := &Node{Key: key, Name: fmt.Sprintf("simnode/v1.0.%d", i)}
+	node.SetHead(defaultHead, uint64(time.Now().Unix()))
+	node.conn, err = net.ListenUDP("udp4", &net.UDPAddr{IP: net.IP{127, 0, 0, 1}})
+	if err != nil {
+		return nil, err
+	}
+	if node.db, err = enode.OpenDB(""); err != nil {
+		node.conn.Close()
+		return nil, err
+	}
+	node.ln = enode.NewLocalNode(node.db, key)
+	node.ln.SetStaticIP(net.IP{127, 0, 0, 1})
+	node.ln.SetFallbackUDP(node.conn.LocalAddr().(*net.UDPAddr).Port)
+	if rlpx {
+		node.server = &p2p.Server{Config: p2p.Config{
+			PrivateKey:  key,
+			MaxPeers:    10,
+			Name:        node.Name,
+			ListenAddr:  "127.0.0.1:0",
+			NoDiscovery: true,
+			Protocols: []p2p.Protocol{
+				{Name: "eth", Version: 68, Length: 17, Run: node.runEth(68)},
+				{Name: "eth", Version: 69, Length: 18, Run: node.runEth(69)},
+			},
+		}}
+		if err := node.server.Start(); err != nil {
+			node.Close()
+			return nil, err
+		}
+		node.ln.Set(enr.TCP(node.server.Self().TCP()))
+	}
+	return node, nil
+}
+
+// eth协议的服务，发送Status之后回复GetBlockHeaders请求
+func (n *Node) runEth(version uint) func(*p2p.Peer, p2p.MsgReadWriter) error {
+	return func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+		if err := p2p.Send(rw, 0x00, n.status(version)); err != nil {
+			return err
+		}
+		for {
+			msg, err := rw.ReadMsg()
+			if err != nil {
+				return err
+			}
+			if msg.Code != getBlockHeadersMsg {
+				msg.Discard()
+				continue
+			}
+			var req getBlockHeadersPacket
+			if err := msg.Decode(&req); err != nil {
+				return err
+			}
+			if err := p2p.Send(rw, blockHeadersMsg, n.headers(&req)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// 启动超过这个时间邻居仍然没有全部通过存活检查时返回错误
+const settleTimeout = 30 * time.Second
+
+// 启动所有节点的v4协议，每个节点的路由表中是Link指定的邻居
+// 等待所有节点启动时的邻居都通过存活检查之后返回，之后FINDNODE一定会返回这些邻居
+func (sim *Network) Start() error {
+	for _, n := range sim.Nodes {
+		udp, err := discover.ListenV4(n.conn, n.ln, discover.Config{
+			PrivateKey: n.Key,
+			Bootnodes:  n.Neighbors(),
+		})
+		if err != nil {
+			return err
+		}
+		n.udp = udp
+	}
+	deadline := time.Now().Add(settleTimeout)
+	for _, n := range sim.Nodes {
+		for !n.settled() {
+			if time.Now().After(deadline) {
+				return errors.New("neighbors are not revalidated in time")
+			}
+			revalidate(n.udp)
+		}
+	}
+	return nil
+}
+
+// 节点的enr记录，可以作为爬虫的种子节点
+func (n *Node) Record() *enode.Node {
+	return n.ln.Node()
+}
+
+// 节点的enode链接记录，与爬虫从邻居中得到的记录相同
+func (n *Node) Enode() *enode.Node {
+	r := n.Record()
+	return enode.NewV4(&n.Key.PublicKey, r.IP(), r.TCP(), r.UDP())
+}
+
+// eth/68和eth/69的GetBlockHeaders和BlockHeaders消息，只支持按照哈希查询
+const (
+	getBlockHeadersMsg = 0x03
+	blockHeadersMsg    = 0x04
+)
+
+type getBlockHeadersPacket struct {
+	RequestId uint64
+	Query     struct {
+		Origin  common.Hash
+		Amount  uint64
+		Skip    uint64
+		Reverse bool
+	}
+}
+
+type blockHeadersPacket struct {
+	RequestId uint64
+	Headers   []*types.Header
+}
+
+// 只保存了最新区块，查询其他区块时返回空的列表
+func (n *Node) headers(req *getBlockHeadersPacket) *blockHeadersPacket {
+	res := &blockHeadersPacket{RequestId: req.RequestId, Headers: []*types.Header{}}
+	if head := n.Head(); req.Query.Origin == head.Hash() && req.Query.Amount > 0 {
+		res.Headers = append(res.Headers, head)
+	}
+	return res
+}
+
+// 设置eth协议握手时声明的最新区块，总难度与区块高度相同
+func (n *Node) SetHead(number, time uint64) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.head = &types.Header{
+		Number:     new(big.Int).SetUint64(number),
+		Time:       time,
+		Difficulty: big.NewInt(1),
+	}
+}
+
+func (n *Node) Head() *types.Header {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	return n.head
+}
+
+// 主网上的Status消息，eth/69没有总难度，声明保存了全部区块
+func (n *Node) status(version uint) interface{} {
+	head := n.Head()
+	id := forkid.NewID(params.MainnetChainConfig, params.MainnetGenesisHash, head.Number.Uint64())
+	if version >= 69 {
+		return &statusPacket69{
+			ProtocolVersion: uint32(version),
+			NetworkID:       1,
+			Genesis:         params.MainnetGenesisHash,
+			ForkID:          id,
+			LatestBlock:     head.Number.Uint64(),
+			LatestBlockHash: head.Hash(),
+		}
+	}
+	return &statusPacket68{
+		ProtocolVersion: uint32(version),
+		NetworkID:       1,
+		TD:              new(big.Int).Set(head.Number),
+		Head:            head.Hash(),
+		Genesis:         params.MainnetGenesisHash,
+		ForkID:          id,
+	}
+}
+
+// 把to加入from启动时的路由表，需要在Start之前调用
+func (sim *Network) Link(from, to int) {
+	n := sim.Nodes[from]
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.neighbors = append(n.neighbors, sim.Nodes[to].Enode())
+}
+
+// 启动时加入路由表的邻居，之后路由表中还会有节点通过查找和ping认识的其他节点
+func (n *Node) Neighbors() []*enode.Node {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	return append([]*enode.Node(nil), n.neighbors...)
+}
+
+// UDPv4关闭时同时关闭UDP连接
+func (n *Node) Close() {
+	if n.udp != nil {
+		n.udp.Close()
+	} else {
+		n.conn.Close()
+	}
+	if n.db != nil {
+		n.db.Close()
+	}
+	if n.server != nil {
+		n.server.Stop()
+	}
+}
+
+func (sim *Network) Close() {
+	for _, n := range sim.Nodes {
+		n.Close()
+	}
+}
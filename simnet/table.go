@@ -0,0 +1,54 @@
+package simnet
+
+import (
+	"reflect"
+	"sync"
+	"unsafe"
+
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// go-ethereum的路由表在FINDNODE时只返回通过了存活检查的节点，没有节点通过检查时才返回全部节点
+// 存活检查每10秒内随机检查一个节点，启动后很长时间内节点只返回路由表的一部分，爬取的结果不确定
+// go-ethereum没有导出路由表，这里链接过去主动执行存活检查，直到启动时的邻居都通过了检查
+
+//go:linkname doRevalidate github.com/ethereum/go-ethereum/p2p/discover.(*Table).doRevalidate
+func doRevalidate(tab unsafe.Pointer, done chan<- struct{})
+
+// 执行一次存活检查，检查随机一个桶中的最后一个节点
+func revalidate(udp *discover.UDPv4) {
+	done := make(chan struct{}, 1)
+	doRevalidate(unsafe.Pointer(reflect.ValueOf(udp).Elem().FieldByName("tab").Pointer()), done)
+}
+
+// 路由表中通过了存活检查的节点
+func liveNodes(udp *discover.UDPv4) map[enode.ID]bool {
+	tab := reflect.ValueOf(udp).Elem().FieldByName("tab").Elem()
+	mutex := (*sync.Mutex)(unsafe.Pointer(tab.FieldByName("mutex").UnsafeAddr()))
+	mutex.Lock()
+	defer mutex.Unlock()
+	rs := make(map[enode.ID]bool)
+	buckets := tab.FieldByName("buckets")
+	for i := 0; i < buckets.Len(); i++ {
+		entries := buckets.Index(i).Elem().FieldByName("entries")
+		for j := 0; j < entries.Len(); j++ {
+			n := entries.Index(j).Elem()
+			if n.FieldByName("livenessChecks").Uint() > 0 {
+				rs[(*enode.Node)(unsafe.Pointer(n.FieldByName("Node").UnsafeAddr())).ID()] = true
+			}
+		}
+	}
+	return rs
+}
+
+// 启动时的邻居是否都通过了存活检查
+func (n *Node) settled() bool {
+	live := liveNodes(n.udp)
+	for _, nb := range n.Neighbors() {
+		if !live[nb.ID()] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/netutil"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// address表记录从三个来源观察到的节点地址
+// 键格式：a<节点ID的十六进制>
+// 值：json格式的Addresses
+// 三个来源分别是其他节点返回的邻居、节点自己的enr记录以及pong数据包的来源地址
+var addressPrefix = "a"
+
+// 一个来源最后一次观察到的地址
+type Observation struct {
+	IP   string
+	UDP  int
+	TCP  int   // pong的来源地址没有tcp端口
+	Time int64 // 观察到的时间戳
+}
+
+func (o *Observation) same(other *Observation) bool {
+	return o.IP == other.IP && o.UDP == other.UDP && o.TCP == other.TCP
+}
+
+type Addresses struct {
+	ID       string
+	Neighbor *Observation // 其他节点邻居中的地址
+	ENR      *Observation // enr记录中的ip、udp、tcp
+	Pong     *Observation // pong数据包的来源地址
+}
+
+// 地址的分类
+type NATClass int
+
+const (
+	NATUnknown    NATClass = iota // 没有可以比较的两个来源
+	NATConsistent                 // 所有来源的地址一致
+	NATMapped                     // ip一致但是端口不同，存在端口映射
+	NATTranslated                 // 邻居和enr的ip不一致，位于NAT之后
+	NATPrivate                    // 公布了私有或者不可路由的地址
+	natClassCount
+)
+
+func (c NATClass) String() string {
+	switch c {
+	case NATUnknown:
+		return "unknown"
+	case NATConsistent:
+		return "consistent"
+	case NATMapped:
+		return "port mapped"
+	case NATTranslated:
+		return "nat"
+	case NATPrivate:
+		return "private"
+	}
+	return "unknown"
+}
+
+// 不能从公网访问的地址
+func unroutable(ip string) bool {
+	addr := net.ParseIP(ip)
+	return addr == nil || addr.IsUnspecified() || netutil.IsLAN(addr) || netutil.IsSpecialNetwork(addr)
+}
+
+func (o *Observation) known() bool {
+	return o != nil && o.IP != ""
+}
+
+// 根据观察到的地址对节点分类
+// 邻居中的地址是其他节点收到数据包的来源地址，enr是节点自己公布的地址，两者的ip不同说明节点位于NAT之后
+// v4协议按照ip匹配pong，pong的来源ip总是等于ping的目标ip，不能用来判断NAT，
+// 只在与邻居的ip相同时比较udp端口，端口不同说明回复时使用的端口被映射
+func (a *Addresses) Class() NATClass {
+	for _, o := range []*Observation{a.Neighbor, a.ENR} {
+		if o.known() && unroutable(o.IP) {
+			return NATPrivate
+		}
+	}
+	pong := a.Pong.known() && a.Neighbor.known() && a.Pong.IP == a.Neighbor.IP
+	if pong && a.Pong.UDP != a.Neighbor.UDP {
+		return NATMapped
+	}
+	if a.Neighbor.known() && a.ENR.known() {
+		if a.Neighbor.IP != a.ENR.IP {
+			return NATTranslated
+		}
+		if a.Neighbor.UDP != a.ENR.UDP || (a.Neighbor.TCP != 0 && a.ENR.TCP != 0 && a.Neighbor.TCP != a.ENR.TCP) {
+			return NATMapped
+		}
+		return NATConsistent
+	}
+	if pong {
+		return NATConsistent
+	}
+	return NATUnknown
+}
+
+func addressKey(id enode.ID) []byte {
+	return []byte(addressPrefix + hex.EncodeToString(id[:]))
+}
+
+func (l *Logger) getAddresses(id enode.ID) *Addresses {
+	v, err := l.db.Get(addressKey(id), nil)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return nil
+		}
+		panic(err)
+	}
+	a := new(Addresses)
+	if err := json.Unmarshal(v, a); err != nil {
+		return nil
+	}
+	return a
+}
+
+// 来源的类型
+type addrSource int
+
+const (
+	sourceNeighbor addrSource = iota
+	sourceENR
+	sourcePong
+)
+
+// 记录一次观察到的地址，地址没有变化时按照seenResolution的精度更新
+func (l *Logger) writeObservation(id enode.ID, source addrSource, o *Observation) {
+	a := l.getAddresses(id)
+	if a == nil {
+		a = &Addresses{ID: id.String()}
+	}
+	field := &a.Neighbor
+	switch source {
+	case sourceENR:
+		field = &a.ENR
+	case sourcePong:
+		field = &a.Pong
+	}
+	old := *field
+	if old != nil && old.same(o) && o.Time-old.Time < int64(seenResolution/time.Second) {
+		return
+	}
+	*field = o
+	v, err := json.Marshal(a)
+	if err != nil {
+		panic(err)
+	}
+	if err := l.db.Put(addressKey(id), v, nil); err != nil {
+		panic(err)
+	}
+}
+
+// 节点记录中的地址，没有ip时返回空的ip
+func nodeObservation(n *enode.Node) *Observation {
+	o := &Observation{UDP: n.UDP(), TCP: n.TCP(), Time: time.Now().Unix()}
+	if n.IP() != nil {
+		o.IP = n.IP().String()
+	}
+	return o
+}
+
+// pong数据包的来源地址，格式为ip:port
+func pongObservation(from string) *Observation {
+	host, port, err := net.SplitHostPort(from)
+	if err != nil {
+		return nil
+	}
+	udp, _ := strconv.Atoi(port)
+	return &Observation{IP: host, UDP: udp, Time: time.Now().Unix()}
+}
+
+// 查询节点观察到的地址，参数和Lifecycle相同，不存在返回nil
+func (l *Logger) Addresses(s string) *Addresses {
+	id, ok := parseNodeID(s)
+	if !ok {
+		return nil
+	}
+	l.dbLock.RLock()
+	defer l.dbLock.RUnlock()
+	return l.getAddresses(id)
+}
+
+// 每个分类的节点个数
+type NATReport map[string]int
+
+func (l *Logger) NATReport() NATReport {
+	l.dbLock.RLock()
+	defer l.dbLock.RUnlock()
+	rs := make(NATReport)
+	for c := NATClass(0); c < natClassCount; c++ {
+		rs[c.String()] = 0
+	}
+	l.scanKeys(addressPrefix, func(key string, v []byte) {
+		var a Addresses
+		if err := json.Unmarshal(v, &a); err != nil {
+			return
+		}
+		rs[a.Class().String()]++
+	})
+	return rs
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"net"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+func TestAddressClass(t *testing.T) {
+	obs := func(ip string, udp, tcp int) *Observation {
+		return &Observation{IP: ip, UDP: udp, TCP: tcp}
+	}
+	cases := []struct {
+		a    Addresses
+		want NATClass
+	}{
+		{Addresses{Neighbor: obs("1.2.3.4", 30303, 30303)}, NATUnknown},
+		{Addresses{Neighbor: obs("1.2.3.4", 30303, 30303), ENR: obs("1.2.3.4", 30303, 30303), Pong: obs("1.2.3.4", 30303, 0)}, NATConsistent},
+		{Addresses{Neighbor: obs("1.2.3.4", 30303, 30303), Pong: obs("1.2.3.4", 30303, 0)}, NATConsistent},
+		{Addresses{Neighbor: obs("1.2.3.4", 30303, 30303), Pong: obs("1.2.3.4", 41000, 0)}, NATMapped},
+		{Addresses{Neighbor: obs("1.2.3.4", 30303, 30303), ENR: obs("1.2.3.4", 30304, 30303)}, NATMapped},
+		{Addresses{Neighbor: obs("5.6.7.8", 30303, 30303), ENR: obs("1.2.3.4", 30303, 30303), Pong: obs("5.6.7.8", 30303, 0)}, NATTranslated},
+		// pong的ip只能等于ping的目标，没有邻居地址时无法比较
+		{Addresses{ENR: obs("1.2.3.4", 30303, 30303), Pong: obs("5.6.7.8", 30303, 0)}, NATUnknown},
+		{Addresses{Neighbor: obs("5.6.7.8", 30303, 30303), ENR: obs("192.168.1.2", 30303, 30303)}, NATPrivate},
+		{Addresses{Neighbor: obs("127.0.0.1", 30303, 30303)}, NATPrivate},
+	}
+	for i, c := range cases {
+		if got := c.a.Class(); got != c.want {
+			t.Errorf("case %d: got %v, want %v", i, got, c.want)
+		}
+	}
+}
+
+func TestNATReport(t *testing.T) {
+	l := newTestLogger(t)
+	from := testNode(t, "10.0.0.1")
+	to := enode.NewV4(testNode(t, "1.2.3.4").Pubkey(), net.ParseIP("1.2.3.4"), 30303, 30303)
+	// 回复pong的端口与邻居中的不同
+	l.WriteRelation(DiscV4, from, to, 0)
+	l.WritePing(to, &PingResult{From: "1.2.3.4:41000"}, nil)
+	a := l.Addresses(to.ID().String())
+	if a == nil || a.Neighbor == nil || a.Pong == nil || a.Pong.UDP != 41000 || a.ENR != nil {
+		t.Fatalf("wrong addresses %+v", a)
+	}
+	// enr中公布的ip与其他节点看到的不同
+	nat := enode.NewV4(testNode(t, "5.6.7.8").Pubkey(), net.ParseIP("5.6.7.8"), 30303, 30303)
+	announced := enode.NewV4(nat.Pubkey(), net.ParseIP("9.9.9.9"), 30303, 30303)
+	l.WriteRelation(DiscV4, from, nat, 0)
+	l.WriteEnr(nat, announced, nil)
+	report := l.NATReport()
+	if report[NATMapped.String()] != 1 || report[NATTranslated.String()] != 1 || report[NATConsistent.String()] != 0 {
+		t.Fatalf("wrong report %v", report)
+	}
+}
@@ -3,12 +3,15 @@ package storage
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
+	"net"
 	"node_hunter/config"
 	"strconv"
 	"time"
 
 	"github.com/ethereum/go-ethereum/p2p/enode"
 	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/errors"
 	"github.com/syndtr/goleveldb/leveldb/filter"
 	"github.com/syndtr/goleveldb/leveldb/opt"
 	"github.com/syndtr/goleveldb/leveldb/util"
@@ -34,88 +37,70 @@ var relationMetaPrefix = relationPrefix + meta
 var relationDoingPrefix = relationMetaPrefix + doing
 var relationDonePrefix = relationMetaPrefix + done
 
-var todayRelationPrefix = relationDataPrefix + date
-var todayRelationDoingPrefix = relationDoingPrefix + date
-var todayRelationDonePrefix = relationDonePrefix + date
-
-var todayRlpxPrefix = rlpxPrefix + date
-var todayEnrPrefix = enrPrefix + date
-
 // 保存正在查询的日期
 var todayKey = metaPrefix + "today"
 var nodeCountKey = metaPrefix + "nodeCount"
 
-// 今天查询到的各个节点的关系个数，需要加上enode链接
-// 这里的enode链接里面只有一个端口指UDP端口，所有tcp端口不同的记录共用一个值
-var todayNodeRelationCount = metaPrefix + date + "nodeRelationCount"
-
-// 今天查询到的所有关系个数
-var todayRelationCount = metaPrefix + date + "relationCount"
-
 // 所有的关系个数
 var allRelationCount = metaPrefix + "relationCount"
 
-// 今天有多少个节点已经完成查询关系了
-var todayRelationDoneCount = metaPrefix + date + "relationDoneCount"
-
-var todayRlpxDoneCount = metaPrefix + date + "rlpxDoneCount"
 var allRlpxDoneCount = metaPrefix + "rlpxDoneCount"
-
-var todayEnrDoneCount = metaPrefix + date + "enrDoneCount"
 var allEnrDoneCount = metaPrefix + "enrDoneCount"
 
-func updateDate() {
-	todayRelationPrefix = relationDataPrefix + date
-	todayRelationDoingPrefix = relationDoingPrefix + date
-	todayRelationDonePrefix = relationDonePrefix + date
-	todayRlpxPrefix = rlpxPrefix + date
-	todayEnrPrefix = enrPrefix + date
-	todayNodeRelationCount = metaPrefix + date + "nodeRelationCount"
-	todayRelationCount = metaPrefix + date + "relationCount"
-	todayRelationDoneCount = metaPrefix + date + "relationDoneCount"
-	todayRlpxDoneCount = metaPrefix + date + "rlpxDoneCount"
-	todayEnrDoneCount = metaPrefix + date + "enrDoneCount"
-}
-
-// 数据库中键的类型
-type KeyType int
-
-const (
-	Node KeyType = iota
-	RelationData
-	RelationDoing
-	RelationDone
-	Rlpx
-	ENR
-	Meta
-	Unknown
-)
+// 今天的各个前缀和计数都由日期得到，每次使用时重新计算，开始新的一轮后自动使用新的日期
+func todayRelationDoingPrefix() string { return relationDoingPrefix + today() }
+func todayRelationDonePrefix() string  { return relationDonePrefix + today() }
 
-func keyType(key []byte) KeyType {
-	if bytes.HasPrefix(key, []byte(nodesPrefix)) {
-		return Node
-	} else if bytes.HasPrefix(key, []byte(relationDataPrefix)) {
-		return RelationData
-	} else if bytes.HasPrefix(key, []byte(relationDoingPrefix)) {
-		return RelationDoing
-	} else if bytes.HasPrefix(key, []byte(relationDonePrefix)) {
-		return RelationDone
-	} else if bytes.HasPrefix(key, []byte(rlpxPrefix)) {
-		return Rlpx
-	} else if bytes.HasPrefix(key, []byte(enrPrefix)) {
-		return ENR
-	} else if bytes.HasPrefix(key, []byte(metaPrefix)) {
-		return Meta
-	} else {
-		return Unknown
+func todayRlpxPrefix() string { return rlpxPrefix + today() }
+func todayEnrPrefix() string  { return enrPrefix + today() }
+
+// 今天有多少个节点已经完成查询关系了
+func todayRelationDoneCount() string { return metaPrefix + today() + "relationDoneCount" }
+
+func todayRlpxDoneCount() string { return metaPrefix + today() + "rlpxDoneCount" }
+func todayEnrDoneCount() string  { return metaPrefix + today() + "enrDoneCount" }
+
+// 每个协议在关系表中使用的键，协议标记插在日期之前
+type relationKeys struct {
+	data              string // 今天的关系记录前缀
+	doing             string // 今天正在查询的节点前缀
+	done              string // 今天查询完成的节点前缀
+	nodeRelationCount string // 今天各个节点的关系个数
+	relationCount     string // 今天的关系个数
+	relationDoneCount string // 今天完成查询的节点个数
+	allRelationCount  string // 所有的关系个数
+}
+
+func keysOf(p Protocol) relationKeys {
+	tag := p.tag()
+	d := today()
+	return relationKeys{
+		data:              relationDataPrefix + tag + d,
+		doing:             relationDoingPrefix + tag + d,
+		done:              relationDonePrefix + tag + d,
+		nodeRelationCount: metaPrefix + tag + d + "nodeRelationCount",
+		relationCount:     metaPrefix + tag + d + "relationCount",
+		relationDoneCount: metaPrefix + tag + d + "relationDoneCount",
+		allRelationCount:  metaPrefix + tag + "relationCount",
 	}
 }
 
+// 通过某个协议发现的节点个数，每个协议单独计数
+// 使用协议名而不是键中的协议标记，v4的计数不会与所有节点的计数nodeCountKey相同
+func nodeCountKeyOf(p Protocol) string {
+	return metaPrefix + p.String() + "nodeCount"
+}
+
 func openDB() *leveldb.DB {
 	o := &opt.Options{
 		Filter: filter.NewBloomFilter(10),
 	}
 	db, err := leveldb.OpenFile(config.DBPath, o)
+	// 异常退出可能导致数据库损坏，尝试修复
+	if errors.IsCorrupted(err) {
+		fmt.Println("database corrupted, recovering:", err)
+		db, err = leveldb.RecoverFile(config.DBPath, o)
+	}
 	if err != nil {
 		panic(err)
 	}
@@ -148,35 +133,52 @@ func (l *Logger) RemoveDate() {
 	l.db.Delete([]byte(todayKey), nil)
 }
 
-func (l *Logger) WriteNode(n *enode.Node) bool {
+// 记录通过协议p发现的节点
+// 节点第一次通过此协议发现时返回true，并加入此协议的等待列表
+func (l *Logger) WriteNode(n *enode.Node, p Protocol) bool {
 	l.dbLock.Lock()
 	defer l.dbLock.Unlock()
-	return l.writeNode(n)
+	return l.writeNode(n, p)
 }
 
-func (l *Logger) writeNode(n *enode.Node) bool {
-	if l.hasNode(n) {
+func (l *Logger) writeNode(n *enode.Node, p Protocol) bool {
+	key := []byte(nodesPrefix + n.URLv4())
+	v, err := l.db.Get(key, nil)
+	if err != nil && err != leveldb.ErrNotFound {
+		panic(err)
+	}
+	exist := err == nil
+	if exist && hasProtocol(v, p) {
 		return false
 	}
-	l.waitingLock.Lock()
-	l.waitingNodes = append(l.waitingNodes, n)
-	l.waitingLock.Unlock()
+	l.enqueue(p, n)
 
-	// 读取之前的个数，并自增
-	count := l.nodes()
-	count++
-
-	now := time.Now().Unix()
 	batch := leveldb.MakeBatch(100)
-	// 写入新的个数
-	batch.Put([]byte(nodeCountKey), int64ToBytes(int64(count)))
+	if exist {
+		// 已经记录的节点第一次通过此协议发现，追加协议标记
+		v = append(int64ToBytes(bytesToInt64(v[:8])), nodeProtocols(v)...)
+	} else {
+		// 读取之前的个数，并自增
+		count := l.nodes()
+		count++
+		// 写入新的个数
+		batch.Put([]byte(nodeCountKey), int64ToBytes(int64(count)))
+		v = int64ToBytes(time.Now().Unix())
+	}
+	v = append(v, byte(p))
+	count := l.protocolNodes(p)
+	count++
+	batch.Put([]byte(nodeCountKeyOf(p)), int64ToBytes(int64(count)))
 	// 写入节点记录
-	batch.Put([]byte(nodesPrefix+n.URLv4()), int64ToBytes(now))
-	// 执行这两次写入操作
-	err := l.db.Write(batch, nil)
+	batch.Put(key, v)
+	err = l.db.Write(batch, nil)
 	if err != nil {
 		panic(err)
 	}
+	// 新的节点记录可能是已知的节点ID换了地址
+	if !exist {
+		l.observe(n, false)
+	}
 	return true
 }
 
@@ -193,6 +195,22 @@ func (l *Logger) hasNode(n *enode.Node) bool {
 	return ret
 }
 
+// 查询节点是通过哪些协议发现的
+func (l *Logger) nodeProtocols(n *enode.Node) []Protocol {
+	v, err := l.db.Get([]byte(nodesPrefix+n.URLv4()), nil)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return nil
+		}
+		panic(err)
+	}
+	var ps []Protocol
+	for _, b := range nodeProtocols(v) {
+		ps = append(ps, Protocol(b))
+	}
+	return ps
+}
+
 // 查询现在有多少节点记录
 func (l *Logger) nodes() int {
 	return l.readCount(nodeCountKey, nodesPrefix)
@@ -203,47 +221,99 @@ func (l *Logger) Nodes() int {
 	return l.nodes()
 }
 
-func (l *Logger) WriteRelation(from *enode.Node, to *enode.Node) bool {
+// 查询通过协议p发现的节点个数，只通过其他协议发现的节点不计算在内
+func (l *Logger) protocolNodes(p Protocol) int {
+	v, err := l.db.Get([]byte(nodeCountKeyOf(p)), nil)
+	if err != nil {
+		if err != leveldb.ErrNotFound {
+			panic(err)
+		}
+		// 没有计数，遍历所有节点记录
+		count := 0
+		iter := l.db.NewIterator(util.BytesPrefix([]byte(nodesPrefix)), nil)
+		for iter.Next() {
+			if hasProtocol(iter.Value(), p) {
+				count++
+			}
+		}
+		iter.Release()
+		if err := iter.Error(); err != nil {
+			panic(err)
+		}
+		return count
+	}
+	return int(bytesToInt64(v))
+}
+func (l *Logger) ProtocolNodes(p Protocol) int {
+	l.dbLock.RLock()
+	defer l.dbLock.RUnlock()
+	return l.protocolNodes(p)
+}
+
+// 关系中的身份序号占一个字节，最多使用这么多个本地身份
+const MaxIdentities = 255
+
+// 记录第identity个本地身份观察到from认识to
+// 关系的值是<时间戳><观察到关系的身份序号>，每个序号占一个字节
+// 已经存在的关系只追加新的身份序号，返回false
+func (l *Logger) WriteRelation(p Protocol, from *enode.Node, to *enode.Node, identity int) bool {
+	if identity < 0 || identity >= MaxIdentities {
+		panic(fmt.Sprintf("identity %d out of range", identity))
+	}
 	l.dbLock.Lock()
 	defer l.dbLock.Unlock()
-	if l.hasRelation(from, to) {
+	if !l.seenRecently(to) {
+		l.seen(to)
+		l.writeObservation(to.ID(), sourceNeighbor, nodeObservation(to))
+	}
+	keys := keysOf(p)
+	key := keys.data + parseFrom(from) + to.URLv4()
+	v, err := l.db.Get([]byte(key), nil)
+	if err == nil {
+		if len(v) > 8 && bytes.IndexByte(v[8:], byte(identity)) >= 0 {
+			return false
+		}
+		if err := l.db.Put([]byte(key), append(v, byte(identity)), nil); err != nil {
+			panic(err)
+		}
 		return false
+	} else if err != leveldb.ErrNotFound {
+		panic(err)
 	}
 	// 自增from的关系条数
-	count := l.nodeRelations(from)
+	count := l.nodeRelations(p, from)
 	count++
 	batch := leveldb.MakeBatch(100)
-	batch.Put([]byte(todayNodeRelationCount+parseFrom(from)), int64ToBytes(int64(count)))
+	batch.Put([]byte(keys.nodeRelationCount+parseFrom(from)), int64ToBytes(int64(count)))
 
 	// 自增今天的关系条数
-	count = l.todayRelations()
+	count = l.todayRelations(p)
 	count++
-	batch.Put([]byte(todayRelationCount), int64ToBytes(int64(count)))
+	batch.Put([]byte(keys.relationCount), int64ToBytes(int64(count)))
 
 	// 自增总关系条数
-	count = l.allRelations()
+	count = l.allRelations(p)
 	count++
-	batch.Put([]byte(allRelationCount), int64ToBytes(int64(count)))
+	batch.Put([]byte(keys.allRelationCount), int64ToBytes(int64(count)))
 
 	// 再写入具体的关系记录
-	key := todayRelationPrefix + parseFrom(from) + to.URLv4()
 	now := time.Now().Unix()
-	batch.Put([]byte(key), int64ToBytes(now))
-	err := l.db.Write(batch, nil)
+	batch.Put([]byte(key), append(int64ToBytes(now), byte(identity)))
+	err = l.db.Write(batch, nil)
 	if err != nil {
 		panic(err)
 	}
 	return true
 }
 
-func (l *Logger) HasRelation(from *enode.Node, to *enode.Node) bool {
+func (l *Logger) HasRelation(p Protocol, from *enode.Node, to *enode.Node) bool {
 	l.dbLock.RLock()
 	defer l.dbLock.RUnlock()
-	return l.hasRelation(from, to)
+	return l.hasRelation(p, from, to)
 }
 
-func (l *Logger) hasRelation(from *enode.Node, to *enode.Node) bool {
-	key := todayRelationPrefix + parseFrom(from) + to.URLv4()
+func (l *Logger) hasRelation(p Protocol, from *enode.Node, to *enode.Node) bool {
+	key := keysOf(p).data + parseFrom(from) + to.URLv4()
 	ret, err := l.db.Has([]byte(key), nil)
 	if err != nil {
 		panic(err)
@@ -252,14 +322,15 @@ func (l *Logger) hasRelation(from *enode.Node, to *enode.Node) bool {
 }
 
 // 统计某个节点认识的节点个数
-func (l *Logger) nodeRelations(from *enode.Node) int {
+func (l *Logger) nodeRelations(p Protocol, from *enode.Node) int {
+	keys := keysOf(p)
 	url := parseFrom(from)
-	return l.readCount(todayNodeRelationCount+url, todayRelationPrefix+url)
+	return l.readCount(keys.nodeRelationCount+url, keys.data+url)
 }
-func (l *Logger) NodeRelations(from *enode.Node) int {
+func (l *Logger) NodeRelations(p Protocol, from *enode.Node) int {
 	l.dbLock.RLock()
 	defer l.dbLock.RUnlock()
-	return l.nodeRelations(from)
+	return l.nodeRelations(p, from)
 }
 
 // 读取到关系条数后将数值写入数据库
@@ -271,10 +342,10 @@ func (l *Logger) NodeRelations(from *enode.Node) int {
 // 	return count
 // }
 
-func (l *Logger) TodayActives() int {
+func (l *Logger) TodayActives(p Protocol) int {
 	l.dbLock.RLock()
 	defer l.dbLock.RUnlock()
-	iter := l.db.NewIterator(util.BytesPrefix([]byte(todayNodeRelationCount)), nil)
+	iter := l.db.NewIterator(util.BytesPrefix([]byte(keysOf(p).nodeRelationCount)), nil)
 	count := 0
 	for iter.Next() {
 		count++
@@ -286,15 +357,23 @@ func (l *Logger) TodayActives() int {
 	return count
 }
 
-func (l *Logger) TodayActivesInfo() *Actives {
+func (l *Logger) TodayActivesInfo(p Protocol) *Actives {
 	l.dbLock.RLock()
 	defer l.dbLock.RUnlock()
 	rs := new(Actives)
-	iter := l.db.NewIterator(util.BytesPrefix([]byte(todayNodeRelationCount)), nil)
+	prefix := keysOf(p).nodeRelationCount
+	iter := l.db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
 	for iter.Next() {
-		url := string(iter.Key()[len(todayNodeRelationCount):])
+		url := string(iter.Key()[len(prefix):])
 		number := bytesToInt64(iter.Value())
-		rs.Nodes = append(rs.Nodes, ActiveNode{url, int(number)})
+		// 没有会话记录时完整度和估计值未知
+		node := ActiveNode{Url: url, Number: int(number), Completeness: -1, Estimate: -1}
+		if rec := l.readSession(todaySessionPrefix(p) + url); rec != nil {
+			node.Completeness = rec.Completeness
+			node.Estimate = rec.Estimate
+			node.StopReason = rec.StopReason
+		}
+		rs.Nodes = append(rs.Nodes, node)
 	}
 	iter.Release()
 	if err := iter.Error(); err != nil {
@@ -304,29 +383,55 @@ func (l *Logger) TodayActivesInfo() *Actives {
 }
 
 // 统计今天总共记录了多少条关系
-func (l *Logger) todayRelations() int {
-	return l.readCount(todayRelationCount, todayRelationPrefix)
+func (l *Logger) todayRelations(p Protocol) int {
+	keys := keysOf(p)
+	return l.readCount(keys.relationCount, keys.data)
 }
-func (l *Logger) TodayRelations() int {
+func (l *Logger) TodayRelations(p Protocol) int {
 	l.dbLock.RLock()
 	defer l.dbLock.RUnlock()
-	return l.todayRelations()
+	return l.todayRelations(p)
 }
 
 // 统计总共记录了多少条关系
-func (l *Logger) allRelations() int {
-	return l.readCount(allRelationCount, relationDataPrefix)
+// 没有计数时遍历所有日期的关系记录，v4的前缀relationDataPrefix同时是其他协议的前缀，需要按标记区分
+func (l *Logger) allRelations(p Protocol) int {
+	v, err := l.db.Get([]byte(keysOf(p).allRelationCount), nil)
+	if err == nil {
+		return int(bytesToInt64(v))
+	} else if err != leveldb.ErrNotFound {
+		panic(err)
+	}
+	count := 0
+	l.scanKeys(relationDataPrefix+p.tag(), func(key string, v []byte) {
+		if q, _ := splitTag(p.tag() + key); q == p {
+			count++
+		}
+	})
+	return count
 }
-func (l *Logger) AllRelations() int {
+func (l *Logger) AllRelations(p Protocol) int {
 	l.dbLock.RLock()
 	defer l.dbLock.RUnlock()
-	return l.allRelations()
+	return l.allRelations(p)
 }
 
-func (l *Logger) RelationDoing(from *enode.Node) {
+// 遍历前缀为prefix的键，回调的参数是去掉前缀的键
+func (l *Logger) scanKeys(prefix string, fn func(key string, v []byte)) {
+	iter := l.db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		fn(string(iter.Key()[len(prefix):]), iter.Value())
+	}
+	if err := iter.Error(); err != nil {
+		panic(err)
+	}
+}
+
+func (l *Logger) RelationDoing(p Protocol, from *enode.Node) {
 	l.dbLock.Lock()
 	defer l.dbLock.Unlock()
-	key := todayRelationDoingPrefix + from.URLv4()
+	key := keysOf(p).doing + from.URLv4()
 	now := time.Now().Unix()
 	err := l.db.Put([]byte(key), int64ToBytes(now), nil)
 	if err != nil {
@@ -334,13 +439,38 @@ func (l *Logger) RelationDoing(from *enode.Node) {
 	}
 }
 
-func (l *Logger) IsRelationDoing(from *enode.Node) bool {
+// 开始查询节点的关系，写入doing标记
+// 同一个节点ID今天已经查询完成或者正在以其他地址查询时不写入并返回false，节点换了端口不会被重复查询
+func (l *Logger) StartRelation(p Protocol, from *enode.Node) bool {
+	l.dbLock.Lock()
+	defer l.dbLock.Unlock()
+	if l.isRelationDone(p, from) {
+		return false
+	}
+	doing := keysOf(p).doing
+	addr := from.URLv4()[len(idPrefix(from)):]
+	other := false
+	l.scanKeys(doing+idPrefix(from), func(key string, v []byte) {
+		if key != addr {
+			other = true
+		}
+	})
+	if other {
+		return false
+	}
+	if err := l.db.Put([]byte(doing+from.URLv4()), int64ToBytes(time.Now().Unix()), nil); err != nil {
+		panic(err)
+	}
+	return true
+}
+
+func (l *Logger) IsRelationDoing(p Protocol, from *enode.Node) bool {
 	l.dbLock.RLock()
 	defer l.dbLock.RUnlock()
-	return l.isRelationDoing(from)
+	return l.isRelationDoing(p, from)
 }
-func (l *Logger) isRelationDoing(from *enode.Node) bool {
-	key := todayRelationDoingPrefix + from.URLv4()
+func (l *Logger) isRelationDoing(p Protocol, from *enode.Node) bool {
+	key := keysOf(p).doing + from.URLv4()
 	ret, err := l.db.Has([]byte(key), nil)
 	if err != nil {
 		panic(err)
@@ -352,25 +482,26 @@ func (l *Logger) isRelationDoing(from *enode.Node) bool {
 // 1. 删除doing标记
 // 2. 自增关系查询完成个数
 // 3. 记录查询完成
-func (l *Logger) RelationDone(from *enode.Node) {
+func (l *Logger) RelationDone(p Protocol, from *enode.Node) {
 	l.dbLock.Lock()
 	defer l.dbLock.Unlock()
-	// tcp端口不同的节点记录会导致Done重复调用，这里跳过
-	if l.isRelationDone(from) {
+	// 同一个节点ID的其他记录已经完成查询时跳过
+	if l.isRelationDone(p, from) {
 		return
 	}
+	keys := keysOf(p)
 
 	batch := leveldb.MakeBatch(100)
 	// 删除之前的doing标记
-	batch.Delete([]byte(todayRelationDoingPrefix + from.URLv4()))
+	batch.Delete([]byte(keys.doing + from.URLv4()))
 
 	// 自增查询完成的个数
-	count := l.todayRelationDones()
+	count := l.todayRelationDones(p)
 	count++
-	batch.Put([]byte(todayRelationDoneCount), int64ToBytes(int64(count)))
+	batch.Put([]byte(keys.relationDoneCount), int64ToBytes(int64(count)))
 
 	// 记录done标记
-	key := todayRelationDonePrefix + from.URLv4()
+	key := keys.done + from.URLv4()
 	now := time.Now().Unix()
 	batch.Put([]byte(key), int64ToBytes(now))
 	err := l.db.Write(batch, nil)
@@ -379,25 +510,36 @@ func (l *Logger) RelationDone(from *enode.Node) {
 	}
 }
 
-func (l *Logger) IsRelationDone(from *enode.Node) bool {
+// 没有查询节点的关系，只删除doing标记，例如不属于爬取的链的节点
+func (l *Logger) RelationSkipped(p Protocol, from *enode.Node) {
+	l.dbLock.Lock()
+	defer l.dbLock.Unlock()
+	if err := l.db.Delete([]byte(keysOf(p).doing+from.URLv4()), nil); err != nil {
+		panic(err)
+	}
+}
+
+func (l *Logger) IsRelationDone(p Protocol, from *enode.Node) bool {
 	l.dbLock.RLock()
 	defer l.dbLock.RUnlock()
-	return l.isRelationDone(from)
+	return l.isRelationDone(p, from)
 }
 
-func (l *Logger) isRelationDone(from *enode.Node) bool {
-	key := todayRelationDonePrefix + from.URLv4()
-	ret, err := l.db.Has([]byte(key), nil)
-	if err != nil {
+// 按节点ID判断，同一个节点ID的任意一个地址完成了查询都算作完成
+func (l *Logger) isRelationDone(p Protocol, from *enode.Node) bool {
+	iter := l.db.NewIterator(util.BytesPrefix([]byte(keysOf(p).done+idPrefix(from))), nil)
+	defer iter.Release()
+	ret := iter.Next()
+	if err := iter.Error(); err != nil {
 		panic(err)
 	}
 	return ret
 }
 
 // 当前有多少节点正在查询
-func (l *Logger) todayRelationDoings() int {
+func (l *Logger) todayRelationDoings(p Protocol) int {
 	doings := 0
-	doingIter := l.db.NewIterator(util.BytesPrefix([]byte(todayRelationDoingPrefix)), nil)
+	doingIter := l.db.NewIterator(util.BytesPrefix([]byte(keysOf(p).doing)), nil)
 	for doingIter.Next() {
 		doings++
 	}
@@ -407,25 +549,26 @@ func (l *Logger) todayRelationDoings() int {
 	}
 	return doings
 }
-func (l *Logger) TodayRelationDoings() int {
+func (l *Logger) TodayRelationDoings(p Protocol) int {
 	l.dbLock.RLock()
 	defer l.dbLock.RUnlock()
-	return l.todayRelationDoings()
+	return l.todayRelationDoings(p)
 }
 
 // 已经有多少节点查询完成了
-func (l *Logger) todayRelationDones() int {
-	return l.readCount(todayRelationDoneCount, todayRelationDonePrefix)
+func (l *Logger) todayRelationDones(p Protocol) int {
+	keys := keysOf(p)
+	return l.readCount(keys.relationDoneCount, keys.done)
 }
-func (l *Logger) TodayRelationDones() int {
+func (l *Logger) TodayRelationDones(p Protocol) int {
 	l.dbLock.RLock()
 	defer l.dbLock.RUnlock()
-	return l.todayRelationDones()
+	return l.todayRelationDones(p)
 }
 
 func (l *Logger) shouldRelation(url string) bool {
-	doingKey := todayRelationDoingPrefix + url
-	doneKey := todayRelationDonePrefix + url
+	doingKey := todayRelationDoingPrefix() + url
+	doneKey := todayRelationDonePrefix() + url
 	has1, err1 := l.db.Has([]byte(doingKey), nil)
 	has2, err2 := l.db.Has([]byte(doneKey), nil)
 
@@ -443,17 +586,17 @@ func (l *Logger) shouldRelation(url string) bool {
 	return true
 }
 
-func (l *Logger) GetWaiting() *enode.Node {
-	l.waitingLock.Lock()
-	defer l.waitingLock.Unlock()
-	if len(l.waitingNodes) == 0 {
-		return nil
+// 取出下一个等待通过协议p查询的节点
+// 取出协议p优先级最高的等待节点，没有等待的节点返回nil
+// 队列曾经溢出时从数据库补充没有查询的节点
+func (l *Logger) GetWaiting(p Protocol) *enode.Node {
+	q := l.queue(p)
+	n := q.Pop()
+	if n == nil && q.TakeOverflow() {
+		l.refill(p)
+		n = q.Pop()
 	}
-	first := l.waitingNodes[0]
-	// 置空，避免一直不能被垃圾回收
-	l.waitingNodes[0] = nil
-	l.waitingNodes = l.waitingNodes[1:]
-	return first
+	return n
 }
 
 func (l *Logger) NextNode() *enode.Node {
@@ -472,19 +615,26 @@ func (l *Logger) NextNode() *enode.Node {
 	return enode.MustParseV4(url)
 }
 
-func (l *Logger) WriteRlpx(n *enode.Node, info string) bool {
+// info为握手得到的<客户端信息>空格<各个协议>，err不为nil时记录分类后的错误
+func (l *Logger) WriteRlpx(n *enode.Node, info string, err error) bool {
 	l.dbLock.Lock()
 	defer l.dbLock.Unlock()
 
 	if l.hasRlpx(n) {
 		return false
 	}
+	l.writeProbe(n, ProbeRlpx, err == nil)
+	if err != nil {
+		info = string(encodeFailure(err))
+	} else {
+		info = "i" + info
+	}
 
 	batch := leveldb.MakeBatch(100)
 
 	count := l.todayRlpxs()
 	count++
-	batch.Put([]byte(todayRlpxDoneCount), int64ToBytes(int64(count)))
+	batch.Put([]byte(todayRlpxDoneCount()), int64ToBytes(int64(count)))
 
 	count = l.allRlpxs()
 	count++
@@ -494,10 +644,9 @@ func (l *Logger) WriteRlpx(n *enode.Node, info string) bool {
 	now := int64ToBytes(time.Now().Unix())
 	info = string(now) + info
 
-	batch.Put([]byte(todayRlpxPrefix+n.URLv4()), []byte(info))
+	batch.Put([]byte(todayRlpxPrefix()+n.URLv4()), []byte(info))
 
-	err := l.db.Write(batch, nil)
-	if err != nil {
+	if err := l.db.Write(batch, nil); err != nil {
 		panic(err)
 	}
 	return true
@@ -509,8 +658,29 @@ func (l *Logger) HasRlpx(n *enode.Node) bool {
 	return l.hasRlpx(n)
 }
 
+// 读取今天的rlpx记录，去掉时间戳，没有记录返回空字符串
+func (l *Logger) TodayRlpx(n *enode.Node) string {
+	return l.readTodayValue(todayRlpxPrefix() + n.URLv4())
+}
+
+func (l *Logger) readTodayValue(key string) string {
+	l.dbLock.RLock()
+	defer l.dbLock.RUnlock()
+	v, err := l.db.Get([]byte(key), nil)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return ""
+		}
+		panic(err)
+	}
+	if len(v) < 8 {
+		return ""
+	}
+	return string(v[8:])
+}
+
 func (l *Logger) hasRlpx(n *enode.Node) bool {
-	ret, err := l.db.Has([]byte(todayRlpxPrefix+n.URLv4()), nil)
+	ret, err := l.db.Has([]byte(todayRlpxPrefix()+n.URLv4()), nil)
 	if err != nil {
 		panic(err)
 	}
@@ -518,7 +688,7 @@ func (l *Logger) hasRlpx(n *enode.Node) bool {
 }
 
 func (l *Logger) todayRlpxs() int {
-	return l.readCount(todayRlpxDoneCount, todayRlpxPrefix)
+	return l.readCount(todayRlpxDoneCount(), todayRlpxPrefix())
 }
 func (l *Logger) TodayRlpxs() int {
 	l.dbLock.RLock()
@@ -541,10 +711,15 @@ func (l *Logger) WriteEnr(oldNode, newNode *enode.Node, err error) bool {
 	if l.hasEnr(oldNode) {
 		return false
 	}
+	l.writeProbe(oldNode, ProbeENR, newNode != nil && err == nil)
 	// 查询到的enr记录如果发生了更新，向数据库中写入最新的记录
 	if newNode != nil && err == nil {
+		l.writeObservation(newNode.ID(), sourceENR, nodeObservation(newNode))
 		if oldNode.URLv4() != newNode.URLv4() {
-			l.writeNode(newNode)
+			// 新记录沿用旧记录的协议标记
+			for _, p := range l.nodeProtocols(oldNode) {
+				l.writeNode(newNode, p)
+			}
 		}
 	}
 
@@ -552,7 +727,7 @@ func (l *Logger) WriteEnr(oldNode, newNode *enode.Node, err error) bool {
 
 	count := l.todayEnrs()
 	count++
-	batch.Put([]byte(todayEnrDoneCount), int64ToBytes(int64(count)))
+	batch.Put([]byte(todayEnrDoneCount()), int64ToBytes(int64(count)))
 
 	count = l.allEnrs()
 	count++
@@ -561,11 +736,11 @@ func (l *Logger) WriteEnr(oldNode, newNode *enode.Node, err error) bool {
 	now := int64ToBytes(time.Now().Unix())
 	str := string(now)
 	if err != nil {
-		str += "e" + err.Error()
+		str += string(encodeFailure(err))
 	} else {
 		str += "i" + newNode.String()
 	}
-	batch.Put([]byte(todayEnrPrefix+oldNode.URLv4()), []byte(str))
+	batch.Put([]byte(todayEnrPrefix()+oldNode.URLv4()), []byte(str))
 	err = l.db.Write(batch, nil)
 	if err != nil {
 		panic(err)
@@ -579,8 +754,13 @@ func (l *Logger) HasEnr(n *enode.Node) bool {
 	return l.hasEnr(n)
 }
 
+// 读取今天的enr记录，去掉时间戳，没有记录返回空字符串
+func (l *Logger) TodayEnr(n *enode.Node) string {
+	return l.readTodayValue(todayEnrPrefix() + n.URLv4())
+}
+
 func (l *Logger) hasEnr(n *enode.Node) bool {
-	ret, err := l.db.Has([]byte(todayEnrPrefix+n.URLv4()), nil)
+	ret, err := l.db.Has([]byte(todayEnrPrefix()+n.URLv4()), nil)
 	if err != nil {
 		panic(err)
 	}
@@ -588,7 +768,7 @@ func (l *Logger) hasEnr(n *enode.Node) bool {
 }
 
 func (l *Logger) todayEnrs() int {
-	return l.readCount(todayEnrDoneCount, todayEnrPrefix)
+	return l.readCount(todayEnrDoneCount(), todayEnrPrefix())
 }
 func (l *Logger) TodayEnrs() int {
 	l.dbLock.RLock()
@@ -630,26 +810,37 @@ func (l *Logger) readCount(countKey, prefix string) int {
 }
 
 func (l *Logger) RemoveDone() {
-	dones := l.todayRelationDones()
+	dones := 0
+	for _, p := range Protocols {
+		dones += l.todayRelationDones(p)
+	}
 	iter := l.db.NewIterator(util.BytesPrefix([]byte(relationDonePrefix)), nil)
 	for iter.Next() {
 		key := iter.Key()
-		if bytes.HasPrefix(key, []byte(todayRelationDonePrefix)) {
-			dones--
+		for _, p := range Protocols {
+			if bytes.HasPrefix(key, []byte(keysOf(p).done)) {
+				dones--
+			}
 		}
 		l.db.Delete(key, nil)
 	}
 	if dones != 0 {
 		panic("wrong done number")
 	}
-	l.db.Delete([]byte(todayRelationDoneCount), nil)
+	for _, p := range Protocols {
+		l.db.Delete([]byte(keysOf(p).relationDoneCount), nil)
+	}
 }
 
+// 去掉enode链接中的tcp端口，只保留ip和udp端口
+// IPv6地址需要加上方括号
 func parseFrom(n *enode.Node) string {
-	prefix := n.URLv4()[:137]
-	ip := n.IP().String()
-	udp := strconv.Itoa(n.UDP())
-	return prefix + ip + ":" + udp
+	return idPrefix(n) + net.JoinHostPort(n.IP().String(), strconv.Itoa(n.UDP()))
+}
+
+// enode链接中到节点ID为止的前缀，enode://<公钥>@，同一个节点ID的所有地址共用
+func idPrefix(n *enode.Node) string {
+	return n.URLv4()[:137]
 }
 
 func int64ToBytes(i int64) []byte {
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// dns表记录通过EIP-1459的DNS节点树获得的节点
+// 键格式：d<日期><节点树链接><空格><enode链接>
+// 值：<时间戳><节点树序号><enr记录>
+var dnsPrefix = "d"
+
+func todayDNSPrefix() string {
+	return dnsPrefix + today()
+}
+
+// 记录节点来自哪个节点树以及节点树的序号
+// 今天第一次记录返回true
+func (l *Logger) WriteDNSNode(tree string, seq uint, n *enode.Node) bool {
+	l.dbLock.Lock()
+	defer l.dbLock.Unlock()
+	key := []byte(todayDNSPrefix() + tree + " " + n.URLv4())
+	has, err := l.db.Has(key, nil)
+	if err != nil {
+		panic(err)
+	}
+	v := append(int64ToBytes(time.Now().Unix()), int64ToBytes(int64(seq))...)
+	v = append(v, n.String()...)
+	// 节点树更新后重复同步，覆盖为最新的序号
+	if err := l.db.Put(key, v, nil); err != nil {
+		panic(err)
+	}
+	return !has
+}
+
+// 一个节点树今天的同步结果与爬取结果的对比
+type DNSTree struct {
+	Url       string
+	Seq       uint // 最新的节点树序号
+	Nodes     int  // 节点树中的节点个数
+	Crawled   int  // 其中今天已经完成关系查询的节点个数
+	Responded int  // 其中今天返回了关系的节点个数
+}
+
+func (l *Logger) TodayDNSTrees() []DNSTree {
+	l.dbLock.RLock()
+	defer l.dbLock.RUnlock()
+	prefix := todayDNSPrefix()
+	trees := make(map[string]*DNSTree)
+	var urls []string
+	iter := l.db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	for iter.Next() {
+		key := string(iter.Key()[len(prefix):])
+		i := strings.LastIndex(key, " ")
+		if i < 0 {
+			continue
+		}
+		url := key[:i]
+		t, ok := trees[url]
+		if !ok {
+			t = &DNSTree{Url: url}
+			trees[url] = t
+			urls = append(urls, url)
+		}
+		if seq := uint(bytesToInt64(iter.Value()[8:16])); seq > t.Seq {
+			t.Seq = seq
+		}
+		t.Nodes++
+		n, err := enode.ParseV4(key[i+1:])
+		if err != nil {
+			continue
+		}
+		if l.isRelationDone(DiscV4, n) {
+			t.Crawled++
+		}
+		if l.nodeRelations(DiscV4, n) > 0 {
+			t.Responded++
+		}
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		panic(err)
+	}
+	rs := make([]DNSTree, 0, len(urls))
+	for _, url := range urls {
+		rs = append(rs, *trees[url])
+	}
+	return rs
+}
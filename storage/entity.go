@@ -0,0 +1,223 @@
+package storage
+
+import (
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// 节点表以enode链接作为键，同一个节点ID换了IP或者端口就会产生新的记录
+// lifecycle表中每个节点ID的记录同时保存这个节点用过的所有地址，作为节点的实体
+
+// 节点ID使用过的一个地址
+type Endpoint struct {
+	IP        string
+	UDP       int
+	TCP       int
+	FirstSeen int64 // 第一次使用这个地址的时间戳
+	LastSeen  int64 // 最后一次使用这个地址的时间戳
+}
+
+// 记录节点当前的地址，返回是否是新的地址
+func (c *Lifecycle) observe(n *enode.Node, now int64) bool {
+	ip := n.IP().String()
+	for i := range c.Endpoints {
+		e := &c.Endpoints[i]
+		if e.IP == ip && e.UDP == n.UDP() && e.TCP == n.TCP() {
+			e.LastSeen = now
+			return false
+		}
+	}
+	c.Endpoints = append(c.Endpoints, Endpoint{IP: ip, UDP: n.UDP(), TCP: n.TCP(), FirstSeen: now, LastSeen: now})
+	return true
+}
+
+// enode链接中的公钥部分，v4协议的公钥和节点ID一一对应
+func urlKey(url string) (string, bool) {
+	const scheme = "enode://"
+	if !strings.HasPrefix(url, scheme) || len(url) < len(scheme)+128 {
+		return "", false
+	}
+	return url[len(scheme) : len(scheme)+128], true
+}
+
+// 按照节点ID而不是enode链接统计的结果
+type EntityStats struct {
+	Records   int // 节点记录的条数
+	Nodes     int // 不同节点ID的个数
+	Moved     int // 使用过多个地址的节点ID个数
+	Relations int // 今天按照两端的节点ID去重后的关系条数
+	Actives   int // 今天返回了关系的节点ID个数
+}
+
+// 统计协议p按节点ID去重后的节点、关系和活跃节点个数
+func (l *Logger) TodayEntityStats(p Protocol) EntityStats {
+	l.dbLock.RLock()
+	defer l.dbLock.RUnlock()
+	var stats EntityStats
+
+	var ids idCounter
+	moved := false
+	l.scanKeys(nodesPrefix, func(key string, v []byte) {
+		if !hasProtocol(v, p) {
+			return
+		}
+		if id, ok := urlKey(key); ok {
+			stats.Records++
+			if ids.add(id) {
+				moved = false
+			} else if !moved {
+				moved = true
+				stats.Moved++
+			}
+		}
+	})
+	stats.Nodes = ids.count
+	stats.Relations = l.todayIDRelations(p, nil)
+	stats.Actives = l.todayActiveIDs(p, nil)
+	return stats
+}
+
+// 按节点ID去重计数
+// 以enode链接开头的键按照字典序排列，同一个节点ID的键是相邻的，只需要和上一个比较
+type idCounter struct {
+	last  string
+	count int
+}
+
+// 记录一个节点ID，返回它是否与上一个不同
+func (c *idCounter) add(id string) bool {
+	if c.count > 0 && id == c.last {
+		return false
+	}
+	c.last = id
+	c.count++
+	return true
+}
+
+// 按两端节点ID去重的关系个数
+// 同一个起点ID的关系是相邻的，只需要保存当前起点已经出现过的终点ID
+type relationCounter struct {
+	from  idCounter
+	tos   map[string]struct{}
+	count int
+	out   outIDs // 任意一端是链外节点的关系不统计
+}
+
+// 记录一条关系，group区分不同日期的关系，key是起点和终点的enode链接
+func (c *relationCounter) add(group, key string) {
+	from, ok := urlKey(key)
+	if !ok {
+		return
+	}
+	i := strings.Index(key[8:], "enode://")
+	if i < 0 {
+		return
+	}
+	to, ok := urlKey(key[8+i:])
+	if !ok || c.out.has(group, from) || c.out.has(group, to) {
+		return
+	}
+	if c.from.add(group + from) {
+		c.tos = make(map[string]struct{})
+	}
+	if _, ok := c.tos[to]; !ok {
+		c.tos[to] = struct{}{}
+		c.count++
+	}
+}
+
+// 不同节点ID的个数，match判断节点表中的值是否需要统计，今天标记为链外的节点ID不统计
+func (l *Logger) nodeIDs(match func(v []byte) bool, out outIDs) int {
+	var ids idCounter
+	d := today()
+	l.scanKeys(nodesPrefix, func(key string, v []byte) {
+		if !match(v) {
+			return
+		}
+		if id, ok := urlKey(key); ok && !out.has(d, id) {
+			ids.add(id)
+		}
+	})
+	return ids.count
+}
+
+// 所有节点按节点ID去重的个数，不包括今天标记为链外的节点
+func (l *Logger) NodeIDs() int {
+	l.dbLock.RLock()
+	defer l.dbLock.RUnlock()
+	return l.nodeIDs(func(v []byte) bool { return true }, l.outIDs(todayOutPrefix()))
+}
+
+// 通过协议p发现的节点按节点ID去重的个数，不包括今天标记为链外的节点
+func (l *Logger) ProtocolNodeIDs(p Protocol) int {
+	l.dbLock.RLock()
+	defer l.dbLock.RUnlock()
+	return l.nodeIDs(func(v []byte) bool { return hasProtocol(v, p) }, l.outIDs(todayOutPrefix()))
+}
+
+func (l *Logger) todayIDRelations(p Protocol, out outIDs) int {
+	c := relationCounter{out: out}
+	d := today()
+	l.scanKeys(keysOf(p).data, func(key string, v []byte) {
+		c.add(d, key)
+	})
+	return c.count
+}
+
+// 今天按两端节点ID去重的关系条数，不包括指向链外节点的关系
+func (l *Logger) TodayIDRelations(p Protocol) int {
+	l.dbLock.RLock()
+	defer l.dbLock.RUnlock()
+	return l.todayIDRelations(p, l.outIDs(todayOutPrefix()))
+}
+
+// 每天按两端节点ID去重的关系条数之和，需要遍历所有日期的关系
+// 不包括指向当天标记为链外节点的关系
+func (l *Logger) AllIDRelations(p Protocol) int {
+	l.dbLock.RLock()
+	defer l.dbLock.RUnlock()
+	c := relationCounter{out: l.outIDs(outPrefix)}
+	l.scanKeys(relationDataPrefix+p.tag(), func(key string, v []byte) {
+		q, rest := splitTag(p.tag() + key)
+		if q != p || len(rest) < 10 {
+			return
+		}
+		c.add(rest[:10], rest[10:])
+	})
+	return c.count
+}
+
+func (l *Logger) todayActiveIDs(p Protocol, out outIDs) int {
+	var ids idCounter
+	d := today()
+	l.scanKeys(keysOf(p).nodeRelationCount, func(key string, v []byte) {
+		if id, ok := urlKey(key); ok && !out.has(d, id) {
+			ids.add(id)
+		}
+	})
+	return ids.count
+}
+
+// 今天返回了关系的节点ID个数，不包括今天标记为链外的节点
+func (l *Logger) TodayActiveIDs(p Protocol) int {
+	l.dbLock.RLock()
+	defer l.dbLock.RUnlock()
+	return l.todayActiveIDs(p, l.outIDs(todayOutPrefix()))
+}
+
+// 节点出现在邻居中或者被写入节点表时更新地址
+// 新的地址总是写入，已有的地址按照seenResolution的精度更新
+func (l *Logger) observe(n *enode.Node, seen bool) {
+	c := l.readLifecycle(n)
+	now := time.Now().Unix()
+	changed := c.observe(n, now)
+	if seen && now-c.LastSeen >= int64(seenResolution/time.Second) {
+		c.LastSeen = now
+		changed = true
+	}
+	if changed {
+		l.writeLifecycle(n, c)
+	}
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"net"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+func TestEntityStats(t *testing.T) {
+	l := newTestLogger(t)
+	a := testNode(t, "10.0.0.1")
+	// 同一个节点ID换了IP和tcp端口
+	moved := enode.NewV4(a.Pubkey(), net.ParseIP("10.0.9.1"), 30304, 30303)
+	b := testNode(t, "10.0.1.1")
+	c := testNode(t, "10.0.2.1")
+	for _, n := range []*enode.Node{a, moved, b, c} {
+		l.WriteNode(n, DiscV4)
+	}
+	rec := l.Lifecycle(a.ID().String())
+	if rec == nil || len(rec.Endpoints) != 2 || rec.Endpoints[1].IP != "10.0.9.1" || rec.Endpoints[1].TCP != 30304 {
+		t.Fatalf("wrong endpoints %+v", rec)
+	}
+
+	// 两个地址查询到的相同关系只算一条
+	l.WriteRelation(DiscV4, a, b, 0)
+	l.WriteRelation(DiscV4, moved, b, 0)
+	l.WriteRelation(DiscV4, moved, c, 0)
+	l.WriteRelation(DiscV5, b, c, 0)
+	stats := l.TodayEntityStats(DiscV4)
+	want := EntityStats{Records: 4, Nodes: 3, Moved: 1, Relations: 2, Actives: 1}
+	if stats != want {
+		t.Fatalf("wrong stats %+v, want %+v", stats, want)
+	}
+	if l.TodayActives(DiscV4) != 2 || l.TodayRelations(DiscV4) != 3 {
+		t.Fatal("counts by enode url should not change")
+	}
+}
+
+// 默认的统计按节点ID去重，同一个节点ID换了地址不会被重复查询
+func TestCountByID(t *testing.T) {
+	l := newTestLogger(t)
+	a := testNode(t, "10.0.0.1")
+	moved := enode.NewV4(a.Pubkey(), net.ParseIP("10.0.0.1"), 30303, 30305)
+	b := testNode(t, "10.0.1.1")
+	for _, n := range []*enode.Node{a, moved, b} {
+		l.WriteNode(n, DiscV4)
+	}
+	l.WriteRelation(DiscV4, a, b, 0)
+	l.WriteRelation(DiscV4, moved, b, 0)
+
+	info := l.TodayInfo()
+	if info.Nodes != 2 || info.Relations != 1 || info.NodeRecords != 3 || info.RelationRecords != 2 {
+		t.Fatalf("wrong today info %+v", info)
+	}
+	if r := info.Records(); r.Nodes != 3 || r.Relations != 2 {
+		t.Fatalf("wrong records %+v", r)
+	}
+	if l.NodeIDs() != 2 || l.TodayActiveIDs(DiscV4) != 1 || l.TodayActives(DiscV4) != 2 {
+		t.Fatal("wrong node or active ids")
+	}
+	// 另一天的关系单独去重
+	setDate("2022-01-02")
+	l.WriteRelation(DiscV4, a, b, 0)
+	l.WriteRelation(DiscV5, a, b, 0)
+	if got := l.AllIDRelations(DiscV4); got != 2 {
+		t.Fatalf("got %d relations by id, want 2", got)
+	}
+
+	if !l.StartRelation(DiscV4, a) {
+		t.Fatal("first session should start")
+	}
+	if l.StartRelation(DiscV4, moved) {
+		t.Fatal("same id at another port should not start while the first is running")
+	}
+	// 中断之后继续查询同一个地址
+	if !l.StartRelation(DiscV4, a) {
+		t.Fatal("interrupted session should resume")
+	}
+	l.RelationDone(DiscV4, a)
+	if l.StartRelation(DiscV4, moved) || !l.IsRelationDone(DiscV4, moved) {
+		t.Fatal("same id at another port should be done")
+	}
+	if !l.StartRelation(DiscV5, moved) {
+		t.Fatal("other protocols should not be affected")
+	}
+}
+
+// 链外节点不计入按节点ID统计的个数
+func TestCountOutOfNetwork(t *testing.T) {
+	l := newTestLogger(t)
+	a := testNode(t, "10.0.0.1")
+	b := testNode(t, "10.0.1.1")
+	c := testNode(t, "10.0.2.1")
+	for _, n := range []*enode.Node{a, b, c} {
+		l.WriteNode(n, DiscV4)
+	}
+	l.WriteRelation(DiscV4, a, b, 0)
+	l.WriteRelation(DiscV4, c, b, 0)
+	l.WriteOutOfNetwork(c, "no eth entry")
+
+	info := l.TodayInfo()
+	if info.Nodes != 2 || info.Relations != 1 || info.NodeRecords != 3 || info.RelationRecords != 2 {
+		t.Fatalf("wrong today info %+v", info)
+	}
+	if l.TodayActiveIDs(DiscV4) != 1 || l.AllIDRelations(DiscV4) != 1 {
+		t.Fatal("out of network node should not be counted")
+	}
+	// 链外节点没有查询邻居，不算作查询完成
+	if !l.StartRelation(DiscV4, c) {
+		t.Fatal("relation should start")
+	}
+	l.RelationSkipped(DiscV4, c)
+	if info := l.TodayInfo(); info.RelationDone != 0 || info.RelationDoing != 0 || l.IsRelationDone(DiscV4, c) {
+		t.Fatalf("skipped node counted as crawled %+v", info)
+	}
+}
@@ -0,0 +1,250 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enr"
+)
+
+// 探测失败的原因分类，数值直接保存在数据库中，只能追加不能修改
+// 所有的值都小于0x20，旧记录的错误信息以可打印字符开头，可以与新记录区分
+type ErrorClass byte
+
+const (
+	ErrUnknown      ErrorClass = iota + 1
+	ErrTimeout                 // 等待回复、建立连接或者读写超时
+	ErrRefused                 // tcp连接被拒绝
+	ErrUnreachable             // 网络或者主机不可达
+	ErrReset                   // 连接被对方重置或者关闭
+	ErrHandshake               // RLPx加密握手失败
+	ErrDisconnect              // 对方发送了断开连接的消息，附带原因代码
+	ErrBadSignature            // enr记录的签名或者公钥无效
+	ErrBadRecord               // enr记录的ID或者IP与请求的节点不符
+	ErrProtocol                // 对方发送了不符合协议的消息
+	ErrNoEndpoint              // 节点没有可以连接的tcp地址
+	ErrResource                // 本地资源不足，例如打开的文件过多
+	ErrClosed                  // 本地的监听已经关闭
+)
+
+var errorClassNames = map[ErrorClass]string{
+	ErrUnknown:      "unknown",
+	ErrTimeout:      "timeout",
+	ErrRefused:      "refused",
+	ErrUnreachable:  "unreachable",
+	ErrReset:        "reset",
+	ErrHandshake:    "handshake failed",
+	ErrDisconnect:   "disconnect",
+	ErrBadSignature: "bad signature",
+	ErrBadRecord:    "bad record",
+	ErrProtocol:     "protocol error",
+	ErrNoEndpoint:   "no endpoint",
+	ErrResource:     "out of resource",
+	ErrClosed:       "closed",
+}
+
+func (c ErrorClass) String() string {
+	if s, ok := errorClassNames[c]; ok {
+		return s
+	}
+	return fmt.Sprintf("class %d", c)
+}
+
+// 分类后的错误，同时保留原始的错误信息
+type Failure struct {
+	Class   ErrorClass
+	Reason  p2p.DiscReason // 断开连接的原因代码，只对ErrDisconnect有效
+	Message string
+}
+
+func (f *Failure) Error() string {
+	return f.Message
+}
+
+// 统计时使用的分类名称，断开连接按照原因分别统计
+func (f *Failure) Kind() string {
+	if f.Class == ErrDisconnect {
+		return fmt.Sprintf("%s(%s)", f.Class, f.Reason)
+	}
+	return f.Class.String()
+}
+
+// 优先按照错误的类型分类，无法识别时再匹配错误信息
+func ClassifyError(err error) *Failure {
+	var f *Failure
+	if errors.As(err, &f) {
+		return f
+	}
+	f = &Failure{Message: err.Error()}
+	var reason p2p.DiscReason
+	var nerr net.Error
+	switch {
+	case errors.As(err, &reason):
+		f.Class, f.Reason = ErrDisconnect, reason
+	case errors.Is(err, syscall.ECONNREFUSED):
+		f.Class = ErrRefused
+	case errors.Is(err, syscall.ENETUNREACH), errors.Is(err, syscall.EHOSTUNREACH):
+		f.Class = ErrUnreachable
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		f.Class = ErrReset
+	case errors.Is(err, syscall.EMFILE), errors.Is(err, syscall.ENFILE):
+		f.Class = ErrResource
+	case errors.Is(err, net.ErrClosed):
+		f.Class = ErrClosed
+	case errors.Is(err, enr.ErrInvalidSig):
+		f.Class = ErrBadSignature
+	case errors.As(err, &nerr) && nerr.Timeout():
+		f.Class = ErrTimeout
+	default:
+		f.Class, f.Reason = classifyMessage(f.Message)
+	}
+	return f
+}
+
+// 错误信息中的关键字与分类，按顺序匹配
+var messageClasses = []struct {
+	substr string
+	class  ErrorClass
+}{
+	{"too many open files", ErrResource},
+	{"socket closed", ErrClosed},
+	{"use of closed network connection", ErrClosed},
+	{"timeout", ErrTimeout},
+	{"connection refused", ErrRefused},
+	{"no route to host", ErrUnreachable},
+	{"network is unreachable", ErrUnreachable},
+	{"host is unreachable", ErrUnreachable},
+	{"connection reset", ErrReset},
+	{"broken pipe", ErrReset},
+	{"EOF", ErrReset},
+	{"invalid signature", ErrBadSignature},
+	{"invalid public key", ErrBadSignature},
+	{"in response record", ErrBadRecord},
+	{"no tcp endpoint", ErrNoEndpoint},
+	{"ecies", ErrHandshake},
+	{"MAC", ErrHandshake},
+	{"expected handshake", ErrProtocol},
+	{"message too big", ErrProtocol},
+	{"rlp:", ErrProtocol},
+}
+
+// 只根据错误信息分类，用于旧记录以及无法识别类型的错误
+func classifyMessage(msg string) (ErrorClass, p2p.DiscReason) {
+	for r := p2p.DiscRequested; r <= p2p.DiscSubprotocolError; r++ {
+		if s := r.String(); s != "" && msg == s {
+			return ErrDisconnect, r
+		}
+	}
+	for _, mc := range messageClasses {
+		if strings.Contains(msg, mc.substr) {
+			return mc.class, 0
+		}
+	}
+	return ErrUnknown, 0
+}
+
+// 编码为数据库中的值：e<分类><断开原因，只有ErrDisconnect有><原始错误信息>
+func encodeFailure(err error) []byte {
+	f := ClassifyError(err)
+	v := []byte{'e', byte(f.Class)}
+	if f.Class == ErrDisconnect {
+		v = append(v, byte(f.Reason))
+	}
+	return append(v, f.Message...)
+}
+
+// 解码去掉时间戳的值，旧记录的错误信息没有分类，读取时按照错误信息分类
+// 不是错误记录时返回nil
+func decodeFailure(v []byte) *Failure {
+	if len(v) == 0 || v[0] != 'e' {
+		return nil
+	}
+	v = v[1:]
+	if len(v) == 0 || v[0] >= 0x20 {
+		f := &Failure{Message: string(v)}
+		f.Class, f.Reason = classifyMessage(f.Message)
+		return f
+	}
+	f := &Failure{Class: ErrorClass(v[0])}
+	v = v[1:]
+	if f.Class == ErrDisconnect && len(v) > 0 {
+		f.Reason = p2p.DiscReason(v[0])
+		v = v[1:]
+	}
+	f.Message = string(v)
+	return f
+}
+
+// 一种探测一天的结果
+type ProbeErrors struct {
+	Total  int            // 探测的节点个数
+	Failed int            // 失败的个数
+	Kinds  map[string]int // 每种失败原因的个数
+}
+
+// 一天中各种探测的失败原因统计
+type ErrorDay struct {
+	Date   string
+	Probes map[string]*ProbeErrors // 键为探测类型，findnode、enr、rlpx、ping
+}
+
+// 按日期和探测类型统计失败原因
+// enr、rlpx、ping表的值是<时间戳><e或i>...，findnode的结果来自session表
+func (l *Logger) ErrorDays() []ErrorDay {
+	l.dbLock.RLock()
+	defer l.dbLock.RUnlock()
+	days := make(map[string]*ErrorDay)
+	add := func(d string, probe Probe, f *Failure) {
+		day, ok := days[d]
+		if !ok {
+			day = &ErrorDay{Date: d, Probes: make(map[string]*ProbeErrors)}
+			days[d] = day
+		}
+		pe, ok := day.Probes[probe.String()]
+		if !ok {
+			pe = &ProbeErrors{Kinds: make(map[string]int)}
+			day.Probes[probe.String()] = pe
+		}
+		pe.Total++
+		if f != nil {
+			pe.Failed++
+			pe.Kinds[f.Kind()]++
+		}
+	}
+	for _, t := range []struct {
+		prefix string
+		probe  Probe
+	}{{enrPrefix, ProbeENR}, {rlpxPrefix, ProbeRlpx}, {pingPrefix, ProbePing}} {
+		probe := t.probe
+		l.scanKeys(t.prefix, func(key string, v []byte) {
+			if len(key) < 10 || len(v) <= 8 {
+				return
+			}
+			add(key[:10], probe, decodeFailure(v[8:]))
+		})
+	}
+	l.scanKeys(sessionPrefix, func(key string, v []byte) {
+		_, key = splitTag(key)
+		if len(key) < 10 {
+			return
+		}
+		rec := new(SessionRecord)
+		if err := json.Unmarshal(v, rec); err != nil {
+			return
+		}
+		add(key[:10], ProbeFindNode, rec.Failure)
+	})
+	ret := make([]ErrorDay, 0, len(days))
+	for _, day := range days {
+		ret = append(ret, *day)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Date < ret[j].Date })
+	return ret
+}
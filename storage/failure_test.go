@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/p2p"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		err  error
+		kind string
+	}{
+		{&net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, "refused"},
+		{&net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, "reset"},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.EHOSTUNREACH)}, "unreachable"},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("socket", syscall.EMFILE)}, "out of resource"},
+		{errors.New("RPC timeout"), "timeout"},
+		{p2p.DiscTooManyPeers, "disconnect(too many peers)"},
+		{fmt.Errorf("invalid IP in response record: %v", errors.New("special network")), "bad record"},
+		{errors.New("something else"), "unknown"},
+	}
+	for _, tt := range tests {
+		f := ClassifyError(tt.err)
+		if f.Kind() != tt.kind || f.Message != tt.err.Error() {
+			t.Errorf("%v: got %s %q, want %s", tt.err, f.Kind(), f.Message, tt.kind)
+		}
+		// 编码后可以还原
+		if d := decodeFailure(encodeFailure(tt.err)); *d != *f {
+			t.Errorf("%v: decoded %+v, want %+v", tt.err, d, f)
+		}
+	}
+	// 旧记录按照错误信息分类
+	if f := decodeFailure([]byte("etoo many peers")); f.Class != ErrDisconnect || f.Reason != p2p.DiscTooManyPeers {
+		t.Errorf("wrong legacy failure %+v", f)
+	}
+	if f := decodeFailure([]byte("iGeth/v1.10.13")); f != nil {
+		t.Errorf("success should not be a failure %+v", f)
+	}
+}
+
+func TestErrorDays(t *testing.T) {
+	l := newTestLogger(t)
+	a := testNode(t, "10.0.0.1")
+	b := testNode(t, "10.0.0.2")
+	c := testNode(t, "10.0.0.3")
+	l.WriteRlpx(a, "Geth/v1.10.13  eth/66", nil)
+	l.WriteRlpx(b, "", p2p.DiscTooManyPeers)
+	l.WriteRlpx(c, "", errors.New("RPC timeout"))
+	l.WritePing(a, nil, errors.New("RPC timeout"))
+	l.WriteEnr(a, nil, errors.New("invalid signature on node record"))
+	l.WriteSession(DiscV4, a, &SessionRecord{})
+	l.WriteSession(DiscV4, b, &SessionRecord{Failure: ClassifyError(errors.New("RPC timeout"))})
+	setDate("2022-01-02")
+	l.WriteRlpx(a, "", errors.New("RPC timeout"))
+
+	days := l.ErrorDays()
+	if len(days) != 2 || days[0].Date != "2022-01-01" {
+		t.Fatalf("wrong error days %+v", days)
+	}
+	rlpx := days[0].Probes["rlpx"]
+	if rlpx.Total != 3 || rlpx.Failed != 2 || rlpx.Kinds["disconnect(too many peers)"] != 1 || rlpx.Kinds["timeout"] != 1 {
+		t.Fatalf("wrong rlpx errors %+v", rlpx)
+	}
+	if p := days[0].Probes["ping"]; p.Failed != 1 || p.Kinds["timeout"] != 1 {
+		t.Fatalf("wrong ping errors %+v", p)
+	}
+	if p := days[0].Probes["enr"]; p.Failed != 1 || p.Kinds["bad signature"] != 1 {
+		t.Fatalf("wrong enr errors %+v", p)
+	}
+	if p := days[0].Probes["findnode"]; p.Total != 2 || p.Failed != 1 || p.Kinds["timeout"] != 1 {
+		t.Fatalf("wrong findnode errors %+v", p)
+	}
+	if p := days[1].Probes["rlpx"]; p.Total != 1 || p.Failed != 1 {
+		t.Fatalf("wrong rlpx errors of the second day %+v", p)
+	}
+}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// 保存各个本地身份的节点ID，键为identityKey加上身份序号
+var identityKey = metaPrefix + "identity"
+
+// 记录第i个本地身份使用的节点ID
+func (l *Logger) WriteIdentity(i int, id enode.ID) {
+	l.dbLock.Lock()
+	defer l.dbLock.Unlock()
+	if err := l.db.Put([]byte(identityKey+strconv.Itoa(i)), []byte(id.String()), nil); err != nil {
+		panic(err)
+	}
+}
+
+// 一个本地身份今天的观察结果
+// 只有所有身份共同查询的节点可以比较，Compared和Exclusive只统计这些节点的关系
+type IdentityStats struct {
+	Index     int
+	ID        string
+	Sessions  int // 使用此身份开始的查询会话个数
+	Relations int // 此身份观察到的关系个数
+	Compared  int // 所有身份共同查询的节点中此身份观察到的关系个数
+	Exclusive int // 所有身份共同查询的节点中只有此身份观察到的关系个数
+}
+
+// 统计今天每个本地身份观察到的v4关系
+func (l *Logger) TodayIdentityStats() []IdentityStats {
+	l.dbLock.RLock()
+	defer l.dbLock.RUnlock()
+	stats := make(map[int]*IdentityStats)
+	get := func(i int) *IdentityStats {
+		s, ok := stats[i]
+		if !ok {
+			s = &IdentityStats{Index: i}
+			if v, err := l.db.Get([]byte(identityKey+strconv.Itoa(i)), nil); err == nil {
+				s.ID = string(v)
+			} else if err != leveldb.ErrNotFound {
+				panic(err)
+			}
+			stats[i] = s
+		}
+		return s
+	}
+
+	// 会话记录的键和关系记录中的from都是去掉tcp端口的节点记录
+	compared := make(map[string]bool)
+	prefix := todaySessionPrefix(DiscV4)
+	l.scanKeys(prefix, func(key string, v []byte) {
+		if rec := l.readSession(prefix + key); rec != nil {
+			get(rec.Identity).Sessions++
+			if rec.Compared {
+				compared[key] = true
+			}
+		}
+	})
+
+	l.scanKeys(keysOf(DiscV4).data, func(key string, v []byte) {
+		// 旧的记录没有身份序号，都是第0个身份观察到的
+		ids := []byte{0}
+		if len(v) > 8 {
+			ids = v[8:]
+		}
+		for _, i := range ids {
+			get(int(i)).Relations++
+		}
+		i := strings.Index(key[1:], "enode://")
+		if i < 0 || !compared[key[:i+1]] {
+			return
+		}
+		for _, i := range ids {
+			get(int(i)).Compared++
+		}
+		if len(ids) == 1 {
+			get(int(ids[0])).Exclusive++
+		}
+	})
+
+	rs := make([]IdentityStats, 0, len(stats))
+	for _, s := range stats {
+		rs = append(rs, *s)
+	}
+	sort.Slice(rs, func(i, j int) bool { return rs[i].Index < rs[j].Index })
+	return rs
+}
@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+func TestIdentityStats(t *testing.T) {
+	l := newTestLogger(t)
+	from := enode.MustParseV4("enode://6da566ba5f4e82cf07969915fc6c0f8e33783ccd07561e68de51ec761606c648cb139f6f3142138707902224261cae4b4f4126141792f4250cb1d39aa7c73fce@77.170.227.84:30303")
+	other := enode.MustParseV4("enode://b20f2869495bead0de3ecc4ff2c966bfe0776cdb1103f296a58266343d4b101b12ae53459fe64b2c01df0f2e8839aea3632dfa9eb35b8a1904c139a96defa787@185.31.210.165:30303")
+	to1 := enode.MustParseV4("enode://40468e55b635e9513ed4cc54434b34c0f3866c4ac11d7d0827643e9184689a3325c55a00ddc6a8901fadfd018c646192e002544962410cb8ddce8ba6c2b9d350@168.119.18.20:13580?discport=30303")
+	to2 := enode.MustParseV4("enode://8935c9600d925fd46bdf9d1d155ae682c420d75e4546bfd1de4f9cd18c13aab8edd12a1222d34b10113b091f7d95e85e6c985db93086806535a28efd52002109@175.214.58.105:30303")
+
+	// from由两个身份共同查询，other只由身份0查询
+	if !l.WriteRelation(DiscV4, from, to1, 0) || l.WriteRelation(DiscV4, from, to1, 1) {
+		t.Fatal("relation should only be new for the first identity")
+	}
+	l.WriteRelation(DiscV4, from, to2, 1)
+	l.WriteSession(DiscV4, from, &SessionRecord{Identity: 1, Compared: true})
+	l.WriteRelation(DiscV4, other, to1, 0)
+	l.WriteRelation(DiscV4, other, to2, 0)
+	l.WriteSession(DiscV4, other, &SessionRecord{Identity: 0})
+	if l.TodayRelations(DiscV4) != 4 {
+		t.Fatalf("wrong relation count %d", l.TodayRelations(DiscV4))
+	}
+
+	stats := l.TodayIdentityStats()
+	if len(stats) != 2 {
+		t.Fatalf("wrong identity count %d", len(stats))
+	}
+	if s := stats[0]; s.Relations != 3 || s.Compared != 1 || s.Exclusive != 0 || s.Sessions != 1 {
+		t.Errorf("wrong stats for identity 0: %+v", s)
+	}
+	if s := stats[1]; s.Relations != 2 || s.Compared != 2 || s.Exclusive != 1 || s.Sessions != 1 {
+		t.Errorf("wrong stats for identity 1: %+v", s)
+	}
+}
+
+// 身份序号只占一个字节，超出范围直接panic而不是回绕
+func TestIdentityRange(t *testing.T) {
+	l := newTestLogger(t)
+	from := enode.MustParseV4("enode://6da566ba5f4e82cf07969915fc6c0f8e33783ccd07561e68de51ec761606c648cb139f6f3142138707902224261cae4b4f4126141792f4250cb1d39aa7c73fce@77.170.227.84:30303")
+	to := enode.MustParseV4("enode://40468e55b635e9513ed4cc54434b34c0f3866c4ac11d7d0827643e9184689a3325c55a00ddc6a8901fadfd018c646192e002544962410cb8ddce8ba6c2b9d350@168.119.18.20:13580?discport=30303")
+	l.WriteRelation(DiscV4, from, to, MaxIdentities-1)
+	defer func() {
+		if recover() == nil {
+			t.Fatal("identity out of range should panic")
+		}
+	}()
+	l.WriteRelation(DiscV4, from, to, MaxIdentities)
+}
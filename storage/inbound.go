@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"encoding/hex"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/p2p/discover/v4wire"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// inbound表记录其他节点主动发给爬虫的v4协议请求，按天汇总
+// 键格式：b<日期><节点ID的十六进制><包类型><来源地址>
+// 值：<第一次收到的时间戳><最后一次收到的时间戳><个数>
+var inboundPrefix = "b"
+
+func todayInboundPrefix() string {
+	return inboundPrefix + today()
+}
+
+// 我们刚刚查询过的节点发来的ping，是它在回复FINDNODE之前或者回复我们的ping之后证明我们的端点，不是主动的请求
+const InboundProofPing byte = 0xff
+
+// 一个节点从一个地址发来的一种请求
+type Inbound struct {
+	ID    enode.ID
+	From  string
+	Kind  byte // v4wire中的包类型或者InboundProofPing
+	First int64
+	Last  int64
+	Count int
+}
+
+func InboundKind(kind byte) string {
+	switch kind {
+	case v4wire.PingPacket:
+		return "ping"
+	case v4wire.FindnodePacket:
+		return "findnode"
+	case v4wire.ENRRequestPacket:
+		return "enrrequest"
+	case InboundProofPing:
+		return "proofping"
+	}
+	return "unknown"
+}
+
+// 合并到今天已有的记录中
+func (l *Logger) WriteInbound(rs []*Inbound) {
+	l.dbLock.Lock()
+	defer l.dbLock.Unlock()
+	batch := leveldb.MakeBatch(len(rs))
+	for _, r := range rs {
+		key := []byte(todayInboundPrefix() + hex.EncodeToString(r.ID[:]) + string([]byte{r.Kind}) + r.From)
+		first, count := r.First, int64(r.Count)
+		v, err := l.db.Get(key, nil)
+		if err != nil && err != leveldb.ErrNotFound {
+			panic(err)
+		}
+		if err == nil && len(v) == 24 {
+			first = bytesToInt64(v[:8])
+			count += bytesToInt64(v[16:])
+		}
+		v = append(int64ToBytes(first), int64ToBytes(r.Last)...)
+		v = append(v, int64ToBytes(count)...)
+		batch.Put(key, v)
+	}
+	if err := l.db.Write(batch, nil); err != nil {
+		panic(err)
+	}
+}
+
+// 一个节点一天内发来的请求个数
+type InboundNode struct {
+	ID       string
+	From     []string // 使用过的来源地址
+	Requests map[string]int
+}
+
+// 一天的统计
+type InboundDay struct {
+	Date     string
+	Nodes    int            // 发来主动请求的节点ID个数，只发来proofping的节点不计入
+	Requests map[string]int // 每种请求的个数，包括proofping
+	Top      []InboundNode  // 发送FINDNODE最多的节点，通常是其他爬虫
+}
+
+// 按日期统计所有记录，每天保留top个发送FINDNODE最多的节点
+func (l *Logger) InboundDays(top int) []InboundDay {
+	l.dbLock.RLock()
+	defer l.dbLock.RUnlock()
+	days := make(map[string]*InboundDay)
+	nodes := make(map[string]map[string]*InboundNode)
+	var dates []string
+	iter := l.db.NewIterator(util.BytesPrefix([]byte(inboundPrefix)), nil)
+	for iter.Next() {
+		key := string(iter.Key()[len(inboundPrefix):])
+		v := iter.Value()
+		if len(key) < 10+64+1 || len(v) != 24 {
+			continue
+		}
+		d, id, kind, from := key[:10], key[10:74], InboundKind(key[74]), key[75:]
+		day, ok := days[d]
+		if !ok {
+			day = &InboundDay{Date: d, Requests: make(map[string]int)}
+			days[d] = day
+			nodes[d] = make(map[string]*InboundNode)
+			dates = append(dates, d)
+		}
+		count := int(bytesToInt64(v[16:]))
+		day.Requests[kind] += count
+		if key[74] == InboundProofPing {
+			continue
+		}
+		n, ok := nodes[d][id]
+		if !ok {
+			n = &InboundNode{ID: id, Requests: make(map[string]int)}
+			nodes[d][id] = n
+		}
+		n.Requests[kind] += count
+		if len(n.From) == 0 || n.From[len(n.From)-1] != from {
+			n.From = append(n.From, from)
+		}
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		panic(err)
+	}
+	sort.Strings(dates)
+	rs := make([]InboundDay, 0, len(dates))
+	for _, d := range dates {
+		day := days[d]
+		day.Nodes = len(nodes[d])
+		for _, n := range nodes[d] {
+			day.Top = append(day.Top, *n)
+		}
+		sort.Slice(day.Top, func(i, j int) bool {
+			a, b := day.Top[i], day.Top[j]
+			if a.Requests["findnode"] != b.Requests["findnode"] {
+				return a.Requests["findnode"] > b.Requests["findnode"]
+			}
+			return a.ID < b.ID
+		})
+		if len(day.Top) > top {
+			day.Top = day.Top[:top]
+		}
+		rs = append(rs, *day)
+	}
+	return rs
+}
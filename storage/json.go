@@ -1,9 +1,14 @@
 package storage
 
 import (
+	"bufio"
+	"compress/gzip"
 	"encoding/json"
+	"fmt"
 	"io"
+	"node_hunter/config"
 	"os"
+	"strings"
 
 	"github.com/ethereum/go-ethereum/p2p/enode"
 )
@@ -18,15 +23,117 @@ func ReadNodes(path string) []*enode.Node {
 	if err != nil {
 		panic(err)
 	}
+	defer f.Close()
+	nodes, errs := readNodesJSON(f)
+	if len(errs) > 0 {
+		panic(errs[0])
+	}
+	return nodes
+}
+
+// 解析nodes.json格式的节点列表，无效的记录返回对应的错误
+func readNodesJSON(r io.Reader) ([]*enode.Node, []error) {
 	nodes := make(map[string]NodeRecord)
-	r := io.Reader(f)
 	if err := json.NewDecoder(r).Decode(&nodes); err != nil {
-		panic(err)
+		return nil, []error{err}
 	}
+	var errs []error
 	nodeList := []*enode.Node{}
-	for _, v := range nodes {
-		n := enode.MustParse(v.Record)
+	for k, v := range nodes {
+		n, err := ParseNode(v.Record)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("record %s: %v", k, err))
+			continue
+		}
 		nodeList = append(nodeList, n)
 	}
-	return nodeList
+	return nodeList, errs
+}
+
+// 读取种子节点文件，支持以下格式，以及它们经过gzip压缩的文件
+// 1. 以太坊官方维护的nodes.json
+// 2. 每行一个enode://或者enr:链接，空行和#开头的行被忽略
+// 返回所有有效的节点，以及每个无效记录的错误
+func ReadSeeds(path string) ([]*enode.Node, []error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, []error{err}
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	// gzip文件以0x1f 0x8b开头
+	if magic, err := r.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, []error{err}
+		}
+		defer gr.Close()
+		r = bufio.NewReader(gr)
+	}
+	// 第一个非空白字符是{说明是json格式
+	for {
+		b, err := r.Peek(1)
+		if err != nil {
+			// 空文件
+			if err == io.EOF {
+				return nil, nil
+			}
+			return nil, []error{err}
+		}
+		if b[0] == ' ' || b[0] == '\t' || b[0] == '\r' || b[0] == '\n' {
+			r.ReadByte()
+			continue
+		}
+		if b[0] == '{' {
+			return readNodesJSON(r)
+		}
+		break
+	}
+	return readNodeLines(r)
+}
+
+// 解析每行一个节点链接的列表
+func readNodeLines(r io.Reader) ([]*enode.Node, []error) {
+	var (
+		nodes []*enode.Node
+		errs  []error
+	)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), 1<<20)
+	line := 0
+	for scanner.Scan() {
+		line++
+		s := strings.TrimSpace(scanner.Text())
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+		n, err := ParseNode(s)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %v", line, err))
+			continue
+		}
+		nodes = append(nodes, n)
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	return nodes, errs
+}
+
+// 解析enode://或者enr:链接
+func ParseNode(s string) (*enode.Node, error) {
+	n, err := enode.Parse(enode.ValidSchemes, s)
+	if err != nil {
+		return nil, err
+	}
+	n = config.Dialable(n)
+	if n.IP() == nil || n.UDP() == 0 {
+		return nil, fmt.Errorf("missing udp endpoint: %s", s)
+	}
+	// 只支持secp256k1的节点，后面需要使用公钥生成enode链接
+	if n.Pubkey() == nil {
+		return nil, fmt.Errorf("unsupported identity scheme: %s", s)
+	}
+	return n, nil
 }
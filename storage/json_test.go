@@ -1,11 +1,19 @@
 package storage
 
 import (
+	"bytes"
+	"compress/gzip"
 	"fmt"
 	"node_hunter/config"
+	"os"
 	"path"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/enr"
 )
 
 func TestOutJson(t *testing.T) {
@@ -14,3 +22,47 @@ func TestOutJson(t *testing.T) {
 		fmt.Println(time.Now().Unix(), n.URLv4())
 	}
 }
+
+func TestReadSeeds(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	var r enr.Record
+	r.Set(enr.IPv4{10, 0, 0, 1})
+	r.Set(enr.UDP(30303))
+	enode.SignV4(&r, key)
+	record, _ := enode.New(enode.ValidSchemes, &r)
+
+	lines := strings.Join([]string{
+		"# seed nodes",
+		"enode://6da566ba5f4e82cf07969915fc6c0f8e33783ccd07561e68de51ec761606c648cb139f6f3142138707902224261cae4b4f4126141792f4250cb1d39aa7c73fce@77.170.227.84:30303",
+		"",
+		record.String(),
+		"enode://invalid@1.2.3.4:30303",
+	}, "\n")
+	json := `{"a": {"record": "` + record.String() + `"}, "b": {"record": "enr:-bad"}}`
+
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte(lines))
+	w.Close()
+
+	dir := t.TempDir()
+	tests := []struct {
+		name     string
+		content  []byte
+		accepted int
+		rejected int
+	}{
+		{"nodes.txt", []byte(lines), 2, 1},
+		{"nodes.txt.gz", gz.Bytes(), 2, 1},
+		{"nodes.json", []byte(json), 1, 1},
+		{"empty.txt", nil, 0, 0},
+	}
+	for _, test := range tests {
+		file := path.Join(dir, test.name)
+		os.WriteFile(file, test.content, 0666)
+		nodes, errs := ReadSeeds(file)
+		if len(nodes) != test.accepted || len(errs) != test.rejected {
+			t.Errorf("%s: accepted=%d rejected=%d, want %d %d", test.name, len(nodes), len(errs), test.accepted, test.rejected)
+		}
+	}
+}
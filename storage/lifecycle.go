@@ -0,0 +1,319 @@
+package storage
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// lifecycle表记录每个节点的生命周期
+// 键格式：l<节点ID的十六进制>
+// 值：json格式的Lifecycle
+var lifecyclePrefix = "l"
+
+// 最后出现时间的精度，在这个时间内重复出现不更新记录
+const seenResolution = 10 * time.Minute
+
+// 是否已经按照节点表补全了生命周期记录
+var lifecycleBackfillKey = metaPrefix + "lifecycleBackfill"
+
+// 对节点的探测类型
+type Probe byte
+
+const (
+	ProbeFindNode Probe = iota
+	ProbeENR
+	ProbeRlpx
+	ProbePing
+)
+
+func (p Probe) String() string {
+	switch p {
+	case ProbeFindNode:
+		return "findnode"
+	case ProbeENR:
+		return "enr"
+	case ProbeRlpx:
+		return "rlpx"
+	case ProbePing:
+		return "ping"
+	}
+	return "unknown"
+}
+
+// 节点的生命周期，时间都是时间戳，0代表没有发生过
+type Lifecycle struct {
+	ID           string
+	FirstSeen    int64 // 第一次发现
+	LastSeen     int64 // 最后一次出现在其他节点的邻居中
+	LastFindNode int64 // 最后一次响应FINDNODE
+	LastENR      int64 // 最后一次响应ENR请求
+	LastRlpx     int64 // 最后一次完成RLPx握手
+	LastPing     int64 // 最后一次响应ping
+	FailDays     int   // 连续探测失败的天数
+	FailDate     string
+	RespondDate  string
+	Endpoints    []Endpoint // 节点ID使用过的所有地址
+}
+
+// 最后一次响应任意探测的时间
+func (c *Lifecycle) LastResponded() int64 {
+	last := c.LastFindNode
+	for _, t := range []int64{c.LastENR, c.LastRlpx, c.LastPing} {
+		if t > last {
+			last = t
+		}
+	}
+	return last
+}
+
+func lifecycleKey(id enode.ID) []byte {
+	return []byte(lifecyclePrefix + hex.EncodeToString(id[:]))
+}
+
+// 读取节点的生命周期，不存在时创建新的记录
+// 之前的节点没有生命周期记录，使用节点表中的时间作为第一次发现的时间
+func (l *Logger) readLifecycle(n *enode.Node) *Lifecycle {
+	c := l.getLifecycle(n.ID())
+	if c != nil {
+		return c
+	}
+	c = &Lifecycle{ID: n.ID().String(), FirstSeen: time.Now().Unix()}
+	if v, err := l.db.Get([]byte(nodesPrefix+n.URLv4()), nil); err == nil && len(v) >= 8 {
+		c.FirstSeen = bytesToInt64(v[:8])
+	}
+	return c
+}
+
+func (l *Logger) getLifecycle(id enode.ID) *Lifecycle {
+	v, err := l.db.Get(lifecycleKey(id), nil)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return nil
+		}
+		panic(err)
+	}
+	c := new(Lifecycle)
+	if err := json.Unmarshal(v, c); err != nil {
+		return nil
+	}
+	return c
+}
+
+func (l *Logger) writeLifecycle(n *enode.Node, c *Lifecycle) {
+	v, err := json.Marshal(c)
+	if err != nil {
+		panic(err)
+	}
+	if err := l.db.Put(lifecycleKey(n.ID()), v, nil); err != nil {
+		panic(err)
+	}
+}
+
+// 节点出现在其他节点的邻居中
+func (l *Logger) seen(n *enode.Node) {
+	l.observe(n, true)
+}
+
+// 节点在当前的seenResolution时间段内是否已经以相同的地址出现过
+// 同一个节点会出现在很多节点的邻居中，重复出现时不需要读取和更新生命周期与地址记录
+// 需要持有dbLock的写锁
+func (l *Logger) seenRecently(n *enode.Node) bool {
+	now := time.Now().Unix()
+	if l.recent == nil || now-l.recentStart >= int64(seenResolution/time.Second) {
+		l.recent = make(map[enode.ID]string)
+		l.recentStart = now
+	}
+	url := n.URLv4()
+	if l.recent[n.ID()] == url {
+		return true
+	}
+	l.recent[n.ID()] = url
+	return false
+}
+
+// 生命周期表之前的节点没有记录，按照节点表补全一次，之后写入节点表时就会创建记录
+// 节点表只保存了发现的时间，作为第一次发现和最后一次出现的时间，响应的时间未知
+func (l *Logger) backfillLifecycle() {
+	l.dbLock.Lock()
+	defer l.dbLock.Unlock()
+	has, err := l.db.Has([]byte(lifecycleBackfillKey), nil)
+	if err != nil {
+		panic(err)
+	}
+	if has {
+		return
+	}
+	batch := new(leveldb.Batch)
+	// 节点表以enode链接为键，同一个节点ID的记录是相邻的
+	var c *Lifecycle
+	var id enode.ID
+	flush := func() {
+		if c == nil {
+			return
+		}
+		v, err := json.Marshal(c)
+		if err != nil {
+			panic(err)
+		}
+		batch.Put(lifecycleKey(id), v)
+		c = nil
+		if batch.Len() >= 1000 {
+			if err := l.db.Write(batch, nil); err != nil {
+				panic(err)
+			}
+			batch.Reset()
+		}
+	}
+	l.scanKeys(nodesPrefix, func(key string, v []byte) {
+		n, err := enode.ParseV4(key)
+		if err != nil || len(v) < 8 {
+			return
+		}
+		if c == nil || n.ID() != id {
+			flush()
+			if l.getLifecycle(n.ID()) != nil {
+				return
+			}
+			id = n.ID()
+			c = &Lifecycle{ID: id.String()}
+		}
+		t := bytesToInt64(v[:8])
+		if c.FirstSeen == 0 || t < c.FirstSeen {
+			c.FirstSeen = t
+		}
+		if t > c.LastSeen {
+			c.LastSeen = t
+		}
+		c.observe(n, t)
+	})
+	flush()
+	batch.Put([]byte(lifecycleBackfillKey), []byte{1})
+	if err := l.db.Write(batch, nil); err != nil {
+		panic(err)
+	}
+}
+
+// 记录一次探测的结果
+// 一天内有任意一次探测成功，连续失败天数归零；否则每天第一次失败时加一
+func (l *Logger) WriteProbe(n *enode.Node, probe Probe, ok bool) {
+	l.dbLock.Lock()
+	defer l.dbLock.Unlock()
+	l.writeProbe(n, probe, ok)
+}
+
+func (l *Logger) writeProbe(n *enode.Node, probe Probe, ok bool) {
+	c := l.readLifecycle(n)
+	d := today()
+	if ok {
+		now := time.Now().Unix()
+		switch probe {
+		case ProbeFindNode:
+			c.LastFindNode = now
+		case ProbeENR:
+			c.LastENR = now
+		case ProbeRlpx:
+			c.LastRlpx = now
+		case ProbePing:
+			c.LastPing = now
+		}
+		c.RespondDate = d
+		c.FailDays = 0
+	} else if c.RespondDate != d && c.FailDate != d {
+		c.FailDate = d
+		c.FailDays++
+	}
+	l.writeLifecycle(n, c)
+}
+
+// 解析节点ID，参数可以是节点ID、enode链接或者enr链接
+func parseNodeID(s string) (enode.ID, bool) {
+	var id enode.ID
+	if strings.HasPrefix(s, "enode://") || strings.HasPrefix(s, "enr:") {
+		n, err := enode.Parse(enode.ValidSchemes, s)
+		if err != nil {
+			return id, false
+		}
+		return n.ID(), true
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != len(id) {
+		return id, false
+	}
+	copy(id[:], b)
+	return id, true
+}
+
+// 查询节点的生命周期，参数可以是节点ID或者enode链接，不存在返回nil
+func (l *Logger) Lifecycle(s string) *Lifecycle {
+	id, ok := parseNodeID(s)
+	if !ok {
+		return nil
+	}
+	l.dbLock.RLock()
+	defer l.dbLock.RUnlock()
+	return l.getLifecycle(id)
+}
+
+// 按照最后出现和最后响应的时间统计节点个数
+type LifecycleStats struct {
+	Nodes          int
+	SeenDay        int         // 一天内出现在邻居中的节点
+	SeenWeek       int         // 一周内
+	SeenMonth      int         // 一个月内
+	RespondedDay   int         // 一天内响应过任意探测的节点
+	RespondedWeek  int         // 一周内
+	RespondedMonth int         // 一个月内
+	NeverResponded int         // 从来没有响应过
+	FailDays       map[int]int // 连续失败天数对应的节点个数，超过7天的计入7
+}
+
+func (l *Logger) LifecycleStats() LifecycleStats {
+	l.dbLock.RLock()
+	defer l.dbLock.RUnlock()
+	stats := LifecycleStats{FailDays: make(map[int]int)}
+	now := time.Now().Unix()
+	const day = 24 * 3600
+	count := func(t int64, d, w, m *int) {
+		switch {
+		case t == 0:
+		case now-t < day:
+			*d++
+			fallthrough
+		case now-t < 7*day:
+			*w++
+			fallthrough
+		case now-t < 30*day:
+			*m++
+		}
+	}
+	iter := l.db.NewIterator(util.BytesPrefix([]byte(lifecyclePrefix)), nil)
+	for iter.Next() {
+		var c Lifecycle
+		if err := json.Unmarshal(iter.Value(), &c); err != nil {
+			continue
+		}
+		stats.Nodes++
+		count(c.LastSeen, &stats.SeenDay, &stats.SeenWeek, &stats.SeenMonth)
+		responded := c.LastResponded()
+		if responded == 0 {
+			stats.NeverResponded++
+		}
+		count(responded, &stats.RespondedDay, &stats.RespondedWeek, &stats.RespondedMonth)
+		fail := c.FailDays
+		if fail > 7 {
+			fail = 7
+		}
+		stats.FailDays[fail]++
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		panic(err)
+	}
+	return stats
+}
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+func TestLifecycle(t *testing.T) {
+	l := newTestLogger(t)
+	from := testNode(t, "10.0.0.1")
+	to := testNode(t, "10.0.1.1")
+	l.WriteNode(from, DiscV4)
+	c := l.Lifecycle(from.ID().String())
+	if c == nil || c.FirstSeen == 0 || c.LastSeen != 0 || c.LastResponded() != 0 {
+		t.Fatalf("wrong lifecycle of a new node %+v", c)
+	}
+	if l.Lifecycle(from.URLv4()).ID != c.ID {
+		t.Fatal("lifecycle should be found by enode")
+	}
+
+	l.WriteRelation(DiscV4, from, to, 0)
+	if c := l.Lifecycle(to.ID().String()); c == nil || c.LastSeen == 0 {
+		t.Fatalf("neighbor should be seen %+v", c)
+	}
+
+	// 同一天多次失败只算一天
+	l.WritePing(from, nil, errors.New("timeout"))
+	l.WriteRlpx(from, "", errors.New("timeout"))
+	if c := l.Lifecycle(from.ID().String()); c.FailDays != 1 {
+		t.Fatalf("fail days should be 1, got %d", c.FailDays)
+	}
+	setDate("2022-01-02")
+	l.WriteProbe(from, ProbeFindNode, false)
+	if c := l.Lifecycle(from.ID().String()); c.FailDays != 2 {
+		t.Fatalf("fail days should be 2, got %d", c.FailDays)
+	}
+	l.WriteProbe(from, ProbeFindNode, true)
+	c = l.Lifecycle(from.ID().String())
+	if c.FailDays != 0 || c.LastFindNode == 0 || c.LastResponded() != c.LastFindNode {
+		t.Fatalf("wrong lifecycle after response %+v", c)
+	}
+	// 当天响应过之后的失败不增加失败天数
+	l.WriteProbe(from, ProbeENR, false)
+	if c := l.Lifecycle(from.ID().String()); c.FailDays != 0 {
+		t.Fatalf("fail days should stay 0, got %d", c.FailDays)
+	}
+
+	stats := l.LifecycleStats()
+	if stats.Nodes != 2 || stats.RespondedDay != 1 || stats.NeverResponded != 1 || stats.SeenDay != 1 || stats.FailDays[0] != 2 {
+		t.Fatalf("wrong lifecycle stats %+v", stats)
+	}
+}
+
+// 生命周期表之前的节点按照节点表补全，已有的记录不变
+func TestBackfillLifecycle(t *testing.T) {
+	l := newTestLogger(t)
+	old := testNode(t, "10.0.0.1")
+	moved := enode.NewV4(old.Pubkey(), net.ParseIP("10.0.0.2"), 30303, 30303)
+	fresh := testNode(t, "10.0.1.1")
+	l.WriteNode(fresh, DiscV4)
+	// 直接写入节点表，模拟之前没有生命周期记录的节点
+	for i, n := range []*enode.Node{old, moved} {
+		v := append(int64ToBytes(int64(1000+i)), byte(DiscV4))
+		if err := l.db.Put([]byte(nodesPrefix+n.URLv4()), v, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before := l.Lifecycle(fresh.ID().String())
+
+	l.backfillLifecycle()
+	c := l.Lifecycle(old.ID().String())
+	if c == nil || c.FirstSeen != 1000 || c.LastSeen != 1001 || len(c.Endpoints) != 2 || c.LastResponded() != 0 {
+		t.Fatalf("wrong backfilled lifecycle %+v", c)
+	}
+	if after := l.Lifecycle(fresh.ID().String()); after.FirstSeen != before.FirstSeen || after.LastSeen != before.LastSeen {
+		t.Fatalf("existing lifecycle changed: %+v", after)
+	}
+	if stats := l.LifecycleStats(); stats.Nodes != 2 || stats.NeverResponded != 2 {
+		t.Fatalf("wrong lifecycle stats %+v", stats)
+	}
+	// 只补全一次
+	if err := l.db.Delete(lifecycleKey(old.ID()), nil); err != nil {
+		t.Fatal(err)
+	}
+	l.backfillLifecycle()
+	if l.Lifecycle(old.ID().String()) != nil {
+		t.Fatal("backfill should only run once")
+	}
+}
+
+// 同一个时间段内重复出现的邻居只记录一次，换了地址时立即记录
+func TestSeenRecently(t *testing.T) {
+	l := newTestLogger(t)
+	from := testNode(t, "10.0.0.1")
+	to := testNode(t, "10.0.1.1")
+	l.WriteRelation(DiscV4, from, to, 0)
+	if err := l.db.Delete(lifecycleKey(to.ID()), nil); err != nil {
+		t.Fatal(err)
+	}
+	l.WriteRelation(DiscV4, testNode(t, "10.0.0.2"), to, 0)
+	if l.Lifecycle(to.ID().String()) != nil {
+		t.Fatal("repeated neighbor should not be written again")
+	}
+	moved := enode.NewV4(to.Pubkey(), net.ParseIP("10.0.1.2"), 30303, 30303)
+	l.WriteRelation(DiscV4, from, moved, 0)
+	if c := l.Lifecycle(to.ID().String()); c == nil || len(c.Endpoints) != 1 || c.Endpoints[0].IP != "10.0.1.2" {
+		t.Fatalf("moved neighbor should be written %+v", c)
+	}
+	if a := l.Addresses(to.ID().String()); a == nil || a.Neighbor.IP != "10.0.1.2" {
+		t.Fatalf("wrong neighbor address %+v", a)
+	}
+}
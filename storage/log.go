@@ -2,9 +2,11 @@ package storage
 
 import (
 	"fmt"
+	"net"
 	"node_hunter/config"
 	"os"
 	"sync"
+	"sync/atomic"
 
 	"github.com/ethereum/go-ethereum/p2p/enode"
 	"github.com/redmask-hb/GoSimplePrint/goPrint"
@@ -16,16 +18,32 @@ import (
 // 运行使用的日期
 // 如果之前的执行完了使用今天
 // 否则继续按照之前的日期查询
-var date string = ""
+// 开始新的一轮时修改，其他协程同时在读取，使用atomic.Value保存
+var date atomic.Value
+
+func today() string {
+	d, _ := date.Load().(string)
+	return d
+}
+
+func setDate(d string) {
+	date.Store(d)
+}
 
 type Logger struct {
-	// 记录所有节点
-	waitingNodes []*enode.Node
-	waitingLock  sync.Mutex
-	db           *leveldb.DB
-	dbLock       sync.RWMutex
-	nodeIter     iterator.Iterator
-	wg           sync.WaitGroup
+	// 记录每个协议等待查询的节点
+	queues      map[Protocol]*Scheduler
+	priority    Priority
+	newPriority func(l *Logger) Priority // 每轮爬取开始时重新创建优先级策略
+	waitingLock sync.Mutex
+	db          *leveldb.DB
+	dbLock      sync.RWMutex
+	nodeIter    iterator.Iterator
+	wg          sync.WaitGroup
+	listener    net.Listener // rpc服务的监听
+	closeOnce   sync.Once
+	recent      map[enode.ID]string // 当前时间段内已经记录过的邻居，见seenRecently
+	recentStart int64               // 当前时间段的开始时间
 }
 
 func createOrOpen(path string) (*os.File, error) {
@@ -39,61 +57,90 @@ func CreateOrOpen(path string) (*os.File, error) {
 // 输入若干种子节点，作为初始化节点
 // 如果输入nil，说明全部使用data文件夹内记录的节点
 func StartLog(seedNodes []*enode.Node, load bool) *Logger {
+	return StartLogWith(seedNodes, load, NewHistoryPriority)
+}
+
+// 加载等待查询的节点时使用newPriority创建的优先级策略
+// 不加载节点时只优先继续之前中断的节点
+func StartLogWith(seedNodes []*enode.Node, load bool, newPriority func(l *Logger) Priority) *Logger {
 	// 结束后删除今天的日期
 	os.MkdirAll(config.BasePath, 0777)
 	l := &Logger{
-		db: openDB(),
+		db:     openDB(),
+		queues: make(map[Protocol]*Scheduler),
 	}
-	date = l.queryDate()
-	updateDate()
+	setDate(l.queryDate())
+	l.backfillLifecycle()
 	// 启动rpc服务
-	startServer(l)
+	l.listener = startServer(l)
 
+	if config.RejectSybil {
+		l.loadSybil()
+	}
+	l.priority = NewFIFOPriority(l)
 	if load {
+		l.newPriority = newPriority
+		l.loadWaiting()
+	}
+	for _, seed := range seedNodes {
+		l.WriteNode(seed, DiscV4)
+	}
+	return l
+}
 
-		l.waitingNodes = make([]*enode.Node, 0, 500000)
-		// 数据库中保存的节点记录总数
-		nodes := l.Nodes()
+// 加载数据库中所有今天还没有查询完成的节点到等待队列
+func (l *Logger) loadWaiting() {
+	l.priority = l.newPriority(l)
+	// 数据库中保存的节点记录总数
+	nodes := l.Nodes()
 
-		// 生成进度条
-		i := 0
-		bar := goPrint.NewBar(nodes)
-		bar.SetNotice("loading nodes")
+	// 生成进度条
+	i := 0
+	bar := goPrint.NewBar(nodes)
+	bar.SetNotice("loading nodes")
 
-		iter := l.db.NewIterator(util.BytesPrefix([]byte(nodesPrefix)), nil)
-		// 加载所有节点
-		for iter.Next() {
-			if i%1000 == 0 {
-				bar.PrintBar(i)
-			}
-			url := string(iter.Key()[len(nodesPrefix):])
-			node := enode.MustParseV4(url)
-			// 加载还没完成查询的节点
-			if !l.IsRelationDone(node) && !config.Reject(node) {
-				// 之前没查询完成的放到等待列表的最前面
-				if l.IsRelationDoing(node) {
-					l.waitingNodes = append([]*enode.Node{node}, l.waitingNodes...)
-				} else {
-					l.waitingNodes = append(l.waitingNodes, node)
-				}
-			}
-			i++
+	iter := l.db.NewIterator(util.BytesPrefix([]byte(nodesPrefix)), nil)
+	// 加载所有节点
+	for iter.Next() {
+		if i%1000 == 0 {
+			bar.PrintBar(i)
 		}
-		if err := iter.Error(); err != nil {
-			panic(err)
+		url := string(iter.Key()[len(nodesPrefix):])
+		node := enode.MustParseV4(url)
+		// 按照发现节点的协议分别加载还没完成查询的节点
+		for _, b := range nodeProtocols(iter.Value()) {
+			p := Protocol(b)
+			if l.IsRelationDone(p, node) || l.IsOutOfNetwork(node) || config.Reject(node) {
+				continue
+			}
+			l.enqueue(p, node)
 		}
-		bar.PrintBar(nodes)
-		fmt.Println()
+		i++
 	}
-	for _, seed := range seedNodes {
-		l.WriteNode(seed)
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		panic(err)
 	}
-	return l
+	bar.PrintBar(nodes)
+	fmt.Println()
 }
 
+// 关闭rpc服务并删除socket文件，然后关闭数据库
+// 可以重复调用
 func (l *Logger) Close() error {
-	if l.nodeIter != nil {
-		l.nodeIter.Release()
-	}
-	return l.db.Close()
+	var err error
+	l.closeOnce.Do(func() {
+		if l.listener != nil {
+			l.listener.Close()
+			os.Remove(config.RpcPath)
+		}
+		// 等待正在进行的读写结束
+		l.dbLock.Lock()
+		defer l.dbLock.Unlock()
+		if l.nodeIter != nil {
+			l.nodeIter.Release()
+		}
+		err = l.db.Close()
+	})
+	return err
 }
@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"net/rpc"
+	"node_hunter/config"
+	"os"
+	"path"
+	"testing"
+)
+
+// 关闭Logger后删除socket文件，并且可以再次启动
+func TestLoggerClose(t *testing.T) {
+	config.BasePath = t.TempDir()
+	config.DBPath = path.Join(config.BasePath, "db")
+	config.RpcPath = path.Join(config.BasePath, "rpc")
+
+	for i := 0; i < 2; i++ {
+		l := StartLog(nil, false)
+		rc, err := rpc.DialHTTP("unix", config.RpcPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var nodes int
+		if err := rc.Call("Query.NodesCount", struct{}{}, &nodes); err != nil {
+			t.Fatal(err)
+		}
+		rc.Close()
+		if err := l.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(config.RpcPath); !os.IsNotExist(err) {
+			t.Fatal("rpc socket should be removed")
+		}
+		// 重复关闭不报错
+		if err := l.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// outnet表记录不属于爬取的链的节点，这些节点仍然记录在节点表中，但是不查询它们的邻居
+// 键格式：o<日期><enode链接>
+// 值：<时间戳><原因>，原因为没有eth条目或者不匹配的分叉ID
+var outPrefix = "o"
+
+func todayOutPrefix() string {
+	return outPrefix + today()
+}
+
+// 标记节点今天不属于爬取的链，已经标记过返回false
+func (l *Logger) WriteOutOfNetwork(n *enode.Node, reason string) bool {
+	l.dbLock.Lock()
+	defer l.dbLock.Unlock()
+	key := []byte(todayOutPrefix() + n.URLv4())
+	has, err := l.db.Has(key, nil)
+	if err != nil {
+		panic(err)
+	}
+	if has {
+		return false
+	}
+	v := append(int64ToBytes(time.Now().Unix()), reason...)
+	if err := l.db.Put(key, v, nil); err != nil {
+		panic(err)
+	}
+	return true
+}
+
+func (l *Logger) IsOutOfNetwork(n *enode.Node) bool {
+	l.dbLock.RLock()
+	defer l.dbLock.RUnlock()
+	return l.isOutOfNetwork(n)
+}
+
+func (l *Logger) isOutOfNetwork(n *enode.Node) bool {
+	has, err := l.db.Has([]byte(todayOutPrefix()+n.URLv4()), nil)
+	if err != nil {
+		panic(err)
+	}
+	return has
+}
+
+// 标记为链外的节点ID，键是日期加上enode链接中的公钥
+type outIDs map[string]struct{}
+
+// 节点ID在某一天是否被标记为链外节点，id是enode链接中的公钥
+func (o outIDs) has(date, id string) bool {
+	_, ok := o[date+id]
+	return ok
+}
+
+// 读取前缀为prefix的所有链外标记，prefix是outPrefix或者todayOutPrefix()
+func (l *Logger) outIDs(prefix string) outIDs {
+	rs := make(outIDs)
+	l.scanKeys(prefix, func(key string, v []byte) {
+		// 补上前缀中的日期
+		key = prefix[len(outPrefix):] + key
+		if len(key) < 10 {
+			return
+		}
+		if id, ok := urlKey(key[10:]); ok {
+			rs[key[:10]+id] = struct{}{}
+		}
+	})
+	return rs
+}
+
+// 今天标记为不属于爬取的链的节点个数
+func (l *Logger) TodayOutOfNetwork() int {
+	l.dbLock.RLock()
+	defer l.dbLock.RUnlock()
+	iter := l.db.NewIterator(util.BytesPrefix([]byte(todayOutPrefix())), nil)
+	count := 0
+	for iter.Next() {
+		count++
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		panic(err)
+	}
+	return count
+}
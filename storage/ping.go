@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// ping表记录每天对节点的存活探测结果
+// 键格式：p<日期><enode链接>
+// 值：<时间戳>i<rtt毫秒><pong中的enr序号><pong的来源地址>
+// 或者：<时间戳>e<错误分类><原始错误信息>
+var pingPrefix = "p"
+
+func todayPingPrefix() string {
+	return pingPrefix + today()
+}
+
+// 一次ping的结果
+type PingResult struct {
+	RTT  time.Duration
+	Seq  uint64 // pong中携带的enr序号
+	From string // pong数据包的来源地址
+}
+
+// 记录今天ping一个节点的结果，今天已经记录过返回false
+func (l *Logger) WritePing(n *enode.Node, rs *PingResult, err error) bool {
+	l.dbLock.Lock()
+	defer l.dbLock.Unlock()
+	key := []byte(todayPingPrefix() + n.URLv4())
+	has, e := l.db.Has(key, nil)
+	if e != nil {
+		panic(e)
+	}
+	if has {
+		return false
+	}
+	l.writeProbe(n, ProbePing, err == nil)
+	if err == nil {
+		if o := pongObservation(rs.From); o != nil {
+			l.writeObservation(n.ID(), sourcePong, o)
+		}
+	}
+	v := int64ToBytes(time.Now().Unix())
+	if err != nil {
+		v = append(v, encodeFailure(err)...)
+	} else {
+		v = append(v, 'i')
+		v = append(v, int64ToBytes(rs.RTT.Milliseconds())...)
+		v = append(v, int64ToBytes(int64(rs.Seq))...)
+		v = append(v, rs.From...)
+	}
+	if e := l.db.Put(key, v, nil); e != nil {
+		panic(e)
+	}
+	return true
+}
+
+func (l *Logger) HasPing(n *enode.Node) bool {
+	l.dbLock.RLock()
+	defer l.dbLock.RUnlock()
+	has, err := l.db.Has([]byte(todayPingPrefix()+n.URLv4()), nil)
+	if err != nil {
+		panic(err)
+	}
+	return has
+}
+
+// 统计rtt分布使用的区间上限，最后一个区间没有上限
+var RTTBuckets = []time.Duration{
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+// 一天的ping统计
+type PingDay struct {
+	Date      string
+	Pinged    int   // ping过的节点个数
+	Reachable int   // 返回了pong的节点个数
+	RTTs      []int // 落在RTTBuckets各个区间的节点个数，比RTTBuckets多一项
+	Median    time.Duration
+	P90       time.Duration
+}
+
+// 按日期统计所有的ping记录
+func (l *Logger) PingDays() []PingDay {
+	l.dbLock.RLock()
+	defer l.dbLock.RUnlock()
+	days := make(map[string]*PingDay)
+	rtts := make(map[string][]time.Duration)
+	var dates []string
+	iter := l.db.NewIterator(util.BytesPrefix([]byte(pingPrefix)), nil)
+	for iter.Next() {
+		key := iter.Key()[len(pingPrefix):]
+		v := iter.Value()
+		if len(key) < 10 || len(v) < 9 {
+			continue
+		}
+		d := string(key[:10])
+		day, ok := days[d]
+		if !ok {
+			day = &PingDay{Date: d, RTTs: make([]int, len(RTTBuckets)+1)}
+			days[d] = day
+			dates = append(dates, d)
+		}
+		day.Pinged++
+		if v[8] != 'i' || len(v) < 25 {
+			continue
+		}
+		day.Reachable++
+		rtt := time.Duration(bytesToInt64(v[9:17])) * time.Millisecond
+		rtts[d] = append(rtts[d], rtt)
+		i := sort.Search(len(RTTBuckets), func(i int) bool { return rtt < RTTBuckets[i] })
+		day.RTTs[i]++
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		panic(err)
+	}
+	sort.Strings(dates)
+	ret := make([]PingDay, 0, len(dates))
+	for _, d := range dates {
+		day := days[d]
+		day.Median = percentile(rtts[d], 0.5)
+		day.P90 = percentile(rtts[d], 0.9)
+		ret = append(ret, *day)
+	}
+	return ret
+}
+
+func percentile(ds []time.Duration, p float64) time.Duration {
+	if len(ds) == 0 {
+		return 0
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	return ds[int(float64(len(ds)-1)*p)]
+}
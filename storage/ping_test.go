@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+func TestPingDays(t *testing.T) {
+	l := newTestLogger(t)
+	a := enode.MustParseV4("enode://6da566ba5f4e82cf07969915fc6c0f8e33783ccd07561e68de51ec761606c648cb139f6f3142138707902224261cae4b4f4126141792f4250cb1d39aa7c73fce@77.170.227.84:30303")
+	b := enode.MustParseV4("enode://40468e55b635e9513ed4cc54434b34c0f3866c4ac11d7d0827643e9184689a3325c55a00ddc6a8901fadfd018c646192e002544962410cb8ddce8ba6c2b9d350@168.119.18.20:30303")
+
+	if !l.WritePing(a, &PingResult{RTT: 120 * time.Millisecond, Seq: 3, From: "77.170.227.84:30303"}, nil) {
+		t.Fatal("ping should be written")
+	}
+	if l.WritePing(a, nil, errors.New("RPC timeout")) {
+		t.Fatal("ping should be written once a day")
+	}
+	l.WritePing(b, nil, errors.New("RPC timeout"))
+	if !l.HasPing(b) {
+		t.Fatal("failed ping should be recorded")
+	}
+
+	days := l.PingDays()
+	if len(days) != 1 {
+		t.Fatalf("wrong days: %v", days)
+	}
+	d := days[0]
+	if d.Date != today() || d.Pinged != 2 || d.Reachable != 1 || d.Median != 120*time.Millisecond {
+		t.Fatalf("wrong ping day: %+v", d)
+	}
+	// 120ms落在100ms到200ms的区间
+	if d.RTTs[2] != 1 {
+		t.Fatalf("wrong rtt distribution: %v", d.RTTs)
+	}
+}
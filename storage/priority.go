@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"hash/fnv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// 决定等待查询的节点的优先级
+// 返回节点的类别以及同一类别内的分数，分数越高越先查询
+// 调用时可能持有数据库的锁，实现中不能调用加锁的Logger方法
+type Priority interface {
+	Priority(p Protocol, n *enode.Node) (Class, float64)
+}
+
+// 可以选择的优先级策略
+var Priorities = map[string]func(l *Logger) Priority{
+	"history": NewHistoryPriority,
+	"fifo":    NewFIFOPriority,
+}
+
+// 只优先继续之前中断的节点，其他节点按照加入的顺序查询
+type fifoPriority struct {
+	l *Logger
+}
+
+func NewFIFOPriority(l *Logger) Priority {
+	return fifoPriority{l}
+}
+
+func (f fifoPriority) Priority(p Protocol, n *enode.Node) (Class, float64) {
+	if f.l.isRelationDoing(p, n) {
+		return ClassResume, 0
+	}
+	return ClassFresh, 0
+}
+
+// 节点最近一次被查询的结果
+type contact struct {
+	day       int32 // 查询的日期，距离1970年的天数
+	relations int32 // 那一天查询到的关系个数
+}
+
+// 根据之前几天的查询结果决定优先级
+// 最近一次查询返回了关系的节点优先，其中关系多的优先；从来没有查询过的节点最后
+type historyPriority struct {
+	l       *Logger
+	history map[Protocol]map[uint64]contact
+}
+
+// 扫描今天以前的会话记录和每个节点的关系个数，只在内存中保存节点地址的哈希
+func NewHistoryPriority(l *Logger) Priority {
+	h := &historyPriority{
+		l:       l,
+		history: make(map[Protocol]map[uint64]contact),
+	}
+	for _, p := range Protocols {
+		h.history[p] = make(map[uint64]contact)
+	}
+	// 有会话记录说明查询过这个节点
+	h.scan(sessionPrefix, "", func(p Protocol, url string, day int32, v []byte) {
+		h.update(p, url, contact{day: day})
+	})
+	// 关系个数只有返回了关系的节点才有
+	h.scan(metaPrefix, "nodeRelationCount", func(p Protocol, url string, day int32, v []byte) {
+		h.update(p, url, contact{day: day, relations: int32(bytesToInt64(v))})
+	})
+	return h
+}
+
+// 遍历<prefix><协议标记><日期><name><地址>格式的键，跳过今天的记录
+func (h *historyPriority) scan(prefix, name string, fn func(p Protocol, url string, day int32, v []byte)) {
+	iter := h.l.db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		p, key := splitTag(string(iter.Key()[len(prefix):]))
+		if len(key) < 10 {
+			continue
+		}
+		d, err := time.Parse("2006-01-02", key[:10])
+		if err != nil || key[:10] == today() {
+			continue
+		}
+		rest := key[10:]
+		if !strings.HasPrefix(rest, name) {
+			continue
+		}
+		fn(p, rest[len(name):], int32(d.Unix()/86400), iter.Value())
+	}
+	if err := iter.Error(); err != nil {
+		panic(err)
+	}
+}
+
+// 保留最近一天的记录，同一天有关系个数的优先
+func (h *historyPriority) update(p Protocol, url string, c contact) {
+	key := hashURL(url)
+	old, ok := h.history[p][key]
+	if !ok || c.day > old.day || (c.day == old.day && c.relations > old.relations) {
+		h.history[p][key] = c
+	}
+}
+
+func hashURL(url string) uint64 {
+	f := fnv.New64a()
+	f.Write([]byte(url))
+	return f.Sum64()
+}
+
+func (h *historyPriority) Priority(p Protocol, n *enode.Node) (Class, float64) {
+	if h.l.isRelationDoing(p, n) {
+		return ClassResume, 0
+	}
+	c, ok := h.history[p][hashURL(parseFrom(n))]
+	if !ok {
+		return ClassFresh, 0
+	}
+	if c.relations > 0 {
+		return ClassResponsive, float64(c.relations)
+	}
+	// 没有返回关系的节点最近查询过的靠后
+	return ClassUnresponsive, -float64(c.day)
+}
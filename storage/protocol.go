@@ -0,0 +1,66 @@
+package storage
+
+import "strings"
+
+// 节点发现协议的版本
+// 节点表的值中记录节点是通过哪些协议发现的，关系表的键中记录关系属于哪个协议
+type Protocol byte
+
+const (
+	DiscV4 Protocol = '4'
+	DiscV5 Protocol = '5'
+)
+
+// 所有支持的协议
+var Protocols = []Protocol{DiscV4, DiscV5}
+
+func (p Protocol) String() string {
+	return "v" + string(p)
+}
+
+// 写入键中的协议标记，插入在日期之前
+// v4的标记为空，保持与之前的数据兼容；日期以数字开头，所以v4的前缀不会同时是其他协议的前缀
+func (p Protocol) tag() string {
+	if p == DiscV4 {
+		return ""
+	}
+	return p.String()
+}
+
+// 拆分<协议标记><剩余部分>格式的键，没有标记的属于v4
+func splitTag(key string) (Protocol, string) {
+	for _, p := range Protocols {
+		if tag := p.tag(); tag != "" && strings.HasPrefix(key, tag) {
+			return p, key[len(tag):]
+		}
+	}
+	return DiscV4, key
+}
+
+// 解析命令行中输入的协议名称
+func ParseProtocol(s string) (Protocol, bool) {
+	for _, p := range Protocols {
+		if s == p.String() {
+			return p, true
+		}
+	}
+	return 0, false
+}
+
+// 节点表的值是<时间戳><协议标记>
+// 之前的记录只有时间戳，说明是通过v4协议发现的
+func nodeProtocols(v []byte) []byte {
+	if len(v) <= 8 {
+		return []byte{byte(DiscV4)}
+	}
+	return v[8:]
+}
+
+func hasProtocol(v []byte, p Protocol) bool {
+	for _, b := range nodeProtocols(v) {
+		if Protocol(b) == p {
+			return true
+		}
+	}
+	return false
+}
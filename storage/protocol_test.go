@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"node_hunter/config"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// 使用临时目录创建Logger，不启动rpc服务
+func newTestLogger(t *testing.T) *Logger {
+	config.DBPath = t.TempDir()
+	l := &Logger{
+		db:     openDB(),
+		queues: make(map[Protocol]*Scheduler),
+	}
+	setDate("2022-01-01")
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func TestProtocolTag(t *testing.T) {
+	l := newTestLogger(t)
+	from := enode.MustParseV4("enode://6da566ba5f4e82cf07969915fc6c0f8e33783ccd07561e68de51ec761606c648cb139f6f3142138707902224261cae4b4f4126141792f4250cb1d39aa7c73fce@77.170.227.84:30303")
+	to := enode.MustParseV4("enode://40468e55b635e9513ed4cc54434b34c0f3866c4ac11d7d0827643e9184689a3325c55a00ddc6a8901fadfd018c646192e002544962410cb8ddce8ba6c2b9d350@168.119.18.20:13580?discport=30303")
+
+	if !l.WriteNode(from, DiscV4) || l.WriteNode(from, DiscV4) {
+		t.Fatal("v4 node should be written once")
+	}
+	if !l.WriteNode(from, DiscV5) {
+		t.Fatal("v5 tag should be added to an existing node")
+	}
+	if l.Nodes() != 1 || l.ProtocolNodes(DiscV5) != 1 {
+		t.Fatalf("wrong node count: all=%d v5=%d", l.Nodes(), l.ProtocolNodes(DiscV5))
+	}
+	// 只通过v5发现的节点不计入v4的节点个数
+	if !l.WriteNode(to, DiscV5) {
+		t.Fatal("v5 node should be written")
+	}
+	if l.Nodes() != 2 || l.ProtocolNodes(DiscV4) != 1 || l.ProtocolNodes(DiscV5) != 2 {
+		t.Fatalf("wrong node count: all=%d v4=%d v5=%d", l.Nodes(), l.ProtocolNodes(DiscV4), l.ProtocolNodes(DiscV5))
+	}
+	if info := l.TodayInfo(); info.NodeRecords != 1 || info.Nodes != 1 || info.V5NodeRecords != 2 {
+		t.Fatalf("wrong today info %+v", info)
+	}
+	// 没有计数时遍历节点记录
+	if err := l.db.Delete([]byte(nodeCountKeyOf(DiscV4)), nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := l.ProtocolNodes(DiscV4); got != 1 {
+		t.Fatalf("got %d v4 nodes without the counter, want 1", got)
+	}
+	if l.GetWaiting(DiscV4) == nil || l.GetWaiting(DiscV5) == nil {
+		t.Fatal("node should wait for both protocols")
+	}
+
+	l.WriteRelation(DiscV5, from, to, 0)
+	if l.HasRelation(DiscV4, from, to) || !l.HasRelation(DiscV5, from, to) {
+		t.Fatal("relation should only belong to v5")
+	}
+	if l.NodeRelations(DiscV4, from) != 0 || l.NodeRelations(DiscV5, from) != 1 {
+		t.Fatal("wrong relation count")
+	}
+	l.RelationDone(DiscV5, from)
+	if l.IsRelationDone(DiscV4, from) || !l.IsRelationDone(DiscV5, from) {
+		t.Fatal("done sign should only belong to v5")
+	}
+}
+
+// v4和v5的关系、doing标记和会话记录混在一起时，按前缀遍历和没有计数时的统计不能互相包含
+func TestProtocolKeysDisjoint(t *testing.T) {
+	l := newTestLogger(t)
+	from := enode.MustParseV4("enode://6da566ba5f4e82cf07969915fc6c0f8e33783ccd07561e68de51ec761606c648cb139f6f3142138707902224261cae4b4f4126141792f4250cb1d39aa7c73fce@77.170.227.84:30303")
+	to1 := enode.MustParseV4("enode://40468e55b635e9513ed4cc54434b34c0f3866c4ac11d7d0827643e9184689a3325c55a00ddc6a8901fadfd018c646192e002544962410cb8ddce8ba6c2b9d350@168.119.18.20:13580?discport=30303")
+	to2 := enode.MustParseV4("enode://8935c9600d925fd46bdf9d1d155ae682c420d75e4546bfd1de4f9cd18c13aab8edd12a1222d34b10113b091f7d95e85e6c985db93086806535a28efd52002109@175.214.58.105:30303")
+
+	l.WriteRelation(DiscV4, from, to1, 0)
+	l.WriteRelation(DiscV5, from, to1, 0)
+	l.WriteRelation(DiscV5, from, to2, 1)
+	l.RelationDoing(DiscV4, from)
+	l.RelationDoing(DiscV5, from)
+	l.RelationDoing(DiscV5, to1)
+	l.WriteSession(DiscV4, from, &SessionRecord{Identity: 0})
+	l.WriteSession(DiscV5, from, &SessionRecord{Identity: 1})
+
+	if got := l.TodayRelationDoings(DiscV4); got != 1 {
+		t.Errorf("got %d v4 doings, want 1", got)
+	}
+	if got := l.TodayRelationDoings(DiscV5); got != 2 {
+		t.Errorf("got %d v5 doings, want 2", got)
+	}
+	// 删除计数，按前缀遍历统计
+	for _, p := range Protocols {
+		keys := keysOf(p)
+		for _, key := range []string{keys.relationCount, keys.allRelationCount, keys.nodeRelationCount + parseFrom(from)} {
+			if err := l.db.Delete([]byte(key), nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for _, c := range []struct {
+		p                     Protocol
+		today, all, relations int
+	}{{DiscV4, 1, 1, 1}, {DiscV5, 2, 2, 2}} {
+		if got := l.TodayRelations(c.p); got != c.today {
+			t.Errorf("%s: got %d relations today, want %d", c.p, got, c.today)
+		}
+		if got := l.AllRelations(c.p); got != c.all {
+			t.Errorf("%s: got %d relations, want %d", c.p, got, c.all)
+		}
+		if got := l.NodeRelations(c.p, from); got != c.relations {
+			t.Errorf("%s: got %d node relations, want %d", c.p, got, c.relations)
+		}
+	}
+
+	stats := l.TodayIdentityStats()
+	if len(stats) != 1 || stats[0].Relations != 1 || stats[0].Sessions != 1 {
+		t.Errorf("v5 rows counted in identity stats: %+v", stats)
+	}
+	if got := l.TodayEntityStats(DiscV4).Relations; got != 1 {
+		t.Errorf("got %d v4 entity relations, want 1", got)
+	}
+	days := l.ErrorDays()
+	if len(days) != 1 || days[0].Date != today() || days[0].Probes[ProbeFindNode.String()].Total != 2 {
+		t.Errorf("wrong session days %+v", days)
+	}
+}
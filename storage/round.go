@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// 每一轮爬取结束时记录的结果
+// 键格式：mround<日期>
+// 值：json格式的RoundRecord
+var roundPrefix = metaPrefix + "round"
+
+type RoundRecord struct {
+	Date      string
+	Start     int64 // 这一轮开始的时间戳
+	End       int64 // 这一轮结束的时间戳
+	Completed bool  // 是否所有节点都查询完成，否则是到达了时间间隔
+	Info      DBInfo
+}
+
+// 今天的统计数据
+func (l *Logger) TodayInfo() DBInfo {
+	var info DBInfo
+	info.Nodes = l.ProtocolNodeIDs(DiscV4)
+	info.Relations = l.TodayIDRelations(DiscV4)
+	info.NodeRecords = l.ProtocolNodes(DiscV4)
+	info.RelationRecords = l.TodayRelations(DiscV4)
+	info.RelationDoing = l.TodayRelationDoings(DiscV4)
+	info.RelationDone = l.TodayRelationDones(DiscV4)
+	info.Rlpxs = l.TodayRlpxs()
+	info.Enrs = l.TodayEnrs()
+
+	info.V5Nodes = l.ProtocolNodeIDs(DiscV5)
+	info.V5Relations = l.TodayIDRelations(DiscV5)
+	info.V5NodeRecords = l.ProtocolNodes(DiscV5)
+	info.V5RelationRecords = l.TodayRelations(DiscV5)
+	info.V5RelationDoing = l.TodayRelationDoings(DiscV5)
+	info.V5RelationDone = l.TodayRelationDones(DiscV5)
+	info.OutOfNetwork = l.TodayOutOfNetwork()
+	return info
+}
+
+// 结束当前这一轮爬取
+// 记录这一轮的统计数据，删除被中断的会话留下的doing标记，之后不会再继续查询它们
+func (l *Logger) EndRound(start time.Time, completed bool) *RoundRecord {
+	rec := &RoundRecord{
+		Date:      today(),
+		Start:     start.Unix(),
+		End:       time.Now().Unix(),
+		Completed: completed,
+		Info:      l.TodayInfo(),
+	}
+	v, err := json.Marshal(rec)
+	if err != nil {
+		panic(err)
+	}
+	l.dbLock.Lock()
+	defer l.dbLock.Unlock()
+	batch := leveldb.MakeBatch(100)
+	batch.Put([]byte(roundPrefix+today()), v)
+	for _, p := range Protocols {
+		l.scanKeys(keysOf(p).doing, func(key string, v []byte) {
+			batch.Delete([]byte(keysOf(p).doing + key))
+		})
+	}
+	if err := l.db.Write(batch, nil); err != nil {
+		panic(err)
+	}
+	return rec
+}
+
+// 开始新的一轮爬取，使用今天的日期，并重新加载所有节点到等待队列
+// 今天的日期与上一轮相同时，只加载今天还没有查询完成的节点
+func (l *Logger) NewRound() {
+	l.dbLock.Lock()
+	d := time.Now().Format("2006-01-02")
+	if err := l.db.Put([]byte(todayKey), []byte(d), nil); err != nil {
+		panic(err)
+	}
+	setDate(d)
+	l.dbLock.Unlock()
+
+	l.waitingLock.Lock()
+	l.queues = make(map[Protocol]*Scheduler)
+	l.waitingLock.Unlock()
+	if l.newPriority == nil {
+		l.newPriority = NewFIFOPriority
+	}
+	l.loadWaiting()
+}
+
+// 所有轮次的记录，按日期排列
+func (l *Logger) Rounds() []RoundRecord {
+	l.dbLock.RLock()
+	defer l.dbLock.RUnlock()
+	var rs []RoundRecord
+	iter := l.db.NewIterator(util.BytesPrefix([]byte(roundPrefix)), nil)
+	for iter.Next() {
+		var rec RoundRecord
+		if err := json.Unmarshal(iter.Value(), &rec); err != nil {
+			continue
+		}
+		rs = append(rs, rec)
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		panic(err)
+	}
+	return rs
+}
@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRoundRollover(t *testing.T) {
+	l := newTestLogger(t)
+	from := testNode(t, "10.0.0.1")
+	to := testNode(t, "10.0.1.1")
+	l.WriteNode(from, DiscV4)
+	l.WriteNode(to, DiscV4)
+	for l.GetWaiting(DiscV4) != nil {
+	}
+	l.RelationDoing(DiscV4, from)
+	l.WriteRelation(DiscV4, from, to, 0)
+	l.RelationDone(DiscV4, from)
+	// to在这一轮结束时还没有查询完成
+	l.RelationDoing(DiscV4, to)
+
+	start := time.Now()
+	rec := l.EndRound(start, false)
+	if rec.Date != "2022-01-01" || rec.Completed || rec.Info.Relations != 1 || rec.Info.RelationDone != 1 {
+		t.Fatalf("wrong round record %+v", rec)
+	}
+	if l.IsRelationDoing(DiscV4, to) {
+		t.Fatal("doing mark should be removed at the end of a round")
+	}
+
+	l.NewRound()
+	if today() == "2022-01-01" {
+		t.Fatal("new round should use today's date")
+	}
+	// 新的一轮重新查询所有节点
+	waiting := 0
+	for l.GetWaiting(DiscV4) != nil {
+		waiting++
+	}
+	if waiting != 2 || l.TodayRelations(DiscV4) != 0 {
+		t.Fatalf("all nodes should wait in the new round, got %d", waiting)
+	}
+	if rounds := l.Rounds(); len(rounds) != 1 || rounds[0].Date != "2022-01-01" {
+		t.Fatalf("wrong rounds %v", rounds)
+	}
+}
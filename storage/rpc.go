@@ -9,18 +9,42 @@ import (
 	"os"
 )
 
+// 节点和关系默认按节点ID去重，同一个节点ID换了地址只算一次；Records结尾的是按enode链接统计的记录条数
 type DBInfo struct {
-	Nodes         int // 节点记录的条数
-	Relations     int // 关系条数
+	Nodes         int // 通过v4协议发现的不同节点ID的个数
+	Relations     int // 按两端节点ID去重的关系条数
 	RelationDoing int
 	RelationDone  int
 	Rlpxs         int // rlpx记录条数
 	Enrs          int // enr记录条数
+
+	// discv5协议的统计，上面的节点和关系统计只包括v4协议
+	V5Nodes         int // 通过v5协议发现的节点ID个数
+	V5Relations     int
+	V5RelationDoing int
+	V5RelationDone  int
+
+	OutOfNetwork int // 不属于爬取的链的节点个数
+
+	NodeRecords       int // 通过v4协议发现的节点记录的条数
+	RelationRecords   int // 关系记录的条数
+	V5NodeRecords     int
+	V5RelationRecords int
+}
+
+// 使用按enode链接统计的记录条数作为节点和关系个数
+func (i DBInfo) Records() DBInfo {
+	i.Nodes, i.Relations = i.NodeRecords, i.RelationRecords
+	i.V5Nodes, i.V5Relations = i.V5NodeRecords, i.V5RelationRecords
+	return i
 }
 
 type ActiveNode struct {
-	Url    string
-	Number int
+	Url          string
+	Number       int
+	Completeness float64 // 查询会话覆盖远程路由表的比例，-1代表未知
+	Estimate     float64 // 估计的远程路由表大小，-1代表未知
+	StopReason   string  // 会话结束的原因
 }
 
 type Actives struct {
@@ -33,8 +57,14 @@ func (i DBInfo) String() string {
 	RelationDoing: %d
 	RelationDone: %d
 	Rlpxs: %d
-	ENRs: %d`
-	return fmt.Sprintf(str, i.Nodes, i.Relations, i.RelationDoing, i.RelationDone, i.Rlpxs, i.Enrs)
+	ENRs: %d
+	V5Nodes: %d
+	V5Relations: %d
+	V5RelationDoing: %d
+	V5RelationDone: %d
+	OutOfNetwork: %d`
+	return fmt.Sprintf(str, i.Nodes, i.Relations, i.RelationDoing, i.RelationDone, i.Rlpxs, i.Enrs,
+		i.V5Nodes, i.V5Relations, i.V5RelationDoing, i.V5RelationDone, i.OutOfNetwork)
 }
 
 type Query struct {
@@ -46,53 +76,172 @@ func (q *Query) NodesCount(args struct{}, rs *int) error {
 	return nil
 }
 
+func (q *Query) NodeIDs(args struct{}, rs *int) error {
+	*rs = q.l.NodeIDs()
+	return nil
+}
+
+// 按节点ID去重的关系需要遍历所有日期的关系
 func (q *Query) All(args struct{}, info *DBInfo) error {
-	info.Nodes = q.l.Nodes()
-	info.Relations = q.l.AllRelations()
+	info.Nodes = q.l.ProtocolNodeIDs(DiscV4)
+	info.Relations = q.l.AllIDRelations(DiscV4)
+	info.NodeRecords = q.l.ProtocolNodes(DiscV4)
+	info.RelationRecords = q.l.AllRelations(DiscV4)
 
 	// relation的done和doing都只查今天的
-	info.RelationDoing = q.l.TodayRelationDoings()
-	info.RelationDone = q.l.TodayRelationDones()
+	info.RelationDoing = q.l.TodayRelationDoings(DiscV4)
+	info.RelationDone = q.l.TodayRelationDones(DiscV4)
 
 	info.Rlpxs = q.l.AllRlpxs()
 	info.Enrs = q.l.AllEnrs()
+
+	info.V5Nodes = q.l.ProtocolNodeIDs(DiscV5)
+	info.V5Relations = q.l.AllIDRelations(DiscV5)
+	info.V5NodeRecords = q.l.ProtocolNodes(DiscV5)
+	info.V5RelationRecords = q.l.AllRelations(DiscV5)
+	info.V5RelationDoing = q.l.TodayRelationDoings(DiscV5)
+	info.V5RelationDone = q.l.TodayRelationDones(DiscV5)
+	info.OutOfNetwork = q.l.TodayOutOfNetwork()
 	return nil
 }
 
 func (q *Query) Today(args struct{}, info *DBInfo) error {
-	info.Nodes = q.l.Nodes()
-	info.Relations = q.l.TodayRelations()
-	info.RelationDoing = q.l.TodayRelationDoings()
-	info.RelationDone = q.l.TodayRelationDones()
-	info.Rlpxs = q.l.TodayRlpxs()
-	info.Enrs = q.l.TodayEnrs()
+	*info = q.l.TodayInfo()
 	return nil
 }
 
-func (q *Query) Active(args struct{}, number *int) error {
-	*number = q.l.TodayActives()
+// 参数指定统计哪个协议的活跃节点
+func (q *Query) Active(p Protocol, number *int) error {
+	*number = q.l.TodayActives(p)
 	return nil
 }
 
-func (q *Query) ActiveInfo(args struct{}, actives *Actives) error {
-	rs := q.l.TodayActivesInfo()
+func (q *Query) ActiveIDs(p Protocol, number *int) error {
+	*number = q.l.TodayActiveIDs(p)
+	return nil
+}
+
+func (q *Query) Entities(p Protocol, stats *EntityStats) error {
+	*stats = q.l.TodayEntityStats(p)
+	return nil
+}
+
+func (q *Query) ActiveInfo(p Protocol, actives *Actives) error {
+	rs := q.l.TodayActivesInfo(p)
 	*actives = *rs
 	return nil
 }
 
-func startServer(l *Logger) {
+func (q *Query) DNSTrees(args struct{}, trees *[]DNSTree) error {
+	*trees = q.l.TodayDNSTrees()
+	return nil
+}
+
+func (q *Query) Stacks(args struct{}, stacks *Stacks) error {
+	*stacks = q.l.StackCounts()
+	return nil
+}
+
+func (q *Query) Identities(args struct{}, stats *[]IdentityStats) error {
+	*stats = q.l.TodayIdentityStats()
+	return nil
+}
+
+func (q *Query) Pings(args struct{}, days *[]PingDay) error {
+	*days = q.l.PingDays()
+	return nil
+}
+
+func (q *Query) Queue(args struct{}, depths *[]QueueDepth) error {
+	*depths = q.l.QueueDepths()
+	return nil
+}
+
+func (q *Query) Rounds(args struct{}, rounds *[]RoundRecord) error {
+	*rounds = q.l.Rounds()
+	return nil
+}
+
+// 节点不存在时返回空的记录
+func (q *Query) Lifecycle(node string, c *Lifecycle) error {
+	if rs := q.l.Lifecycle(node); rs != nil {
+		*c = *rs
+	}
+	return nil
+}
+
+// 节点不存在时返回空的记录
+func (q *Query) Addresses(node string, a *Addresses) error {
+	if rs := q.l.Addresses(node); rs != nil {
+		*a = *rs
+	}
+	return nil
+}
+
+func (q *Query) NATReport(args struct{}, report *NATReport) error {
+	*report = q.l.NATReport()
+	return nil
+}
+
+func (q *Query) Sybil(opts SybilOptions, clusters *[]SybilCluster) error {
+	*clusters = q.l.Sybil(opts)
+	return nil
+}
+
+func (q *Query) SaveSybil(clusters []SybilCluster, reply *int) error {
+	q.l.SaveSybil(clusters)
+	*reply = len(clusters)
+	return nil
+}
+
+// 参数为每天保留的发送FINDNODE最多的节点个数
+func (q *Query) Inbound(top int, days *[]InboundDay) error {
+	*days = q.l.InboundDays(top)
+	return nil
+}
+
+// 参数为目标节点ID的前缀，空字符串返回所有的查找记录
+func (q *Query) Lookups(target string, lks *[]Lookup) error {
+	*lks = q.l.Lookups(target)
+	return nil
+}
+
+func (q *Query) Errors(args struct{}, days *[]ErrorDay) error {
+	*days = q.l.ErrorDays()
+	return nil
+}
+
+func (q *Query) Status(args struct{}, days *[]StatusDay) error {
+	*days = q.l.StatusDays()
+	return nil
+}
+
+func (q *Query) Sync(opts SyncOptions, days *[]SyncDay) error {
+	*days = q.l.SyncReport(opts)
+	return nil
+}
+
+func (q *Query) LifecycleStats(args struct{}, stats *LifecycleStats) error {
+	*stats = q.l.LifecycleStats()
+	return nil
+}
+
+// 启动rpc服务，每个Logger使用独立的rpc服务，关闭Logger时一起关闭
+func startServer(l *Logger) net.Listener {
 	os.Remove(config.RpcPath)
-	// 启动rpc服务
 	query := &Query{
 		l: l,
 	}
-	rpc.Register(query)
-	rpc.HandleHTTP()
+	server := rpc.NewServer()
+	server.Register(query)
+	mux := http.NewServeMux()
+	mux.Handle(rpc.DefaultRPCPath, server)
 	listener, err := net.Listen("unix", config.RpcPath)
 	if err != nil {
 		panic(err)
 	}
-	go http.Serve(listener, nil)
+	go http.Serve(listener, mux)
+	return listener
 }
 
 type Queryer struct {
@@ -0,0 +1,245 @@
+package storage
+
+import (
+	"container/heap"
+	"node_hunter/config"
+	"node_hunter/limit"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// 等待查询的节点的优先级类别，数值越小越先查询
+type Class int
+
+const (
+	ClassResume       Class = iota // 之前开始查询但是没有完成的节点
+	ClassResponsive                // 之前的查询返回过关系的节点
+	ClassUnresponsive              // 之前查询过但是没有返回关系的节点
+	ClassFresh                     // 从来没有查询过的节点
+	classCount
+)
+
+func (c Class) String() string {
+	switch c {
+	case ClassResume:
+		return "resume"
+	case ClassResponsive:
+		return "responsive"
+	case ClassUnresponsive:
+		return "unresponsive"
+	case ClassFresh:
+		return "fresh"
+	}
+	return "unknown"
+}
+
+type queued struct {
+	node  *enode.Node
+	class Class
+	score float64
+	rank  int    // 加入时同一子网已经加入的个数，用于在子网之间轮流查询
+	seq   uint64 // 加入的顺序
+}
+
+// 先比较类别，然后是子网内的次序，再按分数从高到低，最后按加入顺序
+type queuedHeap []*queued
+
+func (h queuedHeap) Len() int { return len(h) }
+func (h queuedHeap) Less(i, j int) bool {
+	a, b := h[i], h[j]
+	if a.class != b.class {
+		return a.class < b.class
+	}
+	if a.rank != b.rank {
+		return a.rank < b.rank
+	}
+	if a.score != b.score {
+		return a.score > b.score
+	}
+	return a.seq < b.seq
+}
+func (h queuedHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *queuedHeap) Push(x interface{}) { *h = append(*h, x.(*queued)) }
+func (h *queuedHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return x
+}
+
+// 一个协议等待查询的节点
+// 内存中最多保存limit个节点，超过的节点不加入，标记为溢出，之后从数据库补充
+type Scheduler struct {
+	lock     sync.Mutex
+	limit    int
+	heap     queuedHeap
+	urls     map[string]struct{} // 已经在队列中的节点
+	subnets  map[string]int      // 每个子网加入的节点个数
+	depths   [classCount]int     // 每个类别的节点个数
+	seq      uint64
+	overflow bool
+}
+
+func NewScheduler(limit int) *Scheduler {
+	return &Scheduler{
+		limit:   limit,
+		urls:    make(map[string]struct{}),
+		subnets: make(map[string]int),
+	}
+}
+
+// 加入一个节点，已经在队列中或者队列已满返回false
+func (s *Scheduler) Push(n *enode.Node, class Class, score float64) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	url := n.URLv4()
+	if _, ok := s.urls[url]; ok {
+		return false
+	}
+	// 之前中断的节点个数不超过查询线程数，总是加入
+	if class != ClassResume && s.full() {
+		s.overflow = true
+		return false
+	}
+	subnet := limit.Subnet(n.IP())
+	q := &queued{
+		node:  n,
+		class: class,
+		score: score,
+		rank:  s.subnets[subnet],
+		seq:   s.seq,
+	}
+	s.seq++
+	s.subnets[subnet]++
+	s.urls[url] = struct{}{}
+	s.depths[class]++
+	heap.Push(&s.heap, q)
+	return true
+}
+
+// 取出优先级最高的节点，队列为空返回nil
+func (s *Scheduler) Pop() *enode.Node {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.heap) == 0 {
+		return nil
+	}
+	q := heap.Pop(&s.heap).(*queued)
+	delete(s.urls, q.node.URLv4())
+	s.depths[q.class]--
+	// 队列清空后重新开始子网的计数
+	if len(s.heap) == 0 {
+		s.subnets = make(map[string]int)
+	}
+	return q.node
+}
+
+// 队列是否已满
+func (s *Scheduler) Full() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.full()
+}
+
+func (s *Scheduler) full() bool {
+	return s.limit > 0 && len(s.heap) >= s.limit
+}
+
+func (s *Scheduler) Len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.heap)
+}
+
+// 是否有节点因为队列已满没有加入，调用后清除标记
+func (s *Scheduler) TakeOverflow() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	o := s.overflow
+	s.overflow = false
+	return o
+}
+
+// 每个类别的节点个数
+func (s *Scheduler) Depths() map[Class]int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	rs := make(map[Class]int)
+	for c, d := range s.depths {
+		rs[Class(c)] = d
+	}
+	return rs
+}
+
+// 获取协议p的等待队列，不存在时创建
+func (l *Logger) queue(p Protocol) *Scheduler {
+	l.waitingLock.Lock()
+	defer l.waitingLock.Unlock()
+	q := l.queues[p]
+	if q == nil {
+		q = NewScheduler(config.QueueLimit)
+		l.queues[p] = q
+	}
+	return q
+}
+
+// 按照优先级把节点加入协议p的等待队列
+func (l *Logger) enqueue(p Protocol, n *enode.Node) bool {
+	pr := l.priority
+	if pr == nil {
+		pr = NewFIFOPriority(l)
+	}
+	class, score := pr.Priority(p, n)
+	return l.queue(p).Push(n, class, score)
+}
+
+// 遍历数据库，把没有查询的节点加入等待队列直到队列再次装满
+func (l *Logger) refill(p Protocol) {
+	q := l.queue(p)
+	iter := l.db.NewIterator(util.BytesPrefix([]byte(nodesPrefix)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		if !hasProtocol(iter.Value(), p) {
+			continue
+		}
+		node := enode.MustParseV4(string(iter.Key()[len(nodesPrefix):]))
+		// 正在查询的节点也有doing标记，跳过，今天已经标记为链外的节点也不再查询
+		if l.isRelationDone(p, node) || l.isRelationDoing(p, node) || l.isOutOfNetwork(node) || config.Reject(node) {
+			continue
+		}
+		if !l.enqueue(p, node) && q.Full() {
+			break
+		}
+	}
+	if err := iter.Error(); err != nil {
+		panic(err)
+	}
+}
+
+// 每个协议每个类别的等待节点个数
+type QueueDepth struct {
+	Protocol string
+	Class    string
+	Depth    int
+}
+
+func (l *Logger) QueueDepths() []QueueDepth {
+	var rs []QueueDepth
+	for _, p := range Protocols {
+		l.waitingLock.Lock()
+		q := l.queues[p]
+		l.waitingLock.Unlock()
+		if q == nil {
+			continue
+		}
+		depths := q.Depths()
+		for c := Class(0); c < classCount; c++ {
+			rs = append(rs, QueueDepth{p.String(), c.String(), depths[c]})
+		}
+	}
+	return rs
+}
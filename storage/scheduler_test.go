@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"fmt"
+	"node_hunter/config"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+func testNode(t *testing.T, ip string) *enode.Node {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return enode.MustParseV4(fmt.Sprintf("enode://%x@%s:30303", crypto.FromECDSAPub(&key.PublicKey)[1:], ip))
+}
+
+func TestSchedulerOrder(t *testing.T) {
+	s := NewScheduler(0)
+	fresh := testNode(t, "10.0.0.1")
+	low := testNode(t, "10.0.1.1")
+	high := testNode(t, "10.0.2.1")
+	resume := testNode(t, "10.0.3.1")
+	s.Push(fresh, ClassFresh, 0)
+	s.Push(low, ClassResponsive, 1)
+	s.Push(high, ClassResponsive, 10)
+	s.Push(resume, ClassResume, 0)
+	if s.Push(high, ClassResponsive, 10) {
+		t.Fatal("queued node should not be pushed twice")
+	}
+	if d := s.Depths(); d[ClassResponsive] != 2 || d[ClassFresh] != 1 {
+		t.Fatalf("wrong depths %v", d)
+	}
+	for _, want := range []*enode.Node{resume, high, low, fresh} {
+		if got := s.Pop(); got != want {
+			t.Fatalf("wrong order: want %s, got %v", want.IP(), got)
+		}
+	}
+	if s.Pop() != nil {
+		t.Fatal("queue should be empty")
+	}
+}
+
+// 同一个子网的节点与其他子网的节点轮流出队
+func TestSchedulerSubnetFairness(t *testing.T) {
+	s := NewScheduler(0)
+	for i := 0; i < 3; i++ {
+		s.Push(testNode(t, fmt.Sprintf("10.0.0.%d", i+1)), ClassFresh, 0)
+	}
+	other := testNode(t, "10.0.9.1")
+	s.Push(other, ClassFresh, 0)
+	s.Pop()
+	if s.Pop() != other {
+		t.Fatal("other subnet should be served second")
+	}
+}
+
+// 队列满了以后溢出的节点从数据库补充
+func TestSchedulerRefill(t *testing.T) {
+	l := newTestLogger(t)
+	config.QueueLimit = 2
+	defer func() { config.QueueLimit = 100000 }()
+
+	for i := 0; i < 5; i++ {
+		l.WriteNode(testNode(t, fmt.Sprintf("10.0.%d.1", i)), DiscV4)
+	}
+	if l.queue(DiscV4).Len() != 2 {
+		t.Fatal("queue should be bounded")
+	}
+	seen := make(map[string]bool)
+	for n := l.GetWaiting(DiscV4); n != nil; n = l.GetWaiting(DiscV4) {
+		if seen[n.URLv4()] {
+			t.Fatal("node returned twice", n.URLv4())
+		}
+		seen[n.URLv4()] = true
+		l.RelationDoing(DiscV4, n)
+		l.RelationDone(DiscV4, n)
+	}
+	if len(seen) != 5 {
+		t.Fatalf("all nodes should be crawled, got %d", len(seen))
+	}
+}
+
+func TestHistoryPriority(t *testing.T) {
+	l := newTestLogger(t)
+	responsive := testNode(t, "10.0.0.1")
+	unresponsive := testNode(t, "10.0.1.1")
+	fresh := testNode(t, "10.0.2.1")
+	to := testNode(t, "10.0.3.1")
+
+	// 前一天查询过两个节点，只有一个返回了关系
+	setDate("2021-12-31")
+	l.WriteRelation(DiscV4, responsive, to, 0)
+	l.WriteSession(DiscV4, responsive, &SessionRecord{Relations: 1})
+	l.WriteSession(DiscV4, unresponsive, &SessionRecord{})
+	setDate("2022-01-01")
+
+	l.RelationDoing(DiscV4, fresh)
+	pr := NewHistoryPriority(l)
+	tests := []struct {
+		n     *enode.Node
+		class Class
+	}{
+		{responsive, ClassResponsive},
+		{unresponsive, ClassUnresponsive},
+		{to, ClassFresh},
+		{fresh, ClassResume},
+	}
+	for _, tt := range tests {
+		if c, _ := pr.Priority(DiscV4, tt.n); c != tt.class {
+			t.Errorf("%s: want %s, got %s", tt.n.IP(), tt.class, c)
+		}
+	}
+	if c, _ := pr.Priority(DiscV5, responsive); c != ClassFresh {
+		t.Errorf("v5 history should be separate, got %s", c)
+	}
+}
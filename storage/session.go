@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"encoding/json"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// session表记录每个节点每天的关系查询会话的结果
+// 键格式：s<协议标记><日期><from节点记录>
+// 值：json格式的SessionRecord
+var sessionPrefix = "s"
+
+func todaySessionPrefix(p Protocol) string {
+	return sessionPrefix + p.tag() + today()
+}
+
+// 一次关系查询会话的结果
+type SessionRecord struct {
+	Time      int64  // 会话结束的时间戳
+	Strategy  string // 查询策略
+	Identity  int    // 执行查询的本地身份序号，所有身份共同查询时为第一个身份
+	Compared  bool   // 所有本地身份用同样多的请求共同查询了这个节点
+	Queries   int    // 发送的FINDNODE请求个数
+	Relations int    // 会话结束时今天的关系个数
+	// 覆盖了远程节点路由表的比例，0到1之间
+	// 按距离遍历时为成功查询的桶中的节点占路由表大小的比例，没有查询成功的桶按照装满估计，是覆盖率的下限
+	// 随机查询时为见过的节点占估计的路由表大小的比例
+	// 无法判断时为-1
+	Completeness float64
+	Buckets      int // 按距离遍历时成功查询的桶的个数
+	// 随机查询时用标志重捕法估计的远程路由表大小，无法估计时为-1
+	Estimate   float64
+	StopReason string // 会话结束的原因
+	// 所有FINDNODE请求都失败时最后一次的错误，有成功的请求时为nil
+	Failure *Failure
+}
+
+func (l *Logger) WriteSession(p Protocol, from *enode.Node, rec *SessionRecord) {
+	l.dbLock.Lock()
+	defer l.dbLock.Unlock()
+	v, err := json.Marshal(rec)
+	if err != nil {
+		panic(err)
+	}
+	if err := l.db.Put([]byte(todaySessionPrefix(p)+parseFrom(from)), v, nil); err != nil {
+		panic(err)
+	}
+}
+
+// 读取今天的会话记录，不存在返回nil
+func (l *Logger) ReadSession(p Protocol, from *enode.Node) *SessionRecord {
+	l.dbLock.RLock()
+	defer l.dbLock.RUnlock()
+	return l.readSession(todaySessionPrefix(p) + parseFrom(from))
+}
+
+func (l *Logger) readSession(key string) *SessionRecord {
+	v, err := l.db.Get([]byte(key), nil)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return nil
+		}
+		panic(err)
+	}
+	rec := new(SessionRecord)
+	if err := json.Unmarshal(v, rec); err != nil {
+		return nil
+	}
+	return rec
+}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"node_hunter/config"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// 各种IP协议栈的节点个数，按照节点ID统计
+type Stacks struct {
+	IPv4Only int
+	IPv6Only int
+	Dual     int
+}
+
+const (
+	stackV4 = 1 << iota
+	stackV6
+)
+
+// 统计只有IPv4、只有IPv6以及双栈的节点个数
+// 节点记录中的地址来自邻居节点，只有一种地址，双栈信息来自今天查询到的enr记录
+func (l *Logger) StackCounts() Stacks {
+	l.dbLock.RLock()
+	defer l.dbLock.RUnlock()
+	stacks := make(map[enode.ID]byte)
+	mark := func(n *enode.Node) {
+		v4, v6 := config.Endpoints(n)
+		if v4 != nil {
+			stacks[n.ID()] |= stackV4
+		}
+		if v6 != nil {
+			stacks[n.ID()] |= stackV6
+		}
+	}
+
+	iter := l.db.NewIterator(util.BytesPrefix([]byte(nodesPrefix)), nil)
+	for iter.Next() {
+		n, err := enode.ParseV4(string(iter.Key()[len(nodesPrefix):]))
+		if err != nil {
+			continue
+		}
+		mark(n)
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		panic(err)
+	}
+
+	// enr表的值是<时间戳><e或i><错误信息 或 enr链接>
+	iter = l.db.NewIterator(util.BytesPrefix([]byte(todayEnrPrefix())), nil)
+	for iter.Next() {
+		v := iter.Value()
+		if len(v) <= 9 || v[8] != 'i' {
+			continue
+		}
+		n, err := enode.Parse(enode.ValidSchemes, string(v[9:]))
+		if err != nil {
+			continue
+		}
+		mark(n)
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		panic(err)
+	}
+
+	var rs Stacks
+	for _, s := range stacks {
+		switch s {
+		case stackV4:
+			rs.IPv4Only++
+		case stackV6:
+			rs.IPv6Only++
+		case stackV4 | stackV6:
+			rs.Dual++
+		}
+	}
+	return rs
+}
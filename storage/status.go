@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"node_hunter/config"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/forkid"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// status表记录RLPx握手之后eth协议Status消息中的链信息
+// 键格式：h<日期><enode链接>
+// 值：<时间戳>i<json格式的EthStatus> 或者 <时间戳>e<错误>
+var statusPrefix = "h"
+
+func todayStatusPrefix() string {
+	return statusPrefix + today()
+}
+
+// 远程节点发送的Status消息
+// eth/69的Status中没有总难度，改为节点保存的区块范围，Head为其中的最新区块哈希
+type EthStatus struct {
+	Version       uint32 // eth协议版本
+	NetworkID     uint64
+	TD            string // 总难度，十进制，eth/69为空
+	Head          string // 最新区块哈希
+	Genesis       string // 创世区块哈希
+	ForkHash      string // 分叉ID的哈希，4字节的十六进制
+	ForkNext      uint64 // 下一个分叉的区块高度或时间戳，0代表没有
+	EarliestBlock uint64 // eth/69中节点保存的最早区块高度
+	LatestBlock   uint64 // eth/69中节点的最新区块高度
+
+	// 按照最新区块哈希请求到的区块头中的高度和时间戳
+	// 请求失败时HeadFailure不为nil，同步状态未知
+	HeadNumber  uint64
+	HeadTime    uint64
+	HeadFailure *Failure
+}
+
+// Status中的分叉ID
+func (s *EthStatus) ForkID() forkid.ID {
+	id := forkid.ID{Next: s.ForkNext}
+	if b, err := hex.DecodeString(s.ForkHash); err == nil && len(b) == 4 {
+		copy(id.Hash[:], b)
+	}
+	return id
+}
+
+// 节点所在的链，按照分叉ID判断，认识的链使用链的名字
+// 与主网创世区块相同的以太坊经典等链不会被当成主网
+func (s *EthStatus) Chain() string {
+	if name := config.ForkNetwork(s.ForkID()); name != "" {
+		return name
+	}
+	return fmt.Sprintf("network %d genesis %s fork %s", s.NetworkID, s.Genesis, s.ForkHash)
+}
+
+// 记录今天交换Status的结果，今天已经记录过返回false
+func (l *Logger) WriteStatus(n *enode.Node, st *EthStatus, err error) bool {
+	l.dbLock.Lock()
+	defer l.dbLock.Unlock()
+	key := []byte(todayStatusPrefix() + n.URLv4())
+	has, e := l.db.Has(key, nil)
+	if e != nil {
+		panic(e)
+	}
+	if has {
+		return false
+	}
+	v := int64ToBytes(time.Now().Unix())
+	if err != nil {
+		v = append(v, encodeFailure(err)...)
+	} else {
+		b, e := json.Marshal(st)
+		if e != nil {
+			panic(e)
+		}
+		v = append(v, 'i')
+		v = append(v, b...)
+	}
+	if e := l.db.Put(key, v, nil); e != nil {
+		panic(e)
+	}
+	return true
+}
+
+// 读取今天的Status记录，没有记录时两个返回值都是nil
+func (l *Logger) TodayStatus(n *enode.Node) (*EthStatus, *Failure) {
+	l.dbLock.RLock()
+	defer l.dbLock.RUnlock()
+	v, err := l.db.Get([]byte(todayStatusPrefix()+n.URLv4()), nil)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return nil, nil
+		}
+		panic(err)
+	}
+	return decodeStatus(v)
+}
+
+func decodeStatus(v []byte) (*EthStatus, *Failure) {
+	if len(v) <= 8 {
+		return nil, nil
+	}
+	v = v[8:]
+	if f := decodeFailure(v); f != nil {
+		return nil, f
+	}
+	st := new(EthStatus)
+	if v[0] != 'i' || json.Unmarshal(v[1:], st) != nil {
+		return nil, nil
+	}
+	return st, nil
+}
+
+// 一天的Status统计
+type StatusDay struct {
+	Date     string
+	Total    int            // 尝试交换Status的节点个数
+	Failed   int            // 失败的个数
+	Failures map[string]int // 每种失败原因的个数
+	Chains   map[string]int // 每条链的节点个数
+}
+
+// 按日期统计所有的Status记录
+func (l *Logger) StatusDays() []StatusDay {
+	l.dbLock.RLock()
+	defer l.dbLock.RUnlock()
+	days := make(map[string]*StatusDay)
+	l.scanKeys(statusPrefix, func(key string, v []byte) {
+		if len(key) < 10 {
+			return
+		}
+		d := key[:10]
+		day, ok := days[d]
+		if !ok {
+			day = &StatusDay{Date: d, Failures: make(map[string]int), Chains: make(map[string]int)}
+			days[d] = day
+		}
+		st, f := decodeStatus(v)
+		switch {
+		case f != nil:
+			day.Total++
+			day.Failed++
+			day.Failures[f.Kind()]++
+		case st != nil:
+			day.Total++
+			day.Chains[st.Chain()]++
+		}
+	})
+	ret := make([]StatusDay, 0, len(days))
+	for _, day := range days {
+		ret = append(ret, *day)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Date < ret[j].Date })
+	return ret
+}
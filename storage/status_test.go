@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+func TestStatus(t *testing.T) {
+	l := newTestLogger(t)
+	a := testNode(t, "10.0.0.1")
+	b := testNode(t, "10.0.0.2")
+	c := testNode(t, "10.0.0.3")
+	d := testNode(t, "10.0.0.4")
+	mainnet := &EthStatus{Version: 69, NetworkID: 1, Genesis: params.MainnetGenesisHash.Hex(), ForkHash: "07c9462e", LatestBlock: 100}
+	if !l.WriteStatus(a, mainnet, nil) || l.WriteStatus(a, nil, errors.New("RPC timeout")) {
+		t.Fatal("status should be written once a day")
+	}
+	l.WriteStatus(b, &EthStatus{Version: 66, NetworkID: 56, TD: "1", Genesis: "0x0d21840abff46b96c84b2ac9e10e4f5cdaeb5693cb665db62a2f3b02d2d57b5b"}, nil)
+	l.WriteStatus(c, nil, p2p.DiscUselessPeer)
+	// 以太坊经典的创世区块与主网相同
+	classic := &EthStatus{Version: 68, NetworkID: 1, TD: "1", Genesis: params.MainnetGenesisHash.Hex(), ForkHash: "be46d57c"}
+	l.WriteStatus(d, classic, nil)
+
+	if st, f := l.TodayStatus(a); f != nil || st == nil || *st != *mainnet || st.Chain() != "mainnet" {
+		t.Fatalf("wrong status %+v %v", st, f)
+	}
+	if classic.Chain() == "mainnet" {
+		t.Fatal("classic node should not be counted as mainnet")
+	}
+	if st, f := l.TodayStatus(c); st != nil || f == nil || f.Kind() != "disconnect(useless peer)" {
+		t.Fatalf("wrong failure %+v %v", st, f)
+	}
+	days := l.StatusDays()
+	if len(days) != 1 || days[0].Total != 4 || days[0].Failed != 1 || days[0].Chains["mainnet"] != 1 || len(days[0].Chains) != 3 {
+		t.Fatalf("wrong status days %+v", days)
+	}
+}
@@ -0,0 +1,249 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"net"
+	"node_hunter/config"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// 最近一次保存的女巫攻击分析结果
+// 键格式：msybil
+// 值：json格式的SybilCluster数组
+var sybilKey = metaPrefix + "sybil"
+
+// 女巫攻击分析的参数
+type SybilOptions struct {
+	MinIPIDs  int // 一个IP至少有多少个节点ID才标记
+	MinXORIDs int // 一个ID前缀至少有多少个节点ID才标记
+	ExtraBits int // ID前缀的位数比log2(节点ID个数)多出的位数，越大要求ID越接近
+}
+
+// 一个可疑的集群
+type SybilCluster struct {
+	Kind      string  // ip或者xor
+	Key       string  // IP地址，或者节点ID前缀的十六进制
+	Bits      int     // xor集群的前缀位数
+	IDs       int     // 集群中节点ID的个数
+	Expected  float64 // 随机分布时期望的节点ID个数
+	Score     float64 // 见sybilScore，ip和xor集群使用相同的尺度，越高越可疑
+	Relations int     // 今天v4协议指向集群中节点的关系条数
+}
+
+// 从enode链接中解析出节点ID和IP
+func urlIdentity(url string) (enode.ID, string, bool) {
+	var id enode.ID
+	key, ok := urlKey(url)
+	if !ok {
+		return id, "", false
+	}
+	pub, err := hex.DecodeString(key)
+	if err != nil {
+		return id, "", false
+	}
+	// v4协议的节点ID是公钥的哈希
+	copy(id[:], crypto.Keccak256(pub))
+	host := url[len("enode://")+129:]
+	if i := strings.IndexByte(host, '?'); i >= 0 {
+		host = host[:i]
+	}
+	ip, _, err := net.SplitHostPort(host)
+	if err != nil {
+		return id, "", false
+	}
+	return id, ip, true
+}
+
+// 分析节点表中一个IP上有大量节点ID，以及节点ID在XOR空间中异常接近的集群
+// 节点ID是公钥的哈希，正常情况下均匀分布，可以计算每个集群的期望大小
+func (l *Logger) Sybil(opts SybilOptions) []SybilCluster {
+	l.dbLock.RLock()
+	defer l.dbLock.RUnlock()
+	ids := make(map[enode.ID]struct{})
+	ipIDs := make(map[string]map[enode.ID]struct{})
+	l.scanKeys(nodesPrefix, func(key string, v []byte) {
+		id, ip, ok := urlIdentity(key)
+		if !ok {
+			return
+		}
+		ids[id] = struct{}{}
+		if ipIDs[ip] == nil {
+			ipIDs[ip] = make(map[enode.ID]struct{})
+		}
+		ipIDs[ip][id] = struct{}{}
+	})
+	if len(ids) == 0 {
+		return nil
+	}
+	var rs []SybilCluster
+	// 每个集群包含的节点ID
+	members := make(map[enode.ID][]int)
+
+	perIP := float64(len(ids)) / float64(len(ipIDs))
+	for ip, set := range ipIDs {
+		if len(set) < opts.MinIPIDs {
+			continue
+		}
+		for id := range set {
+			members[id] = append(members[id], len(rs))
+		}
+		rs = append(rs, SybilCluster{Kind: "ip", Key: ip, IDs: len(set), Expected: perIP, Score: sybilScore(len(set), perIP, float64(len(ipIDs)))})
+	}
+
+	bits := int(math.Ceil(math.Log2(float64(len(ids))))) + opts.ExtraBits
+	if bits > 64 {
+		bits = 64
+	}
+	if bits < 1 {
+		bits = 1
+	}
+	prefix := func(id enode.ID) uint64 {
+		return binary.BigEndian.Uint64(id[:8]) >> (64 - bits)
+	}
+	prefixIDs := make(map[uint64][]enode.ID)
+	for id := range ids {
+		p := prefix(id)
+		prefixIDs[p] = append(prefixIDs[p], id)
+	}
+	groups := math.Pow(2, float64(bits))
+	expected := float64(len(ids)) / groups
+	for p, set := range prefixIDs {
+		if len(set) < opts.MinXORIDs {
+			continue
+		}
+		for _, id := range set {
+			members[id] = append(members[id], len(rs))
+		}
+		var key [8]byte
+		binary.BigEndian.PutUint64(key[:], p<<(64-bits))
+		rs = append(rs, SybilCluster{
+			Kind:     "xor",
+			Key:      hex.EncodeToString(key[:(bits+7)/8]),
+			Bits:     bits,
+			IDs:      len(set),
+			Expected: expected,
+			Score:    sybilScore(len(set), expected, groups),
+		})
+	}
+
+	// 统计其他节点返回这些集群的次数，反映集群对统计结果的影响
+	if len(rs) > 0 {
+		l.scanKeys(keysOf(DiscV4).data, func(key string, v []byte) {
+			if !strings.HasPrefix(key, "enode://") {
+				return
+			}
+			i := strings.Index(key[8:], "enode://")
+			if i < 0 {
+				return
+			}
+			id, _, ok := urlIdentity(key[8+i:])
+			if !ok {
+				return
+			}
+			for _, c := range members[id] {
+				rs[c].Relations++
+			}
+		})
+	}
+	sort.Slice(rs, func(i, j int) bool { return rs[i].Score > rs[j].Score })
+	return rs
+}
+
+// 集群的分数，ip集群和xor集群的期望大小和分组个数相差很大，大小的比值不能放在一起比较
+// 随机分布时每组的节点ID个数服从均值为expected的泊松分布，计算至少有ids个节点ID的尾概率，
+// 乘以分组的个数得到所有分组中随机出现一个这样的集群的概率，分数为这个概率的-log10，不小于0
+func sybilScore(ids int, expected float64, groups float64) float64 {
+	score := -(poissonLogTail(ids, expected) + math.Log(groups)) / math.Ln10
+	if score < 0 {
+		return 0
+	}
+	return score
+}
+
+// 均值为lambda的泊松分布不小于k的概率的自然对数
+// 集群的尾概率可能远小于float64的范围，k大于lambda时在对数空间计算
+func poissonLogTail(k int, lambda float64) float64 {
+	if k <= 0 {
+		return 0
+	}
+	if lambda <= 0 {
+		return math.Inf(-1)
+	}
+	if float64(k) <= lambda {
+		// 尾概率不会太小，直接用1减去累积概率
+		p := math.Exp(-lambda)
+		cdf := 0.0
+		for i := 0; i < k; i++ {
+			cdf += p
+			p *= lambda / float64(i+1)
+		}
+		return math.Log(math.Max(1-cdf, math.SmallestNonzeroFloat64))
+	}
+	lg, _ := math.Lgamma(float64(k + 1))
+	first := float64(k)*math.Log(lambda) - lambda - lg
+	// 后面的项相对第一项的比例之和，k大于lambda时比例递减
+	sum, term := 1.0, 1.0
+	for i := k + 1; term > sum*1e-16; i++ {
+		term *= lambda / float64(i)
+		sum += term
+	}
+	return first + math.Log(sum)
+}
+
+// 保存分析结果，开启了config.RejectSybil时立即生效
+func (l *Logger) SaveSybil(clusters []SybilCluster) {
+	v, err := json.Marshal(clusters)
+	if err != nil {
+		panic(err)
+	}
+	l.dbLock.Lock()
+	defer l.dbLock.Unlock()
+	if err := l.db.Put([]byte(sybilKey), v, nil); err != nil {
+		panic(err)
+	}
+	if config.RejectSybil {
+		setSybil(clusters)
+	}
+}
+
+// 加载保存的分析结果到config中
+func (l *Logger) loadSybil() {
+	v, err := l.db.Get([]byte(sybilKey), nil)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return
+		}
+		panic(err)
+	}
+	var clusters []SybilCluster
+	if err := json.Unmarshal(v, &clusters); err != nil {
+		return
+	}
+	setSybil(clusters)
+}
+
+func setSybil(clusters []SybilCluster) {
+	var ips []net.IP
+	var prefixes []config.IDPrefix
+	for _, c := range clusters {
+		switch c.Kind {
+		case "ip":
+			if ip := net.ParseIP(c.Key); ip != nil {
+				ips = append(ips, ip)
+			}
+		case "xor":
+			if b, err := hex.DecodeString(c.Key); err == nil && len(b)*8 >= c.Bits {
+				prefixes = append(prefixes, config.IDPrefix{Prefix: b, Bits: c.Bits})
+			}
+		}
+	}
+	config.SetSybil(ips, prefixes)
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"fmt"
+	"math"
+	"node_hunter/config"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+func TestSybil(t *testing.T) {
+	l := newTestLogger(t)
+	// 一个IP上的12个节点ID
+	var farm []*enode.Node
+	for i := 0; i < 12; i++ {
+		farm = append(farm, testNode(t, "10.0.0.1"))
+	}
+	// 生成3个前5位相同的节点ID，其他节点作为对照
+	var near, others []*enode.Node
+	for len(near) < 3 || len(others) < 5 {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := enode.MustParseV4(fmt.Sprintf("enode://%x@10.1.%d.1:30303", crypto.FromECDSAPub(&key.PublicKey)[1:], len(near)+len(others)))
+		if n.ID()[0]>>3 == 0x1f {
+			if len(near) < 3 {
+				near = append(near, n)
+			}
+		} else if len(others) < 5 {
+			others = append(others, n)
+		}
+	}
+	for _, group := range [][]*enode.Node{farm, near, others} {
+		for _, n := range group {
+			l.WriteNode(n, DiscV4)
+		}
+	}
+	l.WriteRelation(DiscV4, others[0], farm[0], 0)
+	l.WriteRelation(DiscV4, others[0], farm[1], 0)
+
+	// 20个节点ID，前缀长度为5位
+	clusters := l.Sybil(SybilOptions{MinIPIDs: 10, MinXORIDs: 3, ExtraBits: 0})
+	var saved []SybilCluster
+	for _, c := range clusters {
+		if c.Kind == "ip" && c.Key == "10.0.0.1" {
+			if c.IDs != 12 || c.Relations != 2 || c.Score <= 1 {
+				t.Fatalf("wrong ip cluster %+v", c)
+			}
+			saved = append(saved, c)
+		}
+		if c.Kind == "xor" && c.Key == "f8" && c.Bits == 5 {
+			saved = append(saved, c)
+		}
+	}
+	if len(saved) != 2 {
+		t.Fatalf("clusters not flagged %+v", clusters)
+	}
+
+	config.RejectSybil = true
+	defer func() {
+		config.RejectSybil = false
+		config.SetSybil(nil, nil)
+	}()
+	l.SaveSybil(saved)
+	if !config.Reject(farm[5]) || !config.Reject(near[2]) {
+		t.Fatal("flagged nodes should be rejected")
+	}
+	for _, n := range others {
+		if config.Reject(n) {
+			t.Fatal("other nodes should not be rejected")
+		}
+	}
+	// 重新加载保存的结果
+	config.SetSybil(nil, nil)
+	l.loadSybil()
+	if !config.IsSybil(near[0]) {
+		t.Fatal("saved clusters should be loaded")
+	}
+}
+
+func TestSybilScore(t *testing.T) {
+	if got, want := poissonLogTail(2, 1), math.Log(1-2/math.E); math.Abs(got-want) > 1e-12 {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	// 1/1000的概率出现至少3个，对数空间计算和直接求和一致
+	lambda := 0.1
+	direct := 1 - math.Exp(-lambda)*(1+lambda+lambda*lambda/2)
+	if got := poissonLogTail(3, lambda); math.Abs(got-math.Log(direct)) > 1e-9 {
+		t.Fatalf("got %v, want %v", got, math.Log(direct))
+	}
+	if s := sybilScore(1000, 1, 1000); math.IsInf(s, 0) || s < 1000 {
+		t.Fatalf("huge cluster should have a finite high score, got %v", s)
+	}
+	// 一个IP上200个节点ID比一个前缀上3个节点ID可疑得多，虽然后者与期望大小的比值更大
+	ip := sybilScore(200, 2, 50000)
+	xor := sybilScore(3, 0.004, 1<<22)
+	if ip <= xor || xor > 2 {
+		t.Fatalf("wrong order: ip=%v xor=%v", ip, xor)
+	}
+}
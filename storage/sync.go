@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// 根据status表中最新区块的高度和时间判断节点的同步状态
+// 链按照Status中的分叉ID判断，同一条链上分叉升级前后的节点在同一组，创世区块相同的以太坊经典等链不在同一组
+// 同一天同一条链上，所有节点的最新区块中区块时间不晚于探测时间的最高区块作为这条链当时的最新区块
+// 落后不超过SyncedBlocks个区块为已同步；否则最新区块与之前一天的探测结果相同，
+// 或者最新区块的时间早于探测时间超过StuckAge为停滞；其余为落后
+
+// 判断同步状态的参数
+type SyncOptions struct {
+	SyncedBlocks uint64        // 落后当时的最新区块不超过这么多个区块为已同步
+	StuckAge     time.Duration // 最新区块的时间早于探测时间超过这么久为停滞，0代表不按照时间判断
+}
+
+// 一个客户端的各种同步状态的节点个数
+type SyncCounts struct {
+	Synced  int
+	Lagging int
+	Stuck   int
+	Unknown int // 没有请求到最新区块的区块头
+}
+
+// 一天中一条链上的同步状态
+type SyncDay struct {
+	Date    string
+	Chain   string
+	Best    uint64                 // 这一天见过的最高区块
+	Clients map[string]*SyncCounts // 键为客户端类型，例如Geth
+}
+
+// rlpx记录中的客户端类型，没有记录时为unknown
+// 客户端信息的格式为<客户端类型>/<版本号>...
+func clientType(info []byte) string {
+	if len(info) <= 9 || info[8] != 'i' {
+		return "unknown"
+	}
+	name := string(info[9:])
+	if i := strings.IndexAny(name, "/ "); i >= 0 {
+		name = name[:i]
+	}
+	if name == "" {
+		return "unknown"
+	}
+	return name
+}
+
+// 按日期和链统计每种客户端的节点的同步状态
+func (l *Logger) SyncReport(opts SyncOptions) []SyncDay {
+	l.dbLock.RLock()
+	defer l.dbLock.RUnlock()
+	type probe struct {
+		time      int64
+		client    string
+		status    *EthStatus
+		unchanged bool // 最新区块与这个节点之前一天的探测结果相同
+	}
+	groups := make(map[[2]string][]probe)
+	// 按照日期的顺序遍历，记录每个节点最近一次探测到的区块高度
+	last := make(map[string]uint64)
+	l.scanKeys(statusPrefix, func(key string, v []byte) {
+		st, _ := decodeStatus(v)
+		if len(key) < 10 || st == nil {
+			return
+		}
+		d, url := key[:10], key[10:]
+		info, err := l.db.Get([]byte(rlpxPrefix+key), nil)
+		if err != nil && err != leveldb.ErrNotFound {
+			panic(err)
+		}
+		p := probe{time: bytesToInt64(v[:8]), client: clientType(info), status: st}
+		if st.HeadFailure == nil && st.HeadNumber != 0 {
+			if prev, ok := last[url]; ok && prev == st.HeadNumber {
+				p.unchanged = true
+			}
+			last[url] = st.HeadNumber
+		}
+		// 按照分叉ID判断的链分组，不按照创世区块
+		g := [2]string{d, st.Chain()}
+		groups[g] = append(groups[g], p)
+	})
+
+	ret := make([]SyncDay, 0, len(groups))
+	for g, probes := range groups {
+		day := SyncDay{Date: g[0], Chain: g[1], Clients: make(map[string]*SyncCounts)}
+		// 按照区块时间排序的所有最新区块，best[i]为前i+1个区块中最高的高度
+		var heads []*EthStatus
+		for _, p := range probes {
+			if st := p.status; st.HeadFailure == nil && st.HeadNumber != 0 {
+				heads = append(heads, st)
+			}
+		}
+		sort.Slice(heads, func(i, j int) bool { return heads[i].HeadTime < heads[j].HeadTime })
+		best := make([]uint64, len(heads))
+		for i, st := range heads {
+			best[i] = st.HeadNumber
+			if i > 0 && best[i-1] > best[i] {
+				best[i] = best[i-1]
+			}
+		}
+		if len(best) > 0 {
+			day.Best = best[len(best)-1]
+		}
+		for _, p := range probes {
+			c, ok := day.Clients[p.client]
+			if !ok {
+				c = new(SyncCounts)
+				day.Clients[p.client] = c
+			}
+			st := p.status
+			if st.HeadFailure != nil || st.HeadNumber == 0 {
+				c.Unknown++
+				continue
+			}
+			// 探测时这条链的最新区块：区块时间不晚于探测时间的最高区块
+			i := sort.Search(len(heads), func(i int) bool { return int64(heads[i].HeadTime) > p.time })
+			var lag uint64
+			if i > 0 && best[i-1] > st.HeadNumber {
+				lag = best[i-1] - st.HeadNumber
+			}
+			switch {
+			case lag <= opts.SyncedBlocks:
+				c.Synced++
+			case p.unchanged || (opts.StuckAge > 0 && p.time-int64(st.HeadTime) > int64(opts.StuckAge/time.Second)):
+				c.Stuck++
+			default:
+				c.Lagging++
+			}
+		}
+		ret = append(ret, day)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Date != ret[j].Date {
+			return ret[i].Date < ret[j].Date
+		}
+		return ret[i].Chain < ret[j].Chain
+	})
+	return ret
+}
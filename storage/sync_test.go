@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSyncReport(t *testing.T) {
+	l := newTestLogger(t)
+	a := testNode(t, "10.0.0.1")
+	b := testNode(t, "10.0.0.2")
+	c := testNode(t, "10.0.0.3")
+	d := testNode(t, "10.0.0.4")
+	now := uint64(time.Now().Unix())
+	status := func(number, age uint64) *EthStatus {
+		return &EthStatus{NetworkID: 1, TD: "1", Genesis: "0xd4e56740f876aef8c010b86a40d5f56745a118d0906a34e69aec8c0db1cb8fa3", ForkHash: "07c9462e", HeadNumber: number, HeadTime: now - age}
+	}
+	l.WriteRlpx(a, "Geth/v1.10.13-stable/linux-amd64/go1.17.5  eth/66", nil)
+	l.WriteRlpx(b, "Geth/v1.10.12-stable/linux-amd64/go1.17.2  eth/66", nil)
+	l.WriteRlpx(c, "Nethermind/v1.11.7/linux-x64/dotnet5.0.11  eth/66", nil)
+	l.WriteStatus(a, status(100, 0), nil)
+	l.WriteStatus(b, status(95, 60), nil)
+	l.WriteStatus(c, status(50, 48*3600), nil)
+	st := status(0, 0)
+	st.HeadFailure = ClassifyError(errors.New("RPC timeout"))
+	l.WriteStatus(d, st, nil)
+	// 还没有升级到BPO2的主网节点与其他主网节点一起统计
+	e := testNode(t, "10.0.0.5")
+	osaka := status(99, 0)
+	osaka.ForkHash = "5167e2a6"
+	l.WriteStatus(e, osaka, nil)
+	// 以太坊经典的创世区块与主网相同，区块更高也不影响主网的最新区块
+	f := testNode(t, "10.0.0.6")
+	classic := status(1000, 0)
+	classic.ForkHash = "be46d57c"
+	l.WriteStatus(f, classic, nil)
+	// 第二天b的最新区块没有变化
+	setDate("2022-01-02")
+	l.WriteStatus(a, status(200, 0), nil)
+	l.WriteStatus(b, status(95, 60), nil)
+
+	days := l.SyncReport(SyncOptions{SyncedBlocks: 2, StuckAge: 24 * time.Hour})
+	if len(days) != 3 || days[0].Chain != "mainnet" || days[0].Best != 100 || days[2].Best != 200 {
+		t.Fatalf("wrong sync days %+v", days)
+	}
+	if g := days[0].Clients["Geth"]; g == nil || g.Synced != 1 || g.Lagging != 1 {
+		t.Fatalf("wrong geth counts of the first day %+v", g)
+	}
+	if n := days[0].Clients["Nethermind"]; n == nil || n.Stuck != 1 {
+		t.Fatalf("wrong nethermind counts %+v", n)
+	}
+	if u := days[0].Clients["unknown"]; u == nil || u.Unknown != 1 || u.Synced != 1 {
+		t.Fatalf("wrong unknown counts %+v", u)
+	}
+	// 第二天没有rlpx记录，客户端类型未知
+	if c := days[1].Clients["unknown"]; days[1].Chain == "mainnet" || days[1].Best != 1000 || c == nil || c.Synced != 1 {
+		t.Fatalf("classic node should be counted separately %+v", days[1])
+	}
+	if u := days[2].Clients["unknown"]; u == nil || u.Synced != 1 || u.Stuck != 1 {
+		t.Fatalf("wrong counts of the second day %+v", u)
+	}
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// trace表记录定向查找经过的每一跳
+// 键格式：t<日期><目标节点ID的十六进制><开始时间戳>
+// 值：json格式的Lookup
+var tracePrefix = "t"
+
+// 查找中出现的一个节点以及它与目标的对数距离
+type TraceNode struct {
+	URL  string
+	Dist int
+}
+
+// 查找中的一跳，即向一个节点发送一次FINDNODE
+type Hop struct {
+	Round    int    // 第几轮查询，从1开始
+	Node     string // 被询问的节点
+	Dist     int    // 被询问的节点与目标的对数距离
+	Sent     int64  // 发送FINDNODE的时间，相对查找开始的毫秒数
+	RTT      int64  // 收到回复的耗时，毫秒
+	Error    string // 查询失败时的错误信息
+	Returned []TraceNode
+	New      int // 返回的节点中第一次在这次查找中出现的个数
+}
+
+// 一次定向查找的完整记录
+type Lookup struct {
+	Date     string
+	Target   string // 目标节点ID
+	Pubkey   string // FINDNODE中使用的目标公钥
+	Start    int64  // 开始时间，毫秒时间戳，同时作为查找的编号
+	Duration int64  // 毫秒
+	Seeds    []TraceNode
+	Hops     []Hop
+	Closest  []TraceNode // 结束时距离目标最近的节点
+	Found    bool        // 目标节点出现在某个节点的回复中
+}
+
+func (l *Logger) WriteLookup(lk *Lookup) {
+	l.dbLock.Lock()
+	defer l.dbLock.Unlock()
+	lk.Date = today()
+	v, err := json.Marshal(lk)
+	if err != nil {
+		panic(err)
+	}
+	key := tracePrefix + today() + lk.Target + string(int64ToBytes(lk.Start))
+	if err := l.db.Put([]byte(key), v, nil); err != nil {
+		panic(err)
+	}
+}
+
+// 读取所有的查找记录，target不为空时只返回目标节点ID以它开头的记录
+// 按开始时间排序
+func (l *Logger) Lookups(target string) []Lookup {
+	l.dbLock.RLock()
+	defer l.dbLock.RUnlock()
+	target = strings.ToLower(target)
+	var lks []Lookup
+	l.scanKeys(tracePrefix, func(key string, v []byte) {
+		if len(key) < 10+64 || !strings.HasPrefix(key[10:], target) {
+			return
+		}
+		var lk Lookup
+		if err := json.Unmarshal(v, &lk); err != nil {
+			return
+		}
+		lks = append(lks, lk)
+	})
+	sort.Slice(lks, func(i, j int) bool { return lks[i].Start < lks[j].Start })
+	return lks
+}
+
+// 从节点表中找到距离目标最近的k个节点，作为查找的起点
+// 节点ID由enode链接中的公钥计算，只解析最后选出的节点
+func (l *Logger) ClosestNodes(target enode.ID, k int) []*enode.Node {
+	l.dbLock.RLock()
+	defer l.dbLock.RUnlock()
+	type candidate struct {
+		id  enode.ID
+		url string
+	}
+	var closest []candidate
+	pub := make([]byte, 64)
+	l.scanKeys(nodesPrefix, func(url string, v []byte) {
+		key, ok := urlKey(url)
+		if !ok {
+			return
+		}
+		if _, err := hex.Decode(pub, []byte(key)); err != nil {
+			return
+		}
+		c := candidate{id: enode.ID(crypto.Keccak256Hash(pub)), url: url}
+		i := sort.Search(len(closest), func(i int) bool { return enode.DistCmp(target, c.id, closest[i].id) < 0 })
+		if i >= k {
+			return
+		}
+		closest = append(closest, candidate{})
+		copy(closest[i+1:], closest[i:])
+		closest[i] = c
+		if len(closest) > k {
+			closest = closest[:k]
+		}
+	})
+	nodes := make([]*enode.Node, 0, len(closest))
+	for _, c := range closest {
+		if n, err := enode.ParseV4(c.url); err == nil {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
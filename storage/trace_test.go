@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+func TestClosestNodes(t *testing.T) {
+	l := newTestLogger(t)
+	var nodes []*enode.Node
+	for i := 0; i < 20; i++ {
+		n := testNode(t, "10.0.0.1")
+		l.WriteNode(n, DiscV4)
+		nodes = append(nodes, n)
+	}
+	target := nodes[7].ID()
+	closest := l.ClosestNodes(target, 5)
+	if len(closest) != 5 || closest[0].ID() != target {
+		t.Fatalf("the target itself should be the closest, got %v", closest)
+	}
+	for i := 1; i < len(closest); i++ {
+		if enode.DistCmp(target, closest[i-1].ID(), closest[i].ID()) > 0 {
+			t.Fatalf("nodes are not sorted by distance")
+		}
+	}
+	// 没有返回的节点都不比返回的最后一个节点更近
+	returned := make(map[enode.ID]bool)
+	for _, n := range closest {
+		returned[n.ID()] = true
+	}
+	for _, n := range nodes {
+		if !returned[n.ID()] && enode.DistCmp(target, n.ID(), closest[4].ID()) < 0 {
+			t.Fatalf("missing closer node %v", n)
+		}
+	}
+}
+
+func TestLookups(t *testing.T) {
+	l := newTestLogger(t)
+	a := testNode(t, "10.0.0.1").ID().String()
+	b := testNode(t, "10.0.0.2").ID().String()
+	l.WriteLookup(&Lookup{Target: a, Start: 2, Hops: []Hop{{Round: 1, Returned: []TraceNode{{URL: "x", Dist: 250}}}}})
+	l.WriteLookup(&Lookup{Target: a, Start: 1})
+	l.WriteLookup(&Lookup{Target: b, Start: 3})
+
+	if lks := l.Lookups(""); len(lks) != 3 || lks[0].Start != 1 || lks[2].Start != 3 {
+		t.Fatalf("wrong lookups %+v", lks)
+	}
+	lks := l.Lookups(a[:8])
+	if len(lks) != 2 || lks[1].Date != "2022-01-01" || len(lks[1].Hops) != 1 || lks[1].Hops[0].Returned[0].Dist != 250 {
+		t.Fatalf("wrong lookups of %s %+v", a, lks)
+	}
+}